@@ -21,12 +21,12 @@ func runCommands(commands []command) {
 	var cmd, found = findCommand(cmdArgs.name, commands)
 	if !found {
 		fmt.Println("command not found")
-		return
+		os.Exit(2)
 	}
 	var err = cmd.handler(cmdArgs)
 	if err != nil {
 		fmt.Println("command error", err)
-		return
+		os.Exit(1)
 	}
 }
 
@@ -1,10 +1,17 @@
 package main
 
 import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
 	"path"
 	"strconv"
+	"syscall"
+	"text/tabwriter"
 	"time"
 
+	"github.com/ChizhovVadim/assets/core"
 	"github.com/ChizhovVadim/assets/dal"
 	"github.com/ChizhovVadim/assets/reports"
 )
@@ -14,6 +21,12 @@ const micexIndex = "MICEXINDEXCF"
 const MOEXRussiaTotalReturnIndex = "MCFTR"
 const USDCbrf = "USDCB"
 
+var currencyTickers = []string{
+	USDCbrf,
+	"EURCB",
+	"CNYCB",
+}
+
 var etfTickers = []string{
 	"FXUS",
 	"FXDE",
@@ -56,22 +69,77 @@ var msciRussiaTickers = []string{
 
 type controller struct {
 	homeDir               string
+	myTradeStorage        core.MyTradeStorage
+	myDividendStorage     core.MyDividendStorage
+	cashMovementStorage   core.CashMovementStorage
+	tradeImporterRegistry *dal.TradeImporterRegistry
 	historyCandleService  *dal.HistoryCandleService
 	periodReportService   *reports.PeriodReportService
 	dividendReportService *reports.DividendReportService
 	ndflReportService     *reports.NdflReportService
 	quoteReportService    *reports.QuoteReportService
+	bondReportService     *reports.BondReportService
 }
 
 func (c *controller) updateHandler(args commandArgs) error {
-	securityCodes := getTickersByType(c.periodReportService, args.params["type"])
-	return c.historyCandleService.UpdateHistoryCandles(securityCodes)
+	var r = dal.UpdateHistoryCandlesRequest{}
+	r.SecurityCodes = getTickersByType(c.periodReportService, args.params["type"])
+	if workers, err := strconv.Atoi(args.params["workers"]); err == nil {
+		r.Workers = workers
+	}
+	if rps, err := strconv.ParseFloat(args.params["rps"], 64); err == nil {
+		r.RequestsPerSecond = rps
+	}
+
+	ctx, cancel := interruptContext()
+	defer cancel()
+	result, err := c.historyCandleService.UpdateHistoryCandles(ctx, r)
+	printUpdateResult(result)
+	if err != nil {
+		return err
+	}
+	if failed := result.Failed(); failed != 0 {
+		return fmt.Errorf("update failed for %v securities", failed)
+	}
+	return nil
+}
+
+func printUpdateResult(result dal.UpdateResult) {
+	var w = tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', 0)
+	fmt.Fprintf(w, "Security\tAdded\tLast\tSource\tError\t\n")
+	for _, item := range result.Items {
+		var lastDate, errText string
+		if !item.LastDate.IsZero() {
+			lastDate = item.LastDate.Format(dateLayout)
+		}
+		if item.Err != nil {
+			errText = item.Err.Error()
+		}
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t\n",
+			item.SecurityCode, item.CandlesAdded, lastDate, item.Source, errText)
+	}
+	w.Flush()
+}
+
+func interruptContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	var signals = make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case <-signals:
+			cancel()
+		case <-ctx.Done():
+		}
+		signal.Stop(signals)
+	}()
+	return ctx, cancel
 }
 
 func (c *controller) periodHandler(args commandArgs) error {
 	var r = reports.PeriodReportRequest{}
 	r.Brief = true
-	r.Currency = args.params["cur"] // example: "USDCB"
+	r.Currency = args.params["cur"] // example: "USD" or "USDCB"
 	r.Account = args.params["account"]
 	start, err := time.Parse(dateLayout, args.params["start"])
 	if err != nil {
@@ -114,8 +182,13 @@ func (c *controller) ndflHandler(args commandArgs) error {
 	if err != nil {
 		year = time.Now().Year()
 	}
+	method, err := reports.ParseCostBasisMethod(args.params["method"]) // fifo, average, specific
+	if err != nil {
+		return err
+	}
+	var resident = args.params["nonresident"] != "true"
 
-	report, err := c.ndflReportService.BuildNdflReport(year, account)
+	report, err := c.ndflReportService.BuildNdflReport(year, account, method, resident)
 	if err != nil {
 		return err
 	}
@@ -129,8 +202,13 @@ func (c *controller) taxfreeHandler(args commandArgs) error {
 	if err != nil {
 		date = time.Now()
 	}
+	method, err := reports.ParseCostBasisMethod(args.params["method"])
+	if err != nil {
+		return err
+	}
+	var resident = args.params["nonresident"] != "true"
 
-	report, err := c.ndflReportService.BuildPlannedTaxReport(account, date)
+	report, err := c.ndflReportService.BuildPlannedTaxReport(account, date, method, resident)
 	if err != nil {
 		return err
 	}
@@ -139,13 +217,90 @@ func (c *controller) taxfreeHandler(args commandArgs) error {
 }
 
 func (c *controller) importHandler(args commandArgs) error {
-	importTradeService := dal.NewSberbankImportTradeService()
-	tt, err := importTradeService.LoadTrades(path.Join(c.homeDir, "src.txt"))
+	var fileName = args.params["file"]
+	if fileName == "" {
+		fileName = path.Join(c.homeDir, "src.txt")
+	}
+	var options = dal.ImportOptions{
+		AggregateOrders: args.params["aggregate"] == "true",
+	}
+	imported, err := c.tradeImporterRegistry.ImportFile(args.params["broker"], fileName, options)
 	if err != nil {
 		return err
 	}
-	importTradeStorage := dal.NewMyTradeStorage(path.Join(c.homeDir, "dst.txt"))
-	return importTradeStorage.Update(tt)
+	for _, importError := range imported.Errors {
+		fmt.Println("Ошибка разбора", importError)
+	}
+	// при записи чтение, объединение и запись выполняются под блокировкой хранилища
+	var confirm = args.params["confirm"] == "true"
+	var mergeTrades = func(existing []core.MyTrade) []core.MyTrade {
+		var result = dal.MergeMyTrades(existing, imported.Trades)
+		printMyTradeMergeResult(result)
+		if !confirm || len(result.New) == 0 {
+			return nil
+		}
+		return result.Merged
+	}
+	var mergeCash = func(existing []core.CashMovement) []core.CashMovement {
+		merged, added := dal.MergeCashMovements(existing, imported.CashMovements)
+		fmt.Printf("Новые движения денежных средств: %v\n", len(added))
+		if !confirm || len(added) == 0 {
+			return nil
+		}
+		return merged
+	}
+	if !confirm {
+		existing, err := c.myTradeStorage.Read("")
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		mergeTrades(existing)
+		existingCash, err := c.cashMovementStorage.Read("")
+		if err != nil {
+			return err
+		}
+		mergeCash(existingCash)
+		fmt.Printf("Дивиденды: %v\n", len(imported.Dividends))
+		fmt.Println("Пробный запуск, для записи сделок укажите -confirm true")
+		return nil
+	}
+	err = c.myTradeStorage.Update(mergeTrades)
+	if err != nil {
+		return err
+	}
+	err = c.cashMovementStorage.Update(mergeCash)
+	if err != nil {
+		return err
+	}
+	if len(imported.Dividends) != 0 {
+		added, err := c.myDividendStorage.AddReceivedDividends(imported.Dividends)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Добавлено дивидендов: %v\n", added)
+	}
+	return nil
+}
+
+func printMyTradeMergeResult(result dal.MyTradeMergeResult) {
+	fmt.Printf("Новые сделки: %v\n", len(result.New))
+	printMyTrades("+", result.New)
+	fmt.Printf("Дубликаты: %v\n", len(result.Duplicates))
+	fmt.Printf("Конфликты: %v\n", len(result.Conflicts))
+	for _, conflict := range result.Conflicts {
+		printMyTrades("<", []core.MyTrade{conflict.Existing})
+		printMyTrades(">", []core.MyTrade{conflict.Imported})
+	}
+}
+
+func printMyTrades(prefix string, tt []core.MyTrade) {
+	var w = tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', 0)
+	for _, t := range tt {
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t\n",
+			prefix, t.Account, t.DateTime.Format(dateLayout+" 15:04:05"), t.SecurityCode,
+			t.Price, t.Volume, t.ExchangeComission+t.BrokerComission, t.TradeId)
+	}
+	w.Flush()
 }
 
 func (c *controller) quoteHandler(args commandArgs) error {
@@ -171,6 +326,21 @@ func (c *controller) quoteHandler(args commandArgs) error {
 	return nil
 }
 
+func (c *controller) bondsHandler(args commandArgs) error {
+	account := args.params["account"]
+	date, err := time.Parse(dateLayout, args.params["date"])
+	if err != nil {
+		date = today()
+	}
+
+	report, err := c.bondReportService.BuildBondReport(account, date)
+	if err != nil {
+		return err
+	}
+	reports.PrintBondReport(report)
+	return nil
+}
+
 func firstDayOfYear(d time.Time) time.Time {
 	return time.Date(d.Year(), 1, 1, 0, 0, 0, 0, d.Location())
 }
@@ -191,6 +361,8 @@ func getTickersByType(periodReportService *reports.PeriodReportService,
 		return etfTickers
 	case "stock":
 		return msciRussiaTickers
+	case "currency":
+		return currencyTickers
 	}
 	return nil
 }
@@ -24,6 +24,18 @@ type MyTrade struct {
 	ExchangeComission float64
 	BrokerComission   float64
 	Account           string
+	TradeId           string
+	Currency          string
+	AccruedInterest   float64        // НКД по сделке: уплаченный при покупке, полученный при продаже
+	Lots              []LotSelection // лоты, закрываемые сделкой, для учета по конкретным лотам
+	Note              string
+}
+
+// LotSelection часть лота, закрываемая сделкой. Lot - TradeId открывающей сделки
+// или дата ее исполнения (2006-01-02).
+type LotSelection struct {
+	Lot    string
+	Volume int
 }
 
 type DividendSchedule struct {
@@ -34,27 +46,140 @@ type DividendSchedule struct {
 }
 
 type ReceivedDividend struct {
-	Account string
-	Date    time.Time
-	Sum     float64
+	Account  string
+	Date     time.Time
+	Sum      float64
+	Tax      float64
+	Currency string
+}
+
+type CashMovementKind string
+
+const (
+	CashDeposit    CashMovementKind = "deposit"
+	CashWithdrawal CashMovementKind = "withdrawal"
+	CashDividend   CashMovementKind = "dividend"
+	CashCoupon     CashMovementKind = "coupon"
+	CashFee        CashMovementKind = "fee"
+	CashTax        CashMovementKind = "tax"
+	CashInterest   CashMovementKind = "interest"
+	CashTransfer   CashMovementKind = "transfer"
+	CashRedemption CashMovementKind = "redemption" // погашение и амортизация номинала облигаций
+	CashOther      CashMovementKind = "other"
+)
+
+// CashMovement движение денежных средств по счету. Sum > 0 - зачисление, Sum < 0 - списание.
+type CashMovement struct {
+	Account      string
+	Date         time.Time
+	Kind         CashMovementKind
+	Sum          float64
+	Currency     string
+	SecurityCode string
+	Note         string
+}
+
+// SecurityTransfer перевод бумаг со счета FromAccount на счет ToAccount.
+// Лоты переходят с исходными ценами и датами открытия.
+type SecurityTransfer struct {
+	Date         time.Time
+	SecurityCode string
+	Volume       int
+	FromAccount  string
+	ToAccount    string
+	Note         string
+}
+
+type CorporateActionKind string
+
+const (
+	CorporateActionSplit      CorporateActionKind = "split"      // дробление и консолидация
+	CorporateActionConversion CorporateActionKind = "conversion" // конвертация в бумаги другого выпуска
+	CorporateActionSpinOff    CorporateActionKind = "spinoff"    // выделение: исходные бумаги остаются
+	CorporateActionExchange   CorporateActionKind = "exchange"   // обмен, слияние: исходные бумаги погашаются
+)
+
+// CorporateAction каждые From бумаг SecurityCode на дату Date превращаются в To бумаг
+// NewSecurityCode (при дроблении - той же бумаги).
+// Для выделения и обмена бумаги, которые получает владелец, перечислены в Results,
+// Cash - деньги на одну исходную бумагу. Taxable - обмен облагается как продажа и покупка.
+type CorporateAction struct {
+	Date            time.Time
+	Kind            CorporateActionKind
+	SecurityCode    string
+	NewSecurityCode string
+	From            float64
+	To              float64
+	Results         []CorporateActionResult
+	Cash            float64
+	Taxable         bool
+	Note            string
+}
+
+// CorporateActionResult каждые From исходных бумаг дают To бумаг SecurityCode.
+// CostShare - доля стоимости исходных бумаг, переходящая в новые. Если доли не заданы,
+// стоимость распределяется пропорционально рыночной стоимости (Price или цена по свечам).
+type CorporateActionResult struct {
+	SecurityCode string
+	From         float64
+	To           float64
+	CostShare    float64
+	Price        float64
 }
 
 type SecurityInfo struct {
-	SecurityCode string `xml:"Name,attr"`
-	Title        string `xml:",attr"`
-	Number       string `xml:",attr"`
-	FinamCode    int    `xml:",attr"`
-	Sector       string `xml:",attr"`
+	SecurityCode string    `xml:"Name,attr"`
+	Title        string    `xml:",attr"`
+	Number       string    `xml:",attr"`
+	FinamCode    int       `xml:",attr"`
+	Sector       string    `xml:",attr"`
+	Market       string    `xml:",attr"`
+	Board        string    `xml:",attr"`
+	Isin         string    `xml:",attr"`
+	LotSize      int       `xml:",attr"`
+	Currency     string    `xml:",attr"` // валюта цены, пусто - рубли
+	Bond         *BondInfo `xml:"-"`
+}
+
+// BondInfo параметры облигации. Цены сделок и свечей облигации - в процентах от номинала.
+type BondInfo struct {
+	FaceValue     float64 // начальный номинал
+	IssueDate     time.Time
+	Maturity      time.Time
+	Government    bool // ОФЗ и муниципальные облигации
+	Coupons       []BondPayment
+	Amortizations []BondPayment
+}
+
+// BondPayment выплата на одну облигацию: купон или часть номинала.
+type BondPayment struct {
+	Date time.Time
+	Sum  float64
 }
 
 type MyTradeStorage interface {
 	Read(account string) ([]MyTrade, error)
-	Update(trades []MyTrade) error
+	// Update изменяет все сделки под блокировкой хранилища, nil - без изменений.
+	Update(update func(trades []MyTrade) []MyTrade) error
+}
+
+type CashMovementStorage interface {
+	Read(account string) ([]CashMovement, error)
+	Update(update func(items []CashMovement) []CashMovement) error
+}
+
+type SecurityTransferStorage interface {
+	Read() ([]SecurityTransfer, error)
+}
+
+type CorporateActionStorage interface {
+	Read() ([]CorporateAction, error)
 }
 
 type MyDividendStorage interface {
 	ReadReceivedDividends(account string, start, finish time.Time) ([]ReceivedDividend, error)
 	Read() ([]DividendSchedule, error)
+	AddReceivedDividends(items []DividendSchedule) (int, error)
 }
 
 type HistoryCandleStorage interface {
@@ -71,4 +196,5 @@ type SecurityInfoStorage interface {
 
 type SecurityInfoDirectory interface {
 	Read(securityCode string) (SecurityInfo, bool)
+	FindByIsin(isin string) (SecurityInfo, bool)
 }
@@ -0,0 +1,95 @@
+package dal
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+const backupFileSuffix = ".bak"
+
+// writeFileAtomic пишет во временный файл рядом с path, делает fsync и атомарно
+// заменяет path. Предыдущая версия файла сохраняется в path.bak.
+func writeFileAtomic(path string, write func(w io.Writer) error) (err error) {
+	var dir = filepath.Dir(path)
+	tmp, err := ioutil.TempFile(dir, "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+	var buf = bufio.NewWriter(tmp)
+	if err = write(buf); err != nil {
+		return err
+	}
+	if err = buf.Flush(); err != nil {
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	if info, statErr := os.Stat(path); statErr == nil {
+		if err = os.Chmod(tmp.Name(), info.Mode()); err != nil {
+			return err
+		}
+		if err = backupFile(path); err != nil {
+			return err
+		}
+	}
+	if err = os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+func backupFile(path string) error {
+	var backupPath = path + backupFileSuffix
+	if err := os.Remove(backupPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Link(path, backupPath); err == nil {
+		return nil
+	}
+	return copyFile(path, backupPath)
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err = out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	// на некоторых платформах fsync каталога не поддерживается
+	d.Sync()
+	return nil
+}
@@ -0,0 +1,134 @@
+package dal
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeTestFile(path, content string) error {
+	return writeFileAtomic(path, func(w io.Writer) error {
+		_, err := io.WriteString(w, content)
+		return err
+	})
+}
+
+func checkFileContent(t *testing.T, path, want string) {
+	t.Helper()
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if string(data) != want {
+		t.Errorf("%v: got %q, want %q", filepath.Base(path), data, want)
+	}
+}
+
+func checkDirFiles(t *testing.T, dir string, want ...string) {
+	t.Helper()
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, info := range infos {
+		names = append(names, info.Name())
+	}
+	if len(names) != len(want) {
+		t.Errorf("got files %v, want %v", names, want)
+		return
+	}
+	for i := range want {
+		if names[i] != want[i] {
+			t.Errorf("got files %v, want %v", names, want)
+			return
+		}
+	}
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	var dir = t.TempDir()
+	var path = filepath.Join(dir, "trades.csv")
+
+	if err := writeTestFile(path, "v1"); err != nil {
+		t.Fatal(err)
+	}
+	checkFileContent(t, path, "v1")
+	checkDirFiles(t, dir, "trades.csv")
+
+	if err := os.Chmod(path, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeTestFile(path, "v2"); err != nil {
+		t.Fatal(err)
+	}
+	checkFileContent(t, path, "v2")
+	checkFileContent(t, path+backupFileSuffix, "v1")
+	checkDirFiles(t, dir, "trades.csv", "trades.csv.bak")
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("file mode not preserved: %v %v", info.Mode(), err)
+	}
+
+	if err := writeTestFile(path, "v3"); err != nil {
+		t.Fatal(err)
+	}
+	checkFileContent(t, path, "v3")
+	checkFileContent(t, path+backupFileSuffix, "v2")
+}
+
+func TestWriteFileAtomicFailure(t *testing.T) {
+	var dir = t.TempDir()
+	var path = filepath.Join(dir, "trades.csv")
+	for _, content := range []string{"backup", "original"} {
+		if err := writeTestFile(path, content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var failed = errors.New("write failed")
+	var err = writeFileAtomic(path, func(w io.Writer) error {
+		io.WriteString(w, "partial")
+		return failed
+	})
+	if err != failed {
+		t.Errorf("got error %v, want %v", err, failed)
+	}
+	checkFileContent(t, path, "original")
+	// временный файл удален, резервная копия не перезаписана
+	checkFileContent(t, path+backupFileSuffix, "backup")
+	checkDirFiles(t, dir, "trades.csv", "trades.csv.bak")
+}
+
+func TestLockFile(t *testing.T) {
+	var path = filepath.Join(t.TempDir(), "trades.csv")
+	unlock, err := lockFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var locked = make(chan struct{})
+	go func() {
+		unlock, err := lockFile(path)
+		if err != nil {
+			t.Error(err)
+			close(locked)
+			return
+		}
+		close(locked)
+		unlock()
+	}()
+	select {
+	case <-locked:
+		t.Fatal("lock acquired twice")
+	case <-time.After(100 * time.Millisecond):
+	}
+	unlock()
+	select {
+	case <-locked:
+	case <-time.After(5 * time.Second):
+		t.Fatal("lock not acquired after unlock")
+	}
+}
@@ -0,0 +1,602 @@
+package dal
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ChizhovVadim/assets/core"
+)
+
+// reportTable таблица из отчета брокера (HTML, XML или лист XLSX).
+type reportTable struct {
+	title  string
+	header []string
+	rows   []reportRow
+}
+
+type reportRow struct {
+	line  int
+	cells []string
+}
+
+type reportColumnIndex map[string]int
+
+func newReportColumnIndex(header []string) reportColumnIndex {
+	var result = make(reportColumnIndex)
+	for i, name := range header {
+		var key = normalizeReportText(name)
+		if _, found := result[key]; !found {
+			result[key] = i
+		}
+	}
+	return result
+}
+
+// lookup возвращает индекс первой найденной колонки из aliases или -1.
+func (columns reportColumnIndex) lookup(aliases ...string) int {
+	for _, alias := range aliases {
+		if index, found := columns[normalizeReportText(alias)]; found {
+			return index
+		}
+	}
+	return -1
+}
+
+func (row reportRow) get(index int) string {
+	if index < 0 || index >= len(row.cells) {
+		return ""
+	}
+	return row.cells[index]
+}
+
+var reportSpaces = regexp.MustCompile(`[\s\x{00A0}]+`)
+
+func normalizeReportText(s string) string {
+	return strings.ToLower(strings.TrimSpace(reportSpaces.ReplaceAllString(s, " ")))
+}
+
+func parseReportFloat(s string) (float64, error) {
+	s = reportSpaces.ReplaceAllString(s, "")
+	if s == "" || s == "-" {
+		return 0, nil
+	}
+	s = strings.Replace(s, ",", ".", -1)
+	return strconv.ParseFloat(s, 64)
+}
+
+func parseReportInt(s string) (int, error) {
+	v, err := parseReportFloat(s)
+	if err != nil {
+		return 0, err
+	}
+	if v != float64(int(v)) {
+		return 0, fmt.Errorf("not integer %v", s)
+	}
+	return int(v), nil
+}
+
+var reportDateLayouts = []string{
+	"02.01.2006 15:04:05",
+	"02.01.2006 15:04",
+	"02.01.2006",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+	"02/01/2006 15:04:05",
+	"02/01/2006",
+	"20060102",
+	"02.01.06",
+}
+
+func parseReportDate(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	for _, layout := range reportDateLayouts {
+		if d, err := time.Parse(layout, s); err == nil {
+			return d, nil
+		}
+	}
+	// в XLSX дата может храниться числом дней с 1899-12-30
+	if v, err := strconv.ParseFloat(s, 64); err == nil && v > 20000 && v < 80000 {
+		var d = time.Date(1899, time.December, 30, 0, 0, 0, 0, time.UTC)
+		return d.Add(time.Duration(v * 24 * float64(time.Hour))).Round(time.Second), nil
+	}
+	return time.Time{}, fmt.Errorf("parse date %q", s)
+}
+
+// parseReportDateTime объединяет дату и необязательное время из разных колонок.
+func parseReportDateTime(date, clock string) (time.Time, error) {
+	d, err := parseReportDate(date)
+	if err != nil {
+		return time.Time{}, err
+	}
+	clock = strings.TrimSpace(clock)
+	if clock == "" {
+		return d, nil
+	}
+	for _, layout := range []string{"15:04:05", "15:04", "150405"} {
+		if t, err := time.Parse(layout, clock); err == nil {
+			return d.Add(time.Duration(t.Hour())*time.Hour +
+				time.Duration(t.Minute())*time.Minute +
+				time.Duration(t.Second())*time.Second), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("parse time %q", clock)
+}
+
+var isinRegexp = regexp.MustCompile(`\b[A-Z]{2}[A-Z0-9]{9}[0-9]\b`)
+
+func findIsin(s string) string {
+	return isinRegexp.FindString(s)
+}
+
+func lineAt(text string, offset int64) int {
+	if offset > int64(len(text)) {
+		offset = int64(len(text))
+	}
+	return strings.Count(text[:offset], "\n") + 1
+}
+
+func isHtmlReport(text string) bool {
+	var head = strings.ToLower(text)
+	if len(head) > importSniffSize {
+		head = head[:importSniffSize]
+	}
+	return strings.Contains(head, "<html") || strings.Contains(head, "<table")
+}
+
+var htmlIgnoredBlocks = regexp.MustCompile(`(?is)<(script|style)[^>]*>.*?</(script|style)>`)
+
+// parseHtmlTables извлекает все таблицы HTML документа.
+// Заголовок таблицы - последний текст перед ней, шапка - первая строка из нескольких ячеек.
+func parseHtmlTables(text string) ([]reportTable, error) {
+	text = htmlIgnoredBlocks.ReplaceAllStringFunc(text, func(s string) string {
+		// сохраняем переводы строк для нумерации
+		return strings.Repeat("\n", strings.Count(s, "\n"))
+	})
+	var decoder = xml.NewDecoder(strings.NewReader(text))
+	decoder.Strict = false
+	decoder.AutoClose = xml.HTMLAutoClose
+	decoder.Entity = xml.HTMLEntity
+	decoder.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) {
+		return input, nil
+	}
+
+	type tableState struct {
+		title string
+		rows  []reportRow
+		row   *reportRow
+		cell  *strings.Builder
+	}
+	var result []reportTable
+	var stack []*tableState
+	var lastText string
+	for {
+		var offset = decoder.InputOffset()
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		var current *tableState
+		if len(stack) > 0 {
+			current = stack[len(stack)-1]
+		}
+		switch token := token.(type) {
+		case xml.StartElement:
+			switch strings.ToLower(token.Name.Local) {
+			case "table":
+				stack = append(stack, &tableState{title: lastText})
+			case "tr":
+				if current != nil {
+					current.row = &reportRow{line: lineAt(text, offset)}
+				}
+			case "td", "th":
+				if current != nil && current.row != nil {
+					current.cell = &strings.Builder{}
+				}
+			case "br", "p", "div":
+				if current != nil && current.cell != nil {
+					current.cell.WriteString(" ")
+				}
+			}
+		case xml.EndElement:
+			switch strings.ToLower(token.Name.Local) {
+			case "table":
+				if current != nil {
+					stack = stack[:len(stack)-1]
+					result = append(result, newReportTable(current.title, current.rows))
+				}
+			case "tr":
+				if current != nil && current.row != nil {
+					current.rows = append(current.rows, *current.row)
+					current.row = nil
+				}
+			case "td", "th":
+				if current != nil && current.row != nil && current.cell != nil {
+					current.row.cells = append(current.row.cells,
+						strings.TrimSpace(reportSpaces.ReplaceAllString(current.cell.String(), " ")))
+					current.cell = nil
+				}
+			}
+		case xml.CharData:
+			if current != nil && current.cell != nil {
+				current.cell.Write(token)
+			} else if current == nil {
+				if s := strings.TrimSpace(reportSpaces.ReplaceAllString(string(token), " ")); s != "" {
+					lastText = s
+				}
+			}
+		}
+	}
+	return result, nil
+}
+
+func newReportTable(title string, rows []reportRow) reportTable {
+	var table = reportTable{title: title}
+	for i, row := range rows {
+		var nonEmpty = 0
+		for _, cell := range row.cells {
+			if cell != "" {
+				nonEmpty++
+			}
+		}
+		if nonEmpty >= 2 {
+			table.header = row.cells
+			table.rows = rows[i+1:]
+			break
+		}
+		if nonEmpty == 1 && table.title == "" {
+			table.title = strings.Join(row.cells, "")
+		}
+	}
+	return table
+}
+
+type xmlReportNode struct {
+	XMLName xml.Name
+	Attrs   []xml.Attr      `xml:",any,attr"`
+	Nodes   []xmlReportNode `xml:",any"`
+	Text    string          `xml:",chardata"`
+}
+
+// parseXmlTables превращает XML отчет в таблицы: каждая группа одноименных
+// дочерних элементов-записей становится таблицей, колонки - атрибуты и простые
+// дочерние элементы записи. Номер строки - порядковый номер записи в группе.
+func parseXmlTables(text string) ([]reportTable, error) {
+	var decoder = xml.NewDecoder(strings.NewReader(text))
+	decoder.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) {
+		return input, nil
+	}
+	var root xmlReportNode
+	var err = decoder.Decode(&root)
+	if err != nil {
+		return nil, err
+	}
+	var result []reportTable
+	collectXmlTables(&root, &result)
+	return result, nil
+}
+
+func collectXmlTables(node *xmlReportNode, result *[]reportTable) {
+	var tables = make(map[string]*reportTable)
+	var columns = make(map[string]reportColumnIndex)
+	var order []string
+	for i := range node.Nodes {
+		var child = &node.Nodes[i]
+		if !isXmlRecord(child) {
+			collectXmlTables(child, result)
+			continue
+		}
+		var name = child.XMLName.Local
+		var table, found = tables[name]
+		if !found {
+			table = &reportTable{title: node.XMLName.Local}
+			tables[name] = table
+			columns[name] = make(reportColumnIndex)
+			order = append(order, name)
+		}
+		var index = columns[name]
+		var row = reportRow{line: len(table.rows) + 1}
+		var set = func(column, value string) {
+			var i, found = index[column]
+			if !found {
+				i = len(table.header)
+				index[column] = i
+				table.header = append(table.header, column)
+			}
+			for len(row.cells) <= i {
+				row.cells = append(row.cells, "")
+			}
+			row.cells[i] = strings.TrimSpace(value)
+		}
+		for _, attr := range child.Attrs {
+			set(attr.Name.Local, attr.Value)
+		}
+		for _, field := range child.Nodes {
+			set(field.XMLName.Local, field.Text)
+		}
+		table.rows = append(table.rows, row)
+	}
+	for _, name := range order {
+		*result = append(*result, *tables[name])
+	}
+}
+
+// isXmlRecord запись - элемент с атрибутами или с дочерними элементами без вложенности.
+func isXmlRecord(node *xmlReportNode) bool {
+	if len(node.Nodes) == 0 {
+		return len(node.Attrs) > 0
+	}
+	for i := range node.Nodes {
+		if len(node.Nodes[i].Nodes) > 0 || len(node.Nodes[i].Attrs) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// reportTradeColumns варианты названий колонок таблицы сделок в отчете брокера.
+type reportTradeColumns struct {
+	date              []string
+	time              []string
+	settlementDate    []string
+	isin              []string
+	securityCode      []string
+	securityName      []string
+	side              []string
+	volume            []string
+	price             []string
+	currency          []string
+	brokerComission   []string
+	exchangeComission []string
+	clearingComission []string
+	accruedInterest   []string
+	tradeId           []string
+	account           []string
+}
+
+func (names *reportTradeColumns) match(columns reportColumnIndex) bool {
+	return columns.lookup(names.date...) != -1 &&
+		columns.lookup(names.price...) != -1 &&
+		columns.lookup(names.volume...) != -1
+}
+
+func parseReportTrade(securityInfoDirectory core.SecurityInfoDirectory, row reportRow,
+	columns reportColumnIndex, names *reportTradeColumns) (core.MyTrade, error) {
+	d, err := parseReportDateTime(row.get(columns.lookup(names.date...)),
+		row.get(columns.lookup(names.time...)))
+	if err != nil {
+		return core.MyTrade{}, err
+	}
+	var executionDate = date(d)
+	if s := row.get(columns.lookup(names.settlementDate...)); s != "" {
+		executionDate, err = parseReportDate(s)
+		if err != nil {
+			return core.MyTrade{}, err
+		}
+	}
+	securityCode, err := resolveSecurityCode(securityInfoDirectory,
+		row.get(columns.lookup(names.isin...)),
+		row.get(columns.lookup(names.securityCode...)),
+		row.get(columns.lookup(names.securityName...)))
+	if err != nil {
+		return core.MyTrade{}, err
+	}
+	volume, err := parseReportInt(row.get(columns.lookup(names.volume...)))
+	if err != nil {
+		return core.MyTrade{}, err
+	}
+	if volume < 0 {
+		volume = -volume
+	}
+	sell, err := parseTradeSide(row.get(columns.lookup(names.side...)))
+	if err != nil {
+		return core.MyTrade{}, err
+	}
+	if sell {
+		volume = -volume
+	}
+	price, err := parseReportFloat(row.get(columns.lookup(names.price...)))
+	if err != nil {
+		return core.MyTrade{}, err
+	}
+	brokerComission, err := parseReportFloat(row.get(columns.lookup(names.brokerComission...)))
+	if err != nil {
+		return core.MyTrade{}, err
+	}
+	exchangeComission, err := parseReportFloat(row.get(columns.lookup(names.exchangeComission...)))
+	if err != nil {
+		return core.MyTrade{}, err
+	}
+	clearingComission, err := parseReportFloat(row.get(columns.lookup(names.clearingComission...)))
+	if err != nil {
+		return core.MyTrade{}, err
+	}
+	accruedInterest, err := parseReportFloat(row.get(columns.lookup(names.accruedInterest...)))
+	if err != nil {
+		return core.MyTrade{}, err
+	}
+	return core.MyTrade{
+		SecurityCode:      securityCode,
+		DateTime:          d,
+		ExecutionDate:     executionDate,
+		Price:             price,
+		Volume:            volume,
+		ExchangeComission: roundComission(math.Abs(exchangeComission) + math.Abs(clearingComission)),
+		BrokerComission:   math.Abs(brokerComission),
+		AccruedInterest:   math.Abs(accruedInterest),
+		TradeId:           row.get(columns.lookup(names.tradeId...)),
+		Currency:          normalizeCurrency(row.get(columns.lookup(names.currency...))),
+	}, nil
+}
+
+func roundComission(v float64) float64 {
+	return math.Round(v*1e6) / 1e6
+}
+
+// reportCashColumns варианты названий колонок таблицы движения денежных средств.
+type reportCashColumns struct {
+	date      []string
+	operation []string
+	note      []string
+	currency  []string
+	credit    []string
+	debit     []string
+	sum       []string
+	account   []string
+}
+
+func (names *reportCashColumns) match(columns reportColumnIndex) bool {
+	return columns.lookup(names.date...) != -1 &&
+		columns.lookup(names.operation...) != -1 &&
+		(columns.lookup(names.credit...) != -1 || columns.lookup(names.sum...) != -1)
+}
+
+// parseReportCashMovement возвращает false для строк, которые не нужно импортировать
+// (расчеты по сделкам, нулевые суммы).
+func parseReportCashMovement(securityInfoDirectory core.SecurityInfoDirectory, row reportRow,
+	columns reportColumnIndex, names *reportCashColumns) (core.CashMovement, bool, error) {
+	var operation = row.get(columns.lookup(names.operation...))
+	var kind, ok = reportCashMovementKind(operation)
+	if !ok {
+		return core.CashMovement{}, false, nil
+	}
+	d, err := parseReportDate(row.get(columns.lookup(names.date...)))
+	if err != nil {
+		return core.CashMovement{}, false, err
+	}
+	var sum float64
+	if index := columns.lookup(names.credit...); index != -1 {
+		credit, err := parseReportFloat(row.get(index))
+		if err != nil {
+			return core.CashMovement{}, false, err
+		}
+		debit, err := parseReportFloat(row.get(columns.lookup(names.debit...)))
+		if err != nil {
+			return core.CashMovement{}, false, err
+		}
+		sum = math.Abs(credit) - math.Abs(debit)
+	} else {
+		sum, err = parseReportFloat(row.get(columns.lookup(names.sum...)))
+		if err != nil {
+			return core.CashMovement{}, false, err
+		}
+	}
+	if sum == 0 {
+		return core.CashMovement{}, false, nil
+	}
+	if kind == core.CashDeposit && sum < 0 {
+		kind = core.CashWithdrawal
+	}
+	var note = strings.TrimSpace(operation + " " + row.get(columns.lookup(names.note...)))
+	var m = core.CashMovement{
+		Date:     d,
+		Kind:     kind,
+		Sum:      sum,
+		Currency: normalizeCurrency(row.get(columns.lookup(names.currency...))),
+		Note:     note,
+	}
+	if kind == core.CashDividend || kind == core.CashCoupon || kind == core.CashTax ||
+		kind == core.CashRedemption {
+		if isin := findIsin(note); isin != "" {
+			if info, found := securityInfoDirectory.FindByIsin(isin); found {
+				m.SecurityCode = info.SecurityCode
+			}
+		}
+	}
+	return m, true, nil
+}
+
+// reportCashMovementKind классифицирует операцию по описанию.
+// Расчеты по сделкам и комиссии за сделки уже учтены в сделках и пропускаются.
+func reportCashMovementKind(operation string) (core.CashMovementKind, bool) {
+	var s = strings.ToLower(operation)
+	switch {
+	case strings.Contains(s, "сделк") && !strings.Contains(s, "комисс"),
+		strings.HasPrefix(s, "покупка") || strings.HasPrefix(s, "продажа"):
+		return "", false
+	case strings.Contains(s, "комисс") &&
+		(strings.Contains(s, "сделк") || strings.Contains(s, "брокер") || strings.Contains(s, "бирж")):
+		return "", false
+	case strings.Contains(s, "дивиденд"):
+		return core.CashDividend, true
+	case strings.Contains(s, "купон"):
+		return core.CashCoupon, true
+	case strings.Contains(s, "погашени") || strings.Contains(s, "амортизац"):
+		return core.CashRedemption, true
+	case strings.Contains(s, "ндфл") || strings.Contains(s, "налог"):
+		return core.CashTax, true
+	case strings.Contains(s, "комисс") || strings.Contains(s, "плата"):
+		return core.CashFee, true
+	case strings.Contains(s, "вывод") || strings.Contains(s, "списание д/с") || strings.Contains(s, "возврат"):
+		return core.CashWithdrawal, true
+	case strings.Contains(s, "зачислен") || strings.Contains(s, "ввод") || strings.Contains(s, "пополнен"):
+		return core.CashDeposit, true
+	}
+	return core.CashOther, true
+}
+
+func isReportTotalRow(row reportRow) bool {
+	for _, cell := range row.cells {
+		if cell == "" {
+			continue
+		}
+		var s = strings.ToLower(cell)
+		return strings.HasPrefix(s, "итого") || strings.HasPrefix(s, "всего")
+	}
+	return true
+}
+
+func parseTradeSide(side string) (bool, error) {
+	var s = strings.ToLower(strings.TrimSpace(side))
+	switch {
+	case strings.HasPrefix(s, "покуп") || strings.HasPrefix(s, "купл") || s == "buy" || s == "b" || s == "к":
+		return false, nil
+	case strings.HasPrefix(s, "прод") || s == "sell" || s == "s" || s == "п":
+		return true, nil
+	}
+	return false, fmt.Errorf("unknown trade side %q", side)
+}
+
+func normalizeCurrency(currency string) string {
+	var s = strings.ToUpper(strings.TrimSpace(currency))
+	switch s {
+	case "RUR", "РУБ", "РУБ.", "РУБЛЬ":
+		return "RUB"
+	}
+	return s
+}
+
+// resolveSecurityCode определяет SecurityCode по ISIN, затем по тикеру.
+// Неизвестный справочнику тикер используется как есть.
+func resolveSecurityCode(securityInfoDirectory core.SecurityInfoDirectory,
+	isin, ticker, name string) (string, error) {
+	if isin = strings.TrimSpace(isin); isin != "" {
+		if info, found := securityInfoDirectory.FindByIsin(isin); found {
+			return info.SecurityCode, nil
+		}
+	}
+	if ticker = strings.TrimSpace(ticker); ticker != "" {
+		if info, found := securityInfoDirectory.Read(ticker); found {
+			return info.SecurityCode, nil
+		}
+		return ticker, nil
+	}
+	return "", fmt.Errorf("security not found isin=%v name=%v", isin, name)
+}
+
+// mapAccount возвращает Account для номера договора (счета) брокера.
+func mapAccount(accounts map[string]string, contract string) string {
+	if account, found := accounts[contract]; found {
+		return account
+	}
+	return contract
+}
@@ -0,0 +1,126 @@
+package dal
+
+import (
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ChizhovVadim/assets/core"
+)
+
+type testSecurityInfoDirectory []core.SecurityInfo
+
+func (d testSecurityInfoDirectory) Read(securityCode string) (core.SecurityInfo, bool) {
+	for _, info := range d {
+		if info.SecurityCode == securityCode {
+			return info, true
+		}
+	}
+	return core.SecurityInfo{}, false
+}
+
+func (d testSecurityInfoDirectory) FindByIsin(isin string) (core.SecurityInfo, bool) {
+	for _, info := range d {
+		if isin != "" && info.Isin == isin {
+			return info, true
+		}
+	}
+	return core.SecurityInfo{}, false
+}
+
+// testBrokerSecurities справочник для обезличенных отчетов из testdata/brokerreports.
+var testBrokerSecurities = testSecurityInfoDirectory{
+	{SecurityCode: "SBER", Isin: "RU0009029540", LotSize: 10},
+	{SecurityCode: "GAZP", Isin: "RU0007661625", LotSize: 10},
+	{SecurityCode: "OFZ26207", Isin: "SU26207RMFS9", LotSize: 1},
+	{SecurityCode: "AAPL", Isin: "US0378331005", Currency: "USD"},
+}
+
+func readBrokerReport(t *testing.T, name string) []byte {
+	data, err := ioutil.ReadFile(filepath.Join("testdata", "brokerreports", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func testDate(year int, month time.Month, day int) time.Time {
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+func testDateTime(year int, month time.Month, day, hour, min, sec int) time.Time {
+	return time.Date(year, month, day, hour, min, sec, 0, time.UTC)
+}
+
+func sameFloat(a, b float64) bool {
+	return math.Abs(a-b) < 1e-6
+}
+
+func checkImportedTrades(t *testing.T, got, want []core.MyTrade) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Errorf("got %v trades, want %v: %+v", len(got), len(want), got)
+		return
+	}
+	for i := range want {
+		var g, w = got[i], want[i]
+		if g.SecurityCode != w.SecurityCode || !g.DateTime.Equal(w.DateTime) ||
+			!g.ExecutionDate.Equal(w.ExecutionDate) || !sameFloat(g.Price, w.Price) || g.Volume != w.Volume ||
+			!sameFloat(g.ExchangeComission, w.ExchangeComission) || !sameFloat(g.BrokerComission, w.BrokerComission) ||
+			!sameFloat(g.AccruedInterest, w.AccruedInterest) || g.Account != w.Account ||
+			g.TradeId != w.TradeId || g.Currency != w.Currency || g.Note != w.Note {
+			t.Errorf("trade %v:\n got %+v\nwant %+v", i, g, w)
+		}
+	}
+}
+
+func checkImportedCashMovements(t *testing.T, got, want []core.CashMovement) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Errorf("got %v cash movements, want %v: %+v", len(got), len(want), got)
+		return
+	}
+	for i := range want {
+		var g, w = got[i], want[i]
+		if g.Account != w.Account || !g.Date.Equal(w.Date) || g.Kind != w.Kind || !sameFloat(g.Sum, w.Sum) ||
+			g.Currency != w.Currency || g.SecurityCode != w.SecurityCode {
+			t.Errorf("cash movement %v:\n got %+v\nwant %+v", i, g, w)
+		}
+	}
+}
+
+func checkImportedDividends(t *testing.T, got, want []core.DividendSchedule) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Errorf("got %v dividends, want %v: %+v", len(got), len(want), got)
+		return
+	}
+	for i := range want {
+		var g, w = got[i], want[i]
+		if g.SecurityCode != w.SecurityCode || !g.RecordDate.Equal(w.RecordDate) || g.ReceivedDividend == nil {
+			t.Errorf("dividend %v:\n got %+v\nwant %+v", i, g, w)
+			continue
+		}
+		var gr, wr = *g.ReceivedDividend, *w.ReceivedDividend
+		if gr.Account != wr.Account || !gr.Date.Equal(wr.Date) || !sameFloat(gr.Sum, wr.Sum) ||
+			!sameFloat(gr.Tax, wr.Tax) || gr.Currency != wr.Currency {
+			t.Errorf("received dividend %v:\n got %+v\nwant %+v", i, gr, wr)
+		}
+	}
+}
+
+// checkImportErrors номера строк с ошибками разбора.
+func checkImportErrors(t *testing.T, got []ImportError, want []int) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Errorf("got errors %v, want lines %v", got, want)
+		return
+	}
+	for i := range want {
+		if got[i].Line != want[i] {
+			t.Errorf("error %v: got %v, want line %v", i, got[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package dal
+
+import (
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ChizhovVadim/assets/core"
+)
+
+// cachedHistoryCandleStorage хранит прочитанные свечи в памяти.
+// Кэш бумаги сбрасывается при Update, изменения файлов в обход хранилища не отслеживаются.
+type cachedHistoryCandleStorage struct {
+	storage     *historyCandleStorage
+	mu          sync.Mutex
+	series      map[string][]core.HistoryCandle
+	generations map[string]int
+}
+
+func NewCachedHistoryCandleStorage(storage *historyCandleStorage) *cachedHistoryCandleStorage {
+	return &cachedHistoryCandleStorage{
+		storage:     storage,
+		series:      make(map[string][]core.HistoryCandle),
+		generations: make(map[string]int),
+	}
+}
+
+func (srv *cachedHistoryCandleStorage) Read(securityCode string) ([]core.HistoryCandle, error) {
+	var cc, err = srv.load(securityCode)
+	if err != nil {
+		return nil, err
+	}
+	var result = make([]core.HistoryCandle, len(cc))
+	copy(result, cc)
+	return result, nil
+}
+
+func (srv *cachedHistoryCandleStorage) CandleBeforeDate(securityCode string, date time.Time) (core.HistoryCandle, error) {
+	var cc, err = srv.load(securityCode)
+	if err != nil {
+		return core.HistoryCandle{}, err
+	}
+	var index = sort.Search(len(cc), func(i int) bool {
+		return !cc[i].DateTime.Before(date)
+	}) - 1
+	if index == -1 {
+		return core.HistoryCandle{}, core.ErrNoData
+	}
+	return cc[index], nil
+}
+
+func (srv *cachedHistoryCandleStorage) CandleByDate(securityCode string, date time.Time) (core.HistoryCandle, error) {
+	var cc, err = srv.load(securityCode)
+	if err != nil {
+		return core.HistoryCandle{}, err
+	}
+	var index = sort.Search(len(cc), func(i int) bool {
+		return cc[i].DateTime.After(date)
+	}) - 1
+	if index == -1 {
+		return core.HistoryCandle{}, core.ErrNoData
+	}
+	return cc[index], nil
+}
+
+func (srv *cachedHistoryCandleStorage) Last(securityCode string) (core.HistoryCandle, error) {
+	var cc, err = srv.load(securityCode)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return core.HistoryCandle{}, core.ErrNoData
+		}
+		return core.HistoryCandle{}, err
+	}
+	if len(cc) == 0 {
+		return core.HistoryCandle{}, core.ErrNoData
+	}
+	return cc[len(cc)-1], nil
+}
+
+func (srv *cachedHistoryCandleStorage) Update(securityCode string, candles []core.HistoryCandle) error {
+	var err = srv.storage.Update(securityCode, candles)
+	srv.mu.Lock()
+	delete(srv.series, securityCode)
+	srv.generations[securityCode]++
+	srv.mu.Unlock()
+	return err
+}
+
+// load возвращает свечи из кэша, при промахе читает файл.
+// Если во время чтения прошел Update, прочитанные свечи в кэш не попадают.
+func (srv *cachedHistoryCandleStorage) load(securityCode string) ([]core.HistoryCandle, error) {
+	srv.mu.Lock()
+	var candles, found = srv.series[securityCode]
+	var generation = srv.generations[securityCode]
+	srv.mu.Unlock()
+	if found {
+		return candles, nil
+	}
+	candles, err := srv.storage.readAll(securityCode)
+	if err != nil {
+		return nil, err
+	}
+	srv.mu.Lock()
+	if srv.generations[securityCode] == generation {
+		srv.series[securityCode] = candles
+	}
+	srv.mu.Unlock()
+	return candles, nil
+}
@@ -0,0 +1,95 @@
+package dal
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ChizhovVadim/assets/core"
+)
+
+func testCandle(year int, month time.Month, day int, c float64) core.HistoryCandle {
+	return core.HistoryCandle{DateTime: testDate(year, month, day), O: c, H: c, L: c, C: c, V: 1}
+}
+
+func TestCachedHistoryCandleStorageLookup(t *testing.T) {
+	var srv = NewCachedHistoryCandleStorage(NewHistoryCandleStorage(t.TempDir()))
+	if _, err := srv.Last("SBER"); err != core.ErrNoData {
+		t.Errorf("Last without file: got %v, want %v", err, core.ErrNoData)
+	}
+	var err = srv.Update("SBER", []core.HistoryCandle{
+		testCandle(2024, 1, 3, 270),
+		testCandle(2024, 1, 4, 272),
+		testCandle(2024, 1, 8, 275),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tests = []struct {
+		name  string
+		get   func(securityCode string, d time.Time) (core.HistoryCandle, error)
+		date  time.Time
+		close float64
+		err   error
+	}{
+		{"before first", srv.CandleBeforeDate, testDate(2024, 1, 3), 0, core.ErrNoData},
+		{"before exact", srv.CandleBeforeDate, testDate(2024, 1, 4), 270, nil},
+		{"before gap", srv.CandleBeforeDate, testDate(2024, 1, 7), 272, nil},
+		{"before after last", srv.CandleBeforeDate, testDate(2024, 2, 1), 275, nil},
+		{"by date too early", srv.CandleByDate, testDate(2024, 1, 2), 0, core.ErrNoData},
+		{"by date exact", srv.CandleByDate, testDate(2024, 1, 4), 272, nil},
+		{"by date gap", srv.CandleByDate, testDate(2024, 1, 6), 272, nil},
+		{"by date after last", srv.CandleByDate, testDate(2024, 2, 1), 275, nil},
+	}
+	for _, test := range tests {
+		c, err := test.get("SBER", test.date)
+		if err != test.err || c.C != test.close {
+			t.Errorf("%v: got %v %v, want %v %v", test.name, c.C, err, test.close, test.err)
+		}
+	}
+	last, err := srv.Last("SBER")
+	if err != nil || last.C != 275 {
+		t.Errorf("Last: got %v %v, want 275", last.C, err)
+	}
+	// Read возвращает копию, изменение результата не портит кэш
+	candles, err := srv.Read("SBER")
+	if err != nil || len(candles) != 3 {
+		t.Fatalf("Read: got %v candles, error %v", len(candles), err)
+	}
+	candles[2].C = 0
+	if last, _ := srv.Last("SBER"); last.C != 275 {
+		t.Errorf("cache modified through Read result: %v", last.C)
+	}
+}
+
+func TestCachedHistoryCandleStorageUpdateInvalidates(t *testing.T) {
+	var folder = t.TempDir()
+	var srv = NewCachedHistoryCandleStorage(NewHistoryCandleStorage(folder))
+	if err := srv.Update("SBER", []core.HistoryCandle{testCandle(2024, 1, 3, 270)}); err != nil {
+		t.Fatal(err)
+	}
+	if last, err := srv.Last("SBER"); err != nil || last.C != 270 {
+		t.Fatalf("Last: got %v %v, want 270", last.C, err)
+	}
+	if err := srv.Update("SBER", []core.HistoryCandle{
+		testCandle(2024, 1, 3, 271),
+		testCandle(2024, 1, 4, 273),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	candles, err := srv.Read("SBER")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(candles) != 2 || candles[0].C != 271 || candles[1].C != 273 {
+		t.Errorf("after Update got %+v", candles)
+	}
+	// после чтения в кэш файл больше не перечитывается
+	if err := ioutil.WriteFile(filepath.Join(folder, "SBER.txt"), []byte("broken"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if last, err := srv.Last("SBER"); err != nil || last.C != 273 {
+		t.Errorf("cached Last: got %v %v, want 273", last.C, err)
+	}
+}
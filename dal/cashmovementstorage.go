@@ -0,0 +1,200 @@
+package dal
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ChizhovVadim/assets/core"
+)
+
+const cashMovementStorageVersion = 1
+
+var cashMovementColumns = []string{
+	"Date",
+	"Account",
+	"Kind",
+	"Sum",
+	"Currency",
+	"SecurityCode",
+	"Note",
+}
+
+type cashMovementStorage struct {
+	path string
+}
+
+func NewCashMovementStorage(path string) *cashMovementStorage {
+	return &cashMovementStorage{path}
+}
+
+// Read возвращает движения денежных средств по счету. Если файла нет, движений нет.
+func (srv *cashMovementStorage) Read(account string) ([]core.CashMovement, error) {
+	file, err := os.Open(srv.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+	items, err := readCashMovements(file)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", srv.path, err)
+	}
+	var result []core.CashMovement
+	for _, item := range items {
+		if account == "" || strings.EqualFold(item.Account, account) {
+			result = append(result, item)
+		}
+	}
+	return result, nil
+}
+
+func readCashMovements(r io.Reader) ([]core.CashMovement, error) {
+	var reader = bufio.NewReader(r)
+	version, lineOffset, err := readMyTradeStorageVersion(reader)
+	if err != nil {
+		return nil, err
+	}
+	if version > cashMovementStorageVersion {
+		return nil, fmt.Errorf("unsupported cash version %v", version)
+	}
+	csv := csv.NewReader(reader)
+	csv.FieldsPerRecord = -1
+	header, err := csv.Read()
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, storageCsvError(err, lineOffset)
+	}
+	var columns = newReportColumnIndex(header)
+	for _, name := range cashMovementColumns[:4] {
+		if columns.lookup(name) == -1 {
+			return nil, fmt.Errorf("cash column not found %v", name)
+		}
+	}
+	var result []core.CashMovement
+	for {
+		rec, err := csv.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, storageCsvError(err, lineOffset)
+		}
+		var line = storageCsvLine(csv, lineOffset)
+		item, err := parseCashMovement(reportRow{line: line, cells: rec}, columns)
+		if err != nil {
+			return nil, fmt.Errorf("line %v: %v", line, err)
+		}
+		result = append(result, item)
+	}
+	return result, nil
+}
+
+func parseCashMovement(row reportRow, columns reportColumnIndex) (core.CashMovement, error) {
+	d, err := time.Parse(myTradeStorageDateLayout, row.get(columns.lookup("Date")))
+	if err != nil {
+		return core.CashMovement{}, err
+	}
+	sum, err := strconv.ParseFloat(row.get(columns.lookup("Sum")), 64)
+	if err != nil {
+		return core.CashMovement{}, err
+	}
+	return core.CashMovement{
+		Date:         d,
+		Account:      row.get(columns.lookup("Account")),
+		Kind:         core.CashMovementKind(strings.ToLower(row.get(columns.lookup("Kind")))),
+		Sum:          sum,
+		Currency:     row.get(columns.lookup("Currency")),
+		SecurityCode: row.get(columns.lookup("SecurityCode")),
+		Note:         row.get(columns.lookup("Note")),
+	}, nil
+}
+
+// Update читает все движения, передает их в update и записывает результат под одной блокировкой.
+// Если update вернул nil, файл не меняется.
+func (srv *cashMovementStorage) Update(update func(items []core.CashMovement) []core.CashMovement) error {
+	unlock, err := lockFile(srv.path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	existing, err := srv.Read("")
+	if err != nil {
+		return err
+	}
+	var items = update(existing)
+	if items == nil {
+		return nil
+	}
+	return writeFileAtomic(srv.path, func(w io.Writer) error {
+		return writeCashMovements(w, items)
+	})
+}
+
+func writeCashMovements(w io.Writer, items []core.CashMovement) error {
+	_, err := fmt.Fprintf(w, "%v%v\n", myTradeStorageVersionPrefix, cashMovementStorageVersion)
+	if err != nil {
+		return err
+	}
+	writer := csv.NewWriter(w)
+	err = writer.Write(cashMovementColumns)
+	if err != nil {
+		return err
+	}
+	for _, item := range items {
+		err := writer.Write([]string{
+			item.Date.Format(myTradeStorageDateLayout),
+			item.Account,
+			string(item.Kind),
+			strconv.FormatFloat(item.Sum, 'f', -1, 64),
+			item.Currency,
+			item.SecurityCode,
+			item.Note,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// MergeCashMovements добавляет к existing движения из imported, которых еще нет
+// (совпадают счет, дата, вид, сумма, валюта и описание). Одинаковые движения учитываются по количеству.
+func MergeCashMovements(existing, imported []core.CashMovement) (merged, added []core.CashMovement) {
+	var counts = make(map[string]int)
+	for _, item := range existing {
+		counts[cashMovementKey(item)]++
+	}
+	for _, item := range imported {
+		var key = cashMovementKey(item)
+		if counts[key] > 0 {
+			counts[key]--
+			continue
+		}
+		added = append(added, item)
+	}
+	merged = make([]core.CashMovement, 0, len(existing)+len(added))
+	merged = append(merged, existing...)
+	merged = append(merged, added...)
+	sort.SliceStable(merged, func(i, j int) bool {
+		return merged[i].Date.Before(merged[j].Date)
+	})
+	return merged, added
+}
+
+func cashMovementKey(item core.CashMovement) string {
+	return fmt.Sprintf("%v|%v|%v|%v|%v|%v",
+		strings.ToLower(item.Account), item.Date.Format(myTradeStorageDateLayout),
+		item.Kind, strconv.FormatFloat(item.Sum, 'f', -1, 64), item.Currency, item.Note)
+}
@@ -0,0 +1,154 @@
+package dal
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ChizhovVadim/assets/core"
+)
+
+const (
+	cbrBaseUrl         = "http://www.cbr.ru/scripts/XML_dynamic.asp"
+	cbrRequestLayout   = "02/01/2006"
+	cbrResponseLayout  = "02.01.2006"
+	cbrSecurityPostfix = "CB"
+)
+
+// Коды валют ЦБ РФ, ключ - код валюты в SecurityCode (USDCB -> USD).
+var cbrCurrencyIds = map[string]string{
+	"USD": "R01235",
+	"EUR": "R01239",
+	"CNY": "R01375",
+	"GBP": "R01035",
+	"CHF": "R01775",
+	"JPY": "R01820",
+	"HKD": "R01200",
+	"KZT": "R01335",
+	"TRY": "R01700J",
+}
+
+type cbrHistoryCandleProvider struct {
+	client  *http.Client
+	baseUrl string
+}
+
+func NewCbrHistoryCandleProvider() *cbrHistoryCandleProvider {
+	return &cbrHistoryCandleProvider{
+		client: &http.Client{
+			Timeout: 25 * time.Second,
+		},
+		baseUrl: cbrBaseUrl,
+	}
+}
+
+func (srv *cbrHistoryCandleProvider) Load(ctx context.Context, securityCode string,
+	beginDate, endDate time.Time) ([]core.HistoryCandle, error) {
+	var currencyId, found = cbrCurrencyId(securityCode)
+	if !found {
+		return nil, fmt.Errorf("cbr currency not found %v", securityCode)
+	}
+	var url, err = historyCandlesCbrUrl(srv.baseUrl, currencyId, beginDate, endDate)
+	if err != nil {
+		return nil, err
+	}
+	return srv.getHistoryCandles(ctx, url)
+}
+
+func cbrCurrencyId(securityCode string) (string, bool) {
+	if !strings.HasSuffix(securityCode, cbrSecurityPostfix) {
+		return "", false
+	}
+	var id, found = cbrCurrencyIds[strings.TrimSuffix(securityCode, cbrSecurityPostfix)]
+	return id, found
+}
+
+func historyCandlesCbrUrl(baseUrl, currencyId string,
+	beginDate, endDate time.Time) (string, error) {
+	u, err := url.Parse(baseUrl)
+	if err != nil {
+		return "", err
+	}
+	var params = url.Values{}
+	params.Set("date_req1", beginDate.Format(cbrRequestLayout))
+	params.Set("date_req2", endDate.Format(cbrRequestLayout))
+	params.Set("VAL_NM_RQ", currencyId)
+	u.RawQuery = params.Encode()
+	return u.String(), nil
+}
+
+func (srv *cbrHistoryCandleProvider) getHistoryCandles(ctx context.Context,
+	url string) ([]core.HistoryCandle, error) {
+	resp, err := httpGet(ctx, srv.client, url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("http status %v", resp.Status)
+	}
+	return parseCbrHistoryCandles(resp.Body)
+}
+
+func parseCbrHistoryCandles(r io.Reader) ([]core.HistoryCandle, error) {
+	type record struct {
+		Date    string `xml:",attr"`
+		Nominal string
+		Value   string
+	}
+	var obj = struct {
+		Items []record `xml:"Record"`
+	}{}
+	var decoder = xml.NewDecoder(r)
+	decoder.CharsetReader = cbrCharsetReader
+	var err = decoder.Decode(&obj)
+	if err != nil {
+		return nil, err
+	}
+	var result []core.HistoryCandle
+	for _, item := range obj.Items {
+		d, err := time.Parse(cbrResponseLayout, item.Date)
+		if err != nil {
+			return nil, err
+		}
+		nominal, err := parseCbrFloat(item.Nominal)
+		if err != nil {
+			return nil, err
+		}
+		value, err := parseCbrFloat(item.Value)
+		if err != nil {
+			return nil, err
+		}
+		if nominal == 0 {
+			return nil, fmt.Errorf("cbr nominal zero %v", item.Date)
+		}
+		var rate = value / nominal
+		result = append(result, core.HistoryCandle{DateTime: d, O: rate, H: rate, L: rate, C: rate})
+	}
+	if len(result) == 0 {
+		return nil, core.ErrNoData
+	}
+	return result, nil
+}
+
+func parseCbrFloat(s string) (float64, error) {
+	return strconv.ParseFloat(strings.Replace(strings.TrimSpace(s), ",", ".", 1), 64)
+}
+
+func cbrCharsetReader(charset string, input io.Reader) (io.Reader, error) {
+	if !isWindows1251Charset(charset) {
+		return nil, fmt.Errorf("unsupported charset %v", charset)
+	}
+	data, err := ioutil.ReadAll(input)
+	if err != nil {
+		return nil, err
+	}
+	return strings.NewReader(decodeWindows1251(data)), nil
+}
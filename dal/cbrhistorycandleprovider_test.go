@@ -0,0 +1,57 @@
+package dal
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCbrHistoryCandleProvider(t *testing.T) {
+	var server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var q = r.URL.Query()
+		if q.Get("VAL_NM_RQ") != "R01335" || q.Get("date_req1") != "09/01/2024" || q.Get("date_req2") != "11/01/2024" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/xml; charset=windows-1251")
+		http.ServeFile(w, r, filepath.Join("testdata", "cbr", "dynamic_kzt.xml"))
+	}))
+	defer server.Close()
+
+	var srv = NewCbrHistoryCandleProvider()
+	srv.baseUrl = server.URL
+	candles, err := srv.Load(context.Background(), "KZTCB",
+		time.Date(2024, 1, 9, 0, 0, 0, 0, time.UTC), time.Date(2024, 1, 11, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want = []struct {
+		date time.Time
+		rate float64
+	}{
+		// курс за 100 тенге, десятичная запятая
+		{time.Date(2024, 1, 10, 0, 0, 0, 0, time.UTC), 0.196543},
+		{time.Date(2024, 1, 11, 0, 0, 0, 0, time.UTC), 0.195001},
+	}
+	if len(candles) != len(want) {
+		t.Fatalf("got %v candles, want %v", len(candles), len(want))
+	}
+	for i, w := range want {
+		var c = candles[i]
+		if !c.DateTime.Equal(w.date) || math.Abs(c.C-w.rate) > 1e-9 || c.O != c.C || c.H != c.C || c.L != c.C {
+			t.Errorf("candle %v: got %+v, want %v %v", i, c, w.date, w.rate)
+		}
+	}
+}
+
+func TestCbrHistoryCandleProviderUnknownCurrency(t *testing.T) {
+	var srv = NewCbrHistoryCandleProvider()
+	srv.baseUrl = "http://127.0.0.1:0"
+	if _, err := srv.Load(context.Background(), "XXXCB", time.Now(), time.Now()); err == nil {
+		t.Error("expected error for unknown currency")
+	}
+}
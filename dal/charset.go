@@ -0,0 +1,61 @@
+package dal
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// Старшая половина кодовой страницы windows-1251.
+var windows1251Table = [128]rune{
+	0x0402, 0x0403, 0x201A, 0x0453, 0x201E, 0x2026, 0x2020, 0x2021, 0x20AC, 0x2030, 0x0409, 0x2039, 0x040A, 0x040C, 0x040B, 0x040F,
+	0x0452, 0x2018, 0x2019, 0x201C, 0x201D, 0x2022, 0x2013, 0x2014, 0xFFFD, 0x2122, 0x0459, 0x203A, 0x045A, 0x045C, 0x045B, 0x045F,
+	0x00A0, 0x040E, 0x045E, 0x0408, 0x00A4, 0x0490, 0x00A6, 0x00A7, 0x0401, 0x00A9, 0x0404, 0x00AB, 0x00AC, 0x00AD, 0x00AE, 0x0407,
+	0x00B0, 0x00B1, 0x0406, 0x0456, 0x0491, 0x00B5, 0x00B6, 0x00B7, 0x0451, 0x2116, 0x0454, 0x00BB, 0x0458, 0x0405, 0x0455, 0x0457,
+}
+
+func decodeWindows1251(data []byte) string {
+	var sb strings.Builder
+	sb.Grow(2 * len(data))
+	for _, b := range data {
+		switch {
+		case b < 0x80:
+			sb.WriteByte(b)
+		case b < 0xC0:
+			sb.WriteRune(windows1251Table[b-0x80])
+		default:
+			sb.WriteRune(rune(b) - 0xC0 + 0x0410)
+		}
+	}
+	return sb.String()
+}
+
+// decodeText возвращает содержимое файла в UTF-8.
+// Отчеты брокеров и выгрузки QUIK часто сохраняются в windows-1251.
+// Начало файла для Detect может быть обрезано посреди символа UTF-8,
+// поэтому неполный последний символ при проверке кодировки не учитывается.
+func decodeText(data []byte) string {
+	data = trimUtf8Bom(data)
+	if utf8.Valid(trimIncompleteRune(data)) {
+		return string(data)
+	}
+	return decodeWindows1251(data)
+}
+
+// trimIncompleteRune отбрасывает начало многобайтного символа UTF-8 в конце data.
+func trimIncompleteRune(data []byte) []byte {
+	for i := 1; i < utf8.UTFMax && i <= len(data); i++ {
+		var start = len(data) - i
+		if utf8.RuneStart(data[start]) {
+			if utf8.FullRune(data[start:]) {
+				return data
+			}
+			return data[:start]
+		}
+	}
+	return data
+}
+
+func isWindows1251Charset(charset string) bool {
+	charset = strings.ToLower(charset)
+	return charset == "windows-1251" || charset == "cp1251"
+}
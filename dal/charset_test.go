@@ -0,0 +1,24 @@
+package dal
+
+import "testing"
+
+func TestDecodeText(t *testing.T) {
+	var utf8Text = []byte("Отчет брокера")
+	var tests = []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"utf-8", utf8Text, "Отчет брокера"},
+		{"utf-8 bom", append([]byte("\xef\xbb\xbf"), utf8Text...), "Отчет брокера"},
+		// начало файла обрезано посреди последней буквы "а"
+		{"utf-8 truncated rune", utf8Text[:len(utf8Text)-1], "Отчет брокер\xd0"},
+		{"windows-1251", []byte{0xCE, 0xF2, 0xF7, 0xE5, 0xF2}, "Отчет"},
+		{"windows-1251 trailing start byte", []byte{0xCE, 0xF2, 0xF7, 0xE5, 0xF2, 0xD0}, "ОтчетР"},
+	}
+	for _, test := range tests {
+		if got := decodeText(test.data); got != test.want {
+			t.Errorf("%v: got %q, want %q", test.name, got, test.want)
+		}
+	}
+}
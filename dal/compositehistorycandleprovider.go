@@ -0,0 +1,119 @@
+package dal
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ChizhovVadim/assets/core"
+)
+
+type NamedHistoryCandleProvider struct {
+	Name     string
+	Provider HistoryCandleProvider
+}
+
+type CandleSource struct {
+	Provider string
+	Start    time.Time
+	Finish   time.Time
+}
+
+type compositeHistoryCandleProvider struct {
+	securityInfoDirectory core.SecurityInfoDirectory
+	providers             []NamedHistoryCandleProvider
+	routes                map[string][]string
+	mu                    sync.Mutex
+	sources               map[string]CandleSource
+}
+
+// routes: SecurityCode или SecurityInfo.Market -> имена провайдеров в порядке опроса.
+// Для бумаг без маршрута провайдеры опрашиваются в порядке providers.
+func NewCompositeHistoryCandleProvider(
+	securityInfoDirectory core.SecurityInfoDirectory,
+	providers []NamedHistoryCandleProvider,
+	routes map[string][]string) *compositeHistoryCandleProvider {
+	return &compositeHistoryCandleProvider{
+		securityInfoDirectory: securityInfoDirectory,
+		providers:             providers,
+		routes:                routes,
+		sources:               make(map[string]CandleSource),
+	}
+}
+
+func (srv *compositeHistoryCandleProvider) Load(ctx context.Context, securityCode string,
+	beginDate, endDate time.Time) ([]core.HistoryCandle, error) {
+	var providers, err = srv.route(securityCode)
+	if err != nil {
+		return nil, err
+	}
+	var errs []string
+	var noData = true
+	for _, p := range providers {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		candles, err := p.Provider.Load(ctx, securityCode, beginDate, endDate)
+		if err == nil && len(candles) == 0 {
+			err = core.ErrNoData
+		}
+		if err != nil {
+			log.Printf("provider failed %v %v %v", p.Name, securityCode, err)
+			errs = append(errs, fmt.Sprintf("%v: %v", p.Name, err))
+			noData = noData && err == core.ErrNoData
+			continue
+		}
+		srv.mu.Lock()
+		srv.sources[securityCode] = CandleSource{
+			Provider: p.Name,
+			Start:    candles[0].DateTime,
+			Finish:   candles[len(candles)-1].DateTime,
+		}
+		srv.mu.Unlock()
+		return candles, nil
+	}
+	if noData {
+		return nil, core.ErrNoData
+	}
+	return nil, fmt.Errorf("all providers failed %v [%v]", securityCode, strings.Join(errs, "; "))
+}
+
+// LastSource возвращает провайдера и диапазон свечей последней успешной загрузки.
+func (srv *compositeHistoryCandleProvider) LastSource(securityCode string) (CandleSource, bool) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	var source, found = srv.sources[securityCode]
+	return source, found
+}
+
+func (srv *compositeHistoryCandleProvider) route(securityCode string) ([]NamedHistoryCandleProvider, error) {
+	var names, found = srv.routes[securityCode]
+	if !found {
+		var secInfo, _ = srv.securityInfoDirectory.Read(securityCode)
+		names, found = srv.routes[secInfo.Market]
+		if !found {
+			return srv.providers, nil
+		}
+	}
+	var result []NamedHistoryCandleProvider
+	for _, name := range names {
+		var p, found = srv.findProvider(name)
+		if !found {
+			return nil, fmt.Errorf("provider not found %v", name)
+		}
+		result = append(result, p)
+	}
+	return result, nil
+}
+
+func (srv *compositeHistoryCandleProvider) findProvider(name string) (NamedHistoryCandleProvider, bool) {
+	for _, p := range srv.providers {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return NamedHistoryCandleProvider{}, false
+}
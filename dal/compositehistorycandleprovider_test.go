@@ -0,0 +1,160 @@
+package dal
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ChizhovVadim/assets/core"
+)
+
+type testHistoryCandleProvider struct {
+	name    string
+	calls   *[]string
+	candles []core.HistoryCandle
+	err     error
+}
+
+func (p testHistoryCandleProvider) Load(ctx context.Context, securityCode string,
+	beginDate, endDate time.Time) ([]core.HistoryCandle, error) {
+	*p.calls = append(*p.calls, p.name)
+	return p.candles, p.err
+}
+
+func TestCompositeHistoryCandleProvider(t *testing.T) {
+	var candles = []core.HistoryCandle{
+		{DateTime: time.Date(2024, 1, 3, 0, 0, 0, 0, time.UTC), C: 100},
+		{DateTime: time.Date(2024, 1, 4, 0, 0, 0, 0, time.UTC), C: 101},
+	}
+	var failed = errors.New("connection refused")
+	var tests = []struct {
+		name         string
+		securityCode string
+		results      map[string]testHistoryCandleProvider
+		calls        []string
+		source       string
+		err          string
+	}{
+		{
+			name:         "fallback in providers order",
+			securityCode: "GAZP",
+			results: map[string]testHistoryCandleProvider{
+				"finam": {err: failed},
+				"moex":  {candles: candles},
+			},
+			calls:  []string{"finam", "moex"},
+			source: "moex",
+		},
+		{
+			name:         "first provider wins",
+			securityCode: "GAZP",
+			results: map[string]testHistoryCandleProvider{
+				"finam": {candles: candles},
+				"moex":  {candles: candles},
+			},
+			calls:  []string{"finam"},
+			source: "finam",
+		},
+		{
+			name:         "route by security code",
+			securityCode: "USDCB",
+			results: map[string]testHistoryCandleProvider{
+				"cbr": {candles: candles},
+			},
+			calls:  []string{"cbr"},
+			source: "cbr",
+		},
+		{
+			name:         "route by market",
+			securityCode: "OFZ26207",
+			results: map[string]testHistoryCandleProvider{
+				"moex":  {},
+				"finam": {candles: candles},
+			},
+			calls:  []string{"moex", "finam"},
+			source: "finam",
+		},
+		{
+			name:         "all providers without data",
+			securityCode: "GAZP",
+			results:      map[string]testHistoryCandleProvider{},
+			calls:        []string{"finam", "moex", "cbr"},
+			err:          core.ErrNoData.Error(),
+		},
+		{
+			name:         "errors aggregated",
+			securityCode: "OFZ26207",
+			results: map[string]testHistoryCandleProvider{
+				"moex":  {err: failed},
+				"finam": {err: core.ErrNoData},
+			},
+			calls: []string{"moex", "finam"},
+			err:   "all providers failed OFZ26207 [moex: connection refused; finam: " + core.ErrNoData.Error() + "]",
+		},
+		{
+			name:         "unknown provider in route",
+			securityCode: "SBER",
+			err:          "provider not found tinkoff",
+		},
+	}
+	for _, test := range tests {
+		var calls []string
+		var providers []NamedHistoryCandleProvider
+		for _, name := range []string{"finam", "moex", "cbr"} {
+			var p = test.results[name]
+			p.name = name
+			p.calls = &calls
+			providers = append(providers, NamedHistoryCandleProvider{Name: name, Provider: p})
+		}
+		var srv = NewCompositeHistoryCandleProvider(
+			testSecurityInfoDirectory{
+				{SecurityCode: "OFZ26207", Market: "bonds"},
+			},
+			providers,
+			map[string][]string{
+				"USDCB": {"cbr"},
+				"SBER":  {"moex", "tinkoff"},
+				"bonds": {"moex", "finam"},
+			})
+		result, err := srv.Load(context.Background(), test.securityCode,
+			time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 1, 10, 0, 0, 0, 0, time.UTC))
+		if strings.Join(calls, ",") != strings.Join(test.calls, ",") {
+			t.Errorf("%v: got calls %v, want %v", test.name, calls, test.calls)
+		}
+		if test.err != "" {
+			if err == nil || err.Error() != test.err {
+				t.Errorf("%v: got error %v, want %v", test.name, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: %v", test.name, err)
+			continue
+		}
+		if len(result) != len(candles) {
+			t.Errorf("%v: got %v candles, want %v", test.name, len(result), len(candles))
+		}
+		var source, found = srv.LastSource(test.securityCode)
+		if !found || source.Provider != test.source ||
+			!source.Start.Equal(candles[0].DateTime) || !source.Finish.Equal(candles[1].DateTime) {
+			t.Errorf("%v: got source %+v, want %v", test.name, source, test.source)
+		}
+	}
+}
+
+func TestCompositeHistoryCandleProviderNoDataIsError(t *testing.T) {
+	var calls []string
+	var srv = NewCompositeHistoryCandleProvider(testSecurityInfoDirectory{},
+		[]NamedHistoryCandleProvider{
+			{Name: "finam", Provider: testHistoryCandleProvider{name: "finam", calls: &calls, err: core.ErrNoData}},
+		}, nil)
+	var _, err = srv.Load(context.Background(), "GAZP", time.Now(), time.Now())
+	if err != core.ErrNoData {
+		t.Errorf("got error %v, want %v", err, core.ErrNoData)
+	}
+	if _, found := srv.LastSource("GAZP"); found {
+		t.Error("source recorded for failed load")
+	}
+}
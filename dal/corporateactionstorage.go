@@ -0,0 +1,145 @@
+package dal
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/ChizhovVadim/assets/core"
+)
+
+// corporateActionStorage корпоративные действия в XML:
+//
+//	<CorporateActions>
+//	  <CorporateAction Kind="split" Name="GMKN" Date="2024-04-04" From="1" To="100"/>
+//	  <CorporateAction Kind="conversion" Name="TRNFP" NewName="TRNF" Date="2024-03-01" From="1" To="1"/>
+//	  <CorporateAction Kind="exchange" Name="OLD" Date="2024-05-01" Cash="10.5">
+//	    <Result Name="NEW" From="2" To="1" CostShare="0.9"/>
+//	  </CorporateAction>
+//	</CorporateActions>
+type corporateActionStorage struct {
+	path string
+}
+
+func NewCorporateActionStorage(path string) *corporateActionStorage {
+	return &corporateActionStorage{path}
+}
+
+type corporateActionXml struct {
+	Kind            string  `xml:",attr"`
+	SecurityCode    string  `xml:"Name,attr"`
+	NewSecurityCode string  `xml:"NewName,attr"`
+	Date            string  `xml:",attr"`
+	From            float64 `xml:",attr"`
+	To              float64 `xml:",attr"`
+	Cash            float64 `xml:",attr"`
+	Taxable         bool    `xml:",attr"`
+	Note            string  `xml:",attr"`
+	Results         []struct {
+		SecurityCode string  `xml:"Name,attr"`
+		From         float64 `xml:",attr"`
+		To           float64 `xml:",attr"`
+		CostShare    float64 `xml:",attr"`
+		Price        float64 `xml:",attr"`
+	} `xml:"Result"`
+}
+
+const corporateActionDateLayout = "2006-01-02"
+
+// Read возвращает корпоративные действия по дате. Если файла нет, действий нет.
+func (srv *corporateActionStorage) Read() ([]core.CorporateAction, error) {
+	var obj = struct {
+		Items []corporateActionXml `xml:"CorporateAction"`
+	}{}
+	var err = decodeXmlFile(srv.path, &obj)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var result []core.CorporateAction
+	for _, item := range obj.Items {
+		action, err := parseCorporateAction(item)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %v %v", srv.path, item.SecurityCode, err)
+		}
+		result = append(result, action)
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].Date.Before(result[j].Date)
+	})
+	return result, nil
+}
+
+func parseCorporateAction(item corporateActionXml) (core.CorporateAction, error) {
+	d, err := time.Parse(corporateActionDateLayout, item.Date)
+	if err != nil {
+		return core.CorporateAction{}, err
+	}
+	var action = core.CorporateAction{
+		Date:            d,
+		Kind:            core.CorporateActionKind(item.Kind),
+		SecurityCode:    item.SecurityCode,
+		NewSecurityCode: item.NewSecurityCode,
+		From:            item.From,
+		To:              item.To,
+		Cash:            item.Cash,
+		Taxable:         item.Taxable,
+		Note:            item.Note,
+	}
+	for _, r := range item.Results {
+		var result = core.CorporateActionResult{
+			SecurityCode: r.SecurityCode,
+			From:         r.From,
+			To:           r.To,
+			CostShare:    r.CostShare,
+			Price:        r.Price,
+		}
+		if result.From == 0 {
+			result.From = 1
+		}
+		if result.To == 0 {
+			result.To = 1
+		}
+		if result.SecurityCode == "" || result.From < 0 || result.To < 0 || result.CostShare < 0 {
+			return core.CorporateAction{}, fmt.Errorf("bad result %+v", r)
+		}
+		action.Results = append(action.Results, result)
+	}
+	if action.From == 0 {
+		action.From = 1
+	}
+	if action.To == 0 {
+		action.To = 1
+	}
+	if action.From < 0 || action.To < 0 {
+		return core.CorporateAction{}, fmt.Errorf("bad ratio %v:%v", item.From, item.To)
+	}
+	switch action.Kind {
+	case core.CorporateActionSplit:
+		action.NewSecurityCode = action.SecurityCode
+	case core.CorporateActionConversion:
+		if action.NewSecurityCode == "" {
+			return core.CorporateAction{}, fmt.Errorf("NewName required")
+		}
+	case core.CorporateActionSpinOff, core.CorporateActionExchange:
+		if len(action.Results) == 0 && action.Cash == 0 {
+			return core.CorporateAction{}, fmt.Errorf("Result required")
+		}
+		if action.Kind == core.CorporateActionSpinOff && action.Cash != 0 {
+			return core.CorporateAction{}, fmt.Errorf("Cash not supported for spinoff")
+		}
+		var costShare = 0.0
+		for _, r := range action.Results {
+			costShare += r.CostShare
+		}
+		if costShare > 1 {
+			return core.CorporateAction{}, fmt.Errorf("total CostShare %v > 1", costShare)
+		}
+	default:
+		return core.CorporateAction{}, fmt.Errorf("unknown kind %q", item.Kind)
+	}
+	return action, nil
+}
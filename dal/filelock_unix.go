@@ -0,0 +1,25 @@
+//go:build !windows
+// +build !windows
+
+package dal
+
+import (
+	"os"
+	"syscall"
+)
+
+// lockFile берет эксклюзивную advisory блокировку path.lock на время записи.
+func lockFile(path string) (unlock func(), err error) {
+	f, err := os.OpenFile(path+".lock", os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return func() {
+		syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		f.Close()
+	}, nil
+}
@@ -0,0 +1,39 @@
+//go:build windows
+// +build windows
+
+package dal
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	modkernel32      = syscall.NewLazyDLL("kernel32.dll")
+	procLockFileEx   = modkernel32.NewProc("LockFileEx")
+	procUnlockFileEx = modkernel32.NewProc("UnlockFileEx")
+)
+
+const lockfileExclusiveLock = 0x00000002
+
+// lockFile берет эксклюзивную блокировку path.lock через LockFileEx на время записи.
+// Блокировку снимает ОС при закрытии файла, в том числе при аварийном завершении процесса,
+// поэтому оставшийся после сбоя path.lock не мешает следующим записям.
+func lockFile(path string) (unlock func(), err error) {
+	f, err := os.OpenFile(path+".lock", os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	var overlapped syscall.Overlapped
+	r1, _, e1 := procLockFileEx.Call(f.Fd(), lockfileExclusiveLock, 0, 1, 0,
+		uintptr(unsafe.Pointer(&overlapped)))
+	if r1 == 0 {
+		f.Close()
+		return nil, e1
+	}
+	return func() {
+		procUnlockFileEx.Call(f.Fd(), 0, 1, 0, uintptr(unsafe.Pointer(&overlapped)))
+		f.Close()
+	}, nil
+}
@@ -1,6 +1,7 @@
 package dal
 
 import (
+	"context"
 	"encoding/csv"
 	"fmt"
 	"io"
@@ -30,7 +31,7 @@ func NewHistoryCandleProvider(securityInfoDirectory core.SecurityInfoDirectory)
 	}
 }
 
-func (srv *historyCandleProvider) Load(securityCode string,
+func (srv *historyCandleProvider) Load(ctx context.Context, securityCode string,
 	beginDate, endDate time.Time) ([]core.HistoryCandle, error) {
 
 	var secInfo, found = srv.securityInfoDirectory.Read(securityCode)
@@ -44,7 +45,7 @@ func (srv *historyCandleProvider) Load(securityCode string,
 	if err != nil {
 		return nil, err
 	}
-	return srv.getHistoryCandles(url)
+	return srv.getHistoryCandles(ctx, url)
 }
 
 func historyCandlesFinamUrl(securityCode int, periodCode int,
@@ -72,8 +73,9 @@ func historyCandlesFinamUrl(securityCode int, periodCode int,
 	return baseUrl.String(), nil
 }
 
-func (srv *historyCandleProvider) getHistoryCandles(url string) ([]core.HistoryCandle, error) {
-	resp, err := srv.client.Get(url)
+func (srv *historyCandleProvider) getHistoryCandles(ctx context.Context,
+	url string) ([]core.HistoryCandle, error) {
+	resp, err := httpGet(ctx, srv.client, url)
 	if err != nil {
 		return nil, err
 	}
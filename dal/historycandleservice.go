@@ -1,14 +1,24 @@
 package dal
 
 import (
+	"context"
+	"errors"
 	"log"
+	"net/http"
+	"sync"
 	"time"
 
 	"github.com/ChizhovVadim/assets/core"
 )
 
+// HistoryCandleProvider загружает свечи. HTTP запросы выполняются через httpGet,
+// чтобы учитывались отмена ctx и ограничение частоты запросов.
 type HistoryCandleProvider interface {
-	Load(securityCode string, beginDate, endDate time.Time) ([]core.HistoryCandle, error)
+	Load(ctx context.Context, securityCode string, beginDate, endDate time.Time) ([]core.HistoryCandle, error)
+}
+
+type candleSourceProvider interface {
+	LastSource(securityCode string) (CandleSource, bool)
 }
 
 type HistoryCandleService struct {
@@ -17,6 +27,37 @@ type HistoryCandleService struct {
 	startHistoryDate      time.Time
 }
 
+type UpdateHistoryCandlesRequest struct {
+	SecurityCodes     []string
+	Workers           int
+	RequestsPerSecond float64
+	MaxAttempts       int
+	InitialBackoff    time.Duration
+}
+
+type UpdateResult struct {
+	Items []UpdateResultItem
+}
+
+type UpdateResultItem struct {
+	SecurityCode string
+	CandlesAdded int
+	LastDate     time.Time
+	Source       string
+	Err          error
+}
+
+// Failed число бумаг с ошибкой обновления. Отсутствие новых данных за период ошибкой не считается.
+func (r UpdateResult) Failed() int {
+	var result = 0
+	for _, item := range r.Items {
+		if item.Err != nil && !errors.Is(item.Err, core.ErrNoData) {
+			result++
+		}
+	}
+	return result
+}
+
 func NewHistoryCandleService(
 	historyCandleStorage core.HistoryCandleStorage,
 	historyCandleProvider HistoryCandleProvider) *HistoryCandleService {
@@ -24,40 +65,163 @@ func NewHistoryCandleService(
 	return &HistoryCandleService{historyCandleStorage, historyCandleProvider, startHistoryDate}
 }
 
-func (srv *HistoryCandleService) UpdateHistoryCandles(securityCodes []string) error {
+func (srv *HistoryCandleService) UpdateHistoryCandles(ctx context.Context,
+	r UpdateHistoryCandlesRequest) (UpdateResult, error) {
+	if r.Workers <= 0 {
+		r.Workers = 4
+	}
+	if r.RequestsPerSecond <= 0 {
+		r.RequestsPerSecond = 1
+	}
+	if r.MaxAttempts <= 0 {
+		r.MaxAttempts = 3
+	}
+	if r.InitialBackoff <= 0 {
+		r.InitialBackoff = time.Second
+	}
 	log.Println("Обновляем исторические котировки...")
-	for i, securityCode := range securityCodes {
-		if i > 0 {
-			time.Sleep(1000 * time.Millisecond)
-		}
-		var err = srv.UpdateHistoryCandlesBySecurityCode(securityCode)
-		if err != nil {
-			log.Printf("update failed first %v %v", securityCode, err)
-			time.Sleep(1000 * time.Millisecond)
-			err = srv.UpdateHistoryCandlesBySecurityCode(securityCode)
-			if err != nil {
-				log.Printf("update failed second %v %v", securityCode, err)
+	// ограничение частоты - на каждый HTTP запрос, в том числе на страницы одной загрузки
+	ctx = withRateLimiter(ctx, newRateLimiter(r.RequestsPerSecond))
+	var result = UpdateResult{Items: make([]UpdateResultItem, len(r.SecurityCodes))}
+	var indexes = make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < r.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range indexes {
+				var securityCode = r.SecurityCodes[index]
+				var item = srv.updateWithRetry(ctx, r, securityCode)
+				if item.Err != nil {
+					log.Printf("update failed %v %v", securityCode, item.Err)
+				}
+				result.Items[index] = item
 			}
+		}()
+	}
+	for i, securityCode := range r.SecurityCodes {
+		if ctx.Err() != nil {
+			result.Items[i] = UpdateResultItem{SecurityCode: securityCode, Err: ctx.Err()}
+			continue
 		}
+		indexes <- i
 	}
+	close(indexes)
+	wg.Wait()
 	log.Println("Исторические котировки обновлены.")
-	return nil
+	return result, ctx.Err()
+}
+
+func (srv *HistoryCandleService) updateWithRetry(ctx context.Context,
+	r UpdateHistoryCandlesRequest, securityCode string) UpdateResultItem {
+	var backoff = r.InitialBackoff
+	var item UpdateResultItem
+	for attempt := 1; ; attempt++ {
+		item = srv.UpdateHistoryCandlesBySecurityCode(ctx, securityCode)
+		if item.Err == nil || item.Err == core.ErrNoData || attempt >= r.MaxAttempts || ctx.Err() != nil {
+			return item
+		}
+		log.Printf("update failed attempt %v %v %v", attempt, securityCode, item.Err)
+		if err := sleepContext(ctx, backoff); err != nil {
+			return item
+		}
+		backoff *= 2
+	}
 }
 
-func (srv *HistoryCandleService) UpdateHistoryCandlesBySecurityCode(securityCode string) error {
+func (srv *HistoryCandleService) UpdateHistoryCandlesBySecurityCode(ctx context.Context,
+	securityCode string) UpdateResultItem {
+	var result = UpdateResultItem{SecurityCode: securityCode}
 	var startDate time.Time
+	var lastDate time.Time
 	if last, err := srv.historyCandleStorage.Last(securityCode); err != nil {
 		if err == core.ErrNoData {
 			startDate = srv.startHistoryDate
 		} else {
-			return err
+			result.Err = err
+			return result
 		}
 	} else {
 		startDate = last.DateTime
+		lastDate = last.DateTime
+		result.LastDate = last.DateTime
+	}
+	var candles, err = srv.historyCandleProvider.Load(ctx, securityCode, startDate, time.Now())
+	if err != nil {
+		result.Err = err
+		return result
 	}
-	var candles, err = srv.historyCandleProvider.Load(securityCode, startDate, time.Now())
+	if sourceProvider, ok := srv.historyCandleProvider.(candleSourceProvider); ok {
+		if source, found := sourceProvider.LastSource(securityCode); found {
+			result.Source = source.Provider
+		}
+	}
+	err = srv.historyCandleStorage.Update(securityCode, candles)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	for _, c := range candles {
+		if c.DateTime.After(lastDate) {
+			result.CandlesAdded++
+		}
+	}
+	result.LastDate = candles[len(candles)-1].DateTime
+	return result
+}
+
+type rateLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+func newRateLimiter(requestsPerSecond float64) *rateLimiter {
+	return &rateLimiter{interval: time.Duration(float64(time.Second) / requestsPerSecond)}
+}
+
+func (l *rateLimiter) Wait(ctx context.Context) error {
+	l.mu.Lock()
+	var now = time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	var delay = l.next.Sub(now)
+	l.next = l.next.Add(l.interval)
+	l.mu.Unlock()
+	return sleepContext(ctx, delay)
+}
+
+type rateLimiterKey struct{}
+
+func withRateLimiter(ctx context.Context, limiter *rateLimiter) context.Context {
+	return context.WithValue(ctx, rateLimiterKey{}, limiter)
+}
+
+// httpGet GET запрос с отменой по ctx. Если в ctx есть ограничитель частоты, ждет очереди.
+func httpGet(ctx context.Context, client *http.Client, url string) (*http.Response, error) {
+	if limiter, ok := ctx.Value(rateLimiterKey{}).(*rateLimiter); ok {
+		if err := limiter.Wait(ctx); err != nil {
+			return nil, err
+		}
+	}
+	req, err := http.NewRequest(http.MethodGet, url, nil)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	return client.Do(req.WithContext(ctx))
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	var timer = time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
 	}
-	return srv.historyCandleStorage.Update(securityCode, candles)
 }
@@ -0,0 +1,23 @@
+package dal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/ChizhovVadim/assets/core"
+)
+
+func TestUpdateResultFailed(t *testing.T) {
+	var result = UpdateResult{Items: []UpdateResultItem{
+		{SecurityCode: "SBER", CandlesAdded: 3},
+		{SecurityCode: "GAZP", Err: core.ErrNoData},
+		{SecurityCode: "LKOH", Err: fmt.Errorf("load LKOH: %w", core.ErrNoData)},
+		{SecurityCode: "MGNT", Err: errors.New("all providers failed")},
+		{SecurityCode: "ROSN", Err: context.Canceled},
+	}}
+	if failed := result.Failed(); failed != 2 {
+		t.Errorf("got %v failed, want 2", failed)
+	}
+}
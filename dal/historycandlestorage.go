@@ -113,14 +113,14 @@ func isPathExists(path string) (bool, error) {
 }
 
 func (srv *historyCandleStorage) writeAll(securityCode, filename string, source []core.HistoryCandle) error {
-	f, err := os.Create(filename)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
+	return writeFileAtomic(filename, func(w io.Writer) error {
+		return writeHistoryCandles(w, securityCode, source)
+	})
+}
 
-	csv := csv.NewWriter(f)
-	err = csv.Write(strings.Split("<TICKER>,<PER>,<DATE>,<TIME>,<OPEN>,<HIGH>,<LOW>,<CLOSE>,<VOL>", ","))
+func writeHistoryCandles(w io.Writer, securityCode string, source []core.HistoryCandle) error {
+	csv := csv.NewWriter(w)
+	err := csv.Write(strings.Split("<TICKER>,<PER>,<DATE>,<TIME>,<OPEN>,<HIGH>,<LOW>,<CLOSE>,<VOL>", ","))
 	if err != nil {
 		return err
 	}
@@ -158,7 +158,12 @@ func lastIndexOfHistoryCandle(source []core.HistoryCandle,
 }
 
 func (srv *historyCandleStorage) Update(securityCode string, candles []core.HistoryCandle) error {
-	var exists, err = isPathExists(srv.fileName(securityCode))
+	unlock, err := lockFile(srv.fileName(securityCode))
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	exists, err := isPathExists(srv.fileName(securityCode))
 	if err != nil {
 		return err
 	}
@@ -176,7 +181,6 @@ func (srv *historyCandleStorage) Update(securityCode string, candles []core.Hist
 		})
 		currentCandles = append(currentCandles[:index+1], candles...)
 	}
-	//TODO write to temp file first?
 	return srv.writeAll(securityCode, srv.fileName(securityCode), currentCandles)
 }
 
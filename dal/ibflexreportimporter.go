@@ -0,0 +1,415 @@
+package dal
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ChizhovVadim/assets/core"
+)
+
+// ibFlexReportImporter разбирает Flex Query XML Interactive Brokers
+// (разделы Trades, CashTransactions, CorporateActions).
+type ibFlexReportImporter struct {
+	securityInfoDirectory core.SecurityInfoDirectory
+	accounts              map[string]string
+}
+
+// accounts: accountId IB -> Account. Если accountId не найден, Account = accountId.
+func NewIBFlexReportImporter(securityInfoDirectory core.SecurityInfoDirectory,
+	accounts map[string]string) *ibFlexReportImporter {
+	return &ibFlexReportImporter{
+		securityInfoDirectory: securityInfoDirectory,
+		accounts:              accounts,
+	}
+}
+
+func (srv *ibFlexReportImporter) Name() string {
+	return "ib"
+}
+
+func (srv *ibFlexReportImporter) Detect(head []byte) bool {
+	return bytes.Contains(head, []byte("<FlexQueryResponse"))
+}
+
+type ibFlexTrade struct {
+	AccountId                      string `xml:"accountId,attr"`
+	Currency                       string `xml:"currency,attr"`
+	AssetCategory                  string `xml:"assetCategory,attr"`
+	Symbol                         string `xml:"symbol,attr"`
+	Isin                           string `xml:"isin,attr"`
+	DateTime                       string `xml:"dateTime,attr"`
+	TradeDate                      string `xml:"tradeDate,attr"`
+	TradeTime                      string `xml:"tradeTime,attr"`
+	SettleDate                     string `xml:"settleDateTarget,attr"`
+	Quantity                       string `xml:"quantity,attr"`
+	TradePrice                     string `xml:"tradePrice,attr"`
+	Multiplier                     string `xml:"multiplier,attr"`
+	IBCommission                   string `xml:"ibCommission,attr"`
+	IBCommissionCurrency           string `xml:"ibCommissionCurrency,attr"`
+	BrokerExecutionCommission      string `xml:"brokerExecutionCommission,attr"`
+	BrokerClearingCommission       string `xml:"brokerClearingCommission,attr"`
+	ThirdPartyExecutionCommission  string `xml:"thirdPartyExecutionCommission,attr"`
+	ThirdPartyClearingCommission   string `xml:"thirdPartyClearingCommission,attr"`
+	ThirdPartyRegulatoryCommission string `xml:"thirdPartyRegulatoryCommission,attr"`
+	OtherCommission                string `xml:"otherCommission,attr"`
+	FxRateToBase                   string `xml:"fxRateToBase,attr"`
+	AccruedInt                     string `xml:"accruedInt,attr"`
+	BuySell                        string `xml:"buySell,attr"`
+	TradeId                        string `xml:"tradeID,attr"`
+	LevelOfDetail                  string `xml:"levelOfDetail,attr"`
+}
+
+type ibFlexCashTransaction struct {
+	AccountId     string `xml:"accountId,attr"`
+	Currency      string `xml:"currency,attr"`
+	Symbol        string `xml:"symbol,attr"`
+	Isin          string `xml:"isin,attr"`
+	DateTime      string `xml:"dateTime,attr"`
+	SettleDate    string `xml:"settleDate,attr"`
+	Amount        string `xml:"amount,attr"`
+	Type          string `xml:"type,attr"`
+	Description   string `xml:"description,attr"`
+	ActionId      string `xml:"actionID,attr"`
+	LevelOfDetail string `xml:"levelOfDetail,attr"`
+}
+
+type ibFlexCorporateAction struct {
+	AccountId   string `xml:"accountId,attr"`
+	Currency    string `xml:"currency,attr"`
+	Symbol      string `xml:"symbol,attr"`
+	Isin        string `xml:"isin,attr"`
+	DateTime    string `xml:"dateTime,attr"`
+	Quantity    string `xml:"quantity,attr"`
+	Proceeds    string `xml:"proceeds,attr"`
+	Type        string `xml:"type,attr"`
+	Description string `xml:"description,attr"`
+}
+
+type ibFlexQueryResponse struct {
+	Statements []struct {
+		AccountId          string `xml:"accountId,attr"`
+		AccountInformation struct {
+			Currency string `xml:"currency,attr"`
+		} `xml:"AccountInformation"`
+		Trades           []ibFlexTrade           `xml:"Trades>Trade"`
+		CashTransactions []ibFlexCashTransaction `xml:"CashTransactions>CashTransaction"`
+		CorporateActions []ibFlexCorporateAction `xml:"CorporateActions>CorporateAction"`
+	} `xml:"FlexStatements>FlexStatement"`
+}
+
+func (srv *ibFlexReportImporter) Import(data []byte, options ImportOptions) (ImportResult, error) {
+	var obj ibFlexQueryResponse
+	var err = xml.Unmarshal(trimUtf8Bom(data), &obj)
+	if err != nil {
+		return ImportResult{}, err
+	}
+	var result ImportResult
+	for _, statement := range obj.Statements {
+		for i, t := range statement.Trades {
+			if (t.LevelOfDetail != "" && !strings.EqualFold(t.LevelOfDetail, "EXECUTION")) ||
+				strings.EqualFold(t.AssetCategory, "CASH") {
+				continue
+			}
+			trade, err := srv.parseTrade(t, statement.AccountInformation.Currency)
+			if err != nil {
+				result.addError(i+1, fmt.Errorf("Trades: %v", err))
+				continue
+			}
+			result.Trades = append(result.Trades, trade)
+		}
+		srv.importCashTransactions(statement.CashTransactions, &result)
+		for i, a := range statement.CorporateActions {
+			err := srv.importCorporateAction(a, &result)
+			if err != nil {
+				result.addError(i+1, fmt.Errorf("CorporateActions: %v", err))
+			}
+		}
+	}
+	return result, nil
+}
+
+func (srv *ibFlexReportImporter) account(accountId string) string {
+	if account, found := srv.accounts[accountId]; found {
+		return account
+	}
+	return accountId
+}
+
+// parseTrade baseCurrency - базовая валюта счета из AccountInformation.
+func (srv *ibFlexReportImporter) parseTrade(t ibFlexTrade, baseCurrency string) (core.MyTrade, error) {
+	var dateTime = t.DateTime
+	if dateTime == "" {
+		dateTime = t.TradeDate + ";" + t.TradeTime
+	}
+	d, err := parseIBDateTime(dateTime)
+	if err != nil {
+		return core.MyTrade{}, err
+	}
+	var executionDate = date(d)
+	if t.SettleDate != "" {
+		executionDate, err = parseIBDateTime(t.SettleDate)
+		if err != nil {
+			return core.MyTrade{}, err
+		}
+	}
+	securityCode, err := resolveSecurityCode(srv.securityInfoDirectory, t.Isin, t.Symbol, t.Symbol)
+	if err != nil {
+		return core.MyTrade{}, err
+	}
+	quantity, err := parseIBFloat(t.Quantity)
+	if err != nil {
+		return core.MyTrade{}, err
+	}
+	multiplier, err := parseIBFloat(t.Multiplier)
+	if err != nil {
+		return core.MyTrade{}, err
+	}
+	if multiplier != 0 && multiplier != 1 {
+		return core.MyTrade{}, fmt.Errorf("multiplier not supported %v %v", t.Symbol, t.Multiplier)
+	}
+	if quantity != math.Trunc(quantity) {
+		return core.MyTrade{}, fmt.Errorf("fractional quantity not supported %v %v", t.Symbol, t.Quantity)
+	}
+	var volume = int(quantity)
+	if strings.HasPrefix(strings.ToUpper(t.BuySell), "SELL") && volume > 0 {
+		volume = -volume
+	}
+	price, err := parseIBFloat(t.TradePrice)
+	if err != nil {
+		return core.MyTrade{}, err
+	}
+	brokerComission, exchangeComission, err := splitIBCommission(t)
+	if err != nil {
+		return core.MyTrade{}, err
+	}
+	var note string
+	if t.IBCommissionCurrency != "" && !strings.EqualFold(t.IBCommissionCurrency, t.Currency) {
+		// комиссия в базовой валюте счета переводится в валюту сделки по курсу fxRateToBase,
+		// курса другой валюты в строке сделки нет
+		fxRateToBase, err := parseIBFloat(t.FxRateToBase)
+		if err != nil {
+			return core.MyTrade{}, err
+		}
+		if !strings.EqualFold(t.IBCommissionCurrency, baseCurrency) || fxRateToBase <= 0 {
+			return core.MyTrade{}, fmt.Errorf("commission currency %v differs from trade currency %v %v",
+				t.IBCommissionCurrency, t.Currency, t.Symbol)
+		}
+		note = fmt.Sprintf("commission %v %v", brokerComission+exchangeComission,
+			normalizeCurrency(t.IBCommissionCurrency))
+		brokerComission /= fxRateToBase
+		exchangeComission /= fxRateToBase
+	}
+	accruedInterest, err := parseIBFloat(t.AccruedInt)
+	if err != nil {
+		return core.MyTrade{}, err
+	}
+	var trade = core.MyTrade{
+		SecurityCode:      securityCode,
+		DateTime:          d,
+		ExecutionDate:     executionDate,
+		Price:             price,
+		Volume:            volume,
+		ExchangeComission: exchangeComission,
+		BrokerComission:   brokerComission,
+		AccruedInterest:   math.Abs(accruedInterest),
+		Account:           srv.account(t.AccountId),
+		TradeId:           t.TradeId,
+		Currency:          normalizeCurrency(t.Currency),
+		Note:              note,
+	}
+	return trade, nil
+}
+
+// splitIBCommission делит комиссию IB на комиссию брокера и сторонние сборы (биржа, клиринг, регулятор).
+// Комиссии в отчете IB отрицательные. Если детализации нет, вся ibCommission - комиссия брокера.
+func splitIBCommission(t ibFlexTrade) (broker, exchange float64, err error) {
+	total, err := parseIBFloat(t.IBCommission)
+	if err != nil {
+		return
+	}
+	var brokerParts, exchangeParts float64
+	for _, s := range []string{t.BrokerExecutionCommission, t.BrokerClearingCommission} {
+		v, err := parseIBFloat(s)
+		if err != nil {
+			return 0, 0, err
+		}
+		brokerParts += v
+	}
+	for _, s := range []string{t.ThirdPartyExecutionCommission, t.ThirdPartyClearingCommission,
+		t.ThirdPartyRegulatoryCommission, t.OtherCommission} {
+		v, err := parseIBFloat(s)
+		if err != nil {
+			return 0, 0, err
+		}
+		exchangeParts += v
+	}
+	if brokerParts == 0 && exchangeParts == 0 {
+		return -total, 0, nil
+	}
+	return -brokerParts, -exchangeParts, nil
+}
+
+func (srv *ibFlexReportImporter) importCashTransactions(items []ibFlexCashTransaction, result *ImportResult) {
+	type dividendKey struct {
+		account, securityCode, date string
+	}
+	var dividends = make(map[dividendKey]*core.DividendSchedule)
+	var dividendOrder []dividendKey
+	for i, c := range items {
+		if c.LevelOfDetail != "" && !strings.EqualFold(c.LevelOfDetail, "DETAIL") {
+			continue
+		}
+		d, err := parseIBDateTime(c.DateTime)
+		if err != nil {
+			result.addError(i+1, fmt.Errorf("CashTransactions: %v", err))
+			continue
+		}
+		amount, err := parseIBFloat(c.Amount)
+		if err != nil {
+			result.addError(i+1, fmt.Errorf("CashTransactions: %v", err))
+			continue
+		}
+		var account = srv.account(c.AccountId)
+		var cashType = strings.ToLower(c.Type)
+		var isDividend = strings.Contains(cashType, "dividend")
+		var isWithholding = strings.Contains(cashType, "withholding")
+		if (isDividend || isWithholding) && (c.Symbol != "" || c.Isin != "") {
+			securityCode, err := resolveSecurityCode(srv.securityInfoDirectory, c.Isin, c.Symbol, c.Description)
+			if err != nil {
+				result.addError(i+1, fmt.Errorf("CashTransactions: %v", err))
+				continue
+			}
+			var key = dividendKey{account, securityCode, date(d).Format(myTradeStorageDateLayout)}
+			var item, found = dividends[key]
+			if !found {
+				// дата отсечки во Flex CashTransactions отсутствует, используем дату выплаты
+				item = &core.DividendSchedule{
+					SecurityCode: securityCode,
+					RecordDate:   date(d),
+					ReceivedDividend: &core.ReceivedDividend{
+						Account:  account,
+						Date:     date(d),
+						Currency: normalizeCurrency(c.Currency),
+					},
+				}
+				dividends[key] = item
+				dividendOrder = append(dividendOrder, key)
+			}
+			// дивиденд и удержанный налог попадают только в Dividends, чтобы не учитывать их дважды
+			item.ReceivedDividend.Sum += amount
+			if isWithholding {
+				item.ReceivedDividend.Tax -= amount
+			}
+			continue
+		}
+		var kind core.CashMovementKind
+		switch {
+		case strings.Contains(cashType, "deposit") || strings.Contains(cashType, "withdraw"):
+			kind = core.CashDeposit
+			if amount < 0 {
+				kind = core.CashWithdrawal
+			}
+		case strings.Contains(cashType, "withholding") || strings.Contains(cashType, "tax"):
+			kind = core.CashTax
+		case strings.Contains(cashType, "interest"):
+			kind = core.CashInterest
+		case strings.Contains(cashType, "fee") || strings.Contains(cashType, "commission"):
+			kind = core.CashFee
+		default:
+			kind = core.CashOther
+		}
+		result.CashMovements = append(result.CashMovements, core.CashMovement{
+			Account:  account,
+			Date:     date(d),
+			Kind:     kind,
+			Sum:      amount,
+			Currency: normalizeCurrency(c.Currency),
+			Note:     c.Description,
+		})
+	}
+	for _, key := range dividendOrder {
+		var item = dividends[key]
+		item.ReceivedDividend.Sum = math.Round(item.ReceivedDividend.Sum*100) / 100
+		item.ReceivedDividend.Tax = math.Round(item.ReceivedDividend.Tax*100) / 100
+		result.Dividends = append(result.Dividends, *item)
+	}
+}
+
+// ibFlexCashCorporateActions типы корпоративных действий Flex, которые сводятся к движению денег.
+// Изменения позиций (сплиты, конвертации, выделения) нужно внести в CorporateActions.xml вручную.
+var ibFlexCashCorporateActions = map[string]core.CashMovementKind{
+	"BM": core.CashRedemption, // погашение облигации
+	"TC": core.CashOther,      // выкуп акций за деньги
+	"CA": core.CashOther,      // денежная выплата по корпоративному действию
+}
+
+// importCorporateAction деньги по корпоративному действию импортируются как движение денежных средств.
+// Изменение позиций и неизвестные типы возвращаются как ошибка, чтобы их не пропустить.
+func (srv *ibFlexReportImporter) importCorporateAction(a ibFlexCorporateAction, result *ImportResult) error {
+	d, err := parseIBDateTime(a.DateTime)
+	if err != nil {
+		return err
+	}
+	proceeds, err := parseIBFloat(a.Proceeds)
+	if err != nil {
+		return err
+	}
+	quantity, err := parseIBFloat(a.Quantity)
+	if err != nil {
+		return err
+	}
+	var kind, mapped = ibFlexCashCorporateActions[strings.ToUpper(strings.TrimSpace(a.Type))]
+	if !mapped {
+		kind = core.CashOther
+	}
+	if proceeds != 0 {
+		result.CashMovements = append(result.CashMovements, core.CashMovement{
+			Account:  srv.account(a.AccountId),
+			Date:     date(d),
+			Kind:     kind,
+			Sum:      proceeds,
+			Currency: normalizeCurrency(a.Currency),
+			Note:     a.Description,
+		})
+	}
+	if quantity != 0 {
+		return fmt.Errorf("corporate action %v changes position %v %v, add it to CorporateActions.xml: %v",
+			a.Type, a.Symbol, quantity, a.Description)
+	}
+	if !mapped {
+		return fmt.Errorf("corporate action type %q not supported: %v", a.Type, a.Description)
+	}
+	return nil
+}
+
+func parseIBFloat(s string) (float64, error) {
+	s = strings.Replace(strings.TrimSpace(s), ",", "", -1)
+	if s == "" {
+		return 0, nil
+	}
+	return strconv.ParseFloat(s, 64)
+}
+
+// parseIBDateTime разбирает даты Flex Query: "20200115;093501", "2020-01-15, 09:35:01", "20200115".
+func parseIBDateTime(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	var datePart, timePart = s, ""
+	if i := strings.IndexAny(s, "; ,"); i != -1 {
+		datePart = s[:i]
+		timePart = strings.Trim(s[i:], "; ,")
+	}
+	for _, layout := range []string{"20060102", "2006-01-02", "01/02/2006"} {
+		if d, err := time.Parse(layout, datePart); err == nil {
+			if timePart == "" {
+				return d, nil
+			}
+			return parseReportDateTime(d.Format(myTradeStorageDateLayout), timePart)
+		}
+	}
+	return time.Time{}, fmt.Errorf("parse date %q", s)
+}
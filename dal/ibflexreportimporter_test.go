@@ -0,0 +1,58 @@
+package dal
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ChizhovVadim/assets/core"
+)
+
+func TestIBFlexReportImporter(t *testing.T) {
+	var data = readBrokerReport(t, "ibflex.xml")
+	var srv = NewIBFlexReportImporter(testBrokerSecurities, map[string]string{"U0000001": "ib"})
+	if !srv.Detect(data) {
+		t.Fatal("flex query not detected")
+	}
+	result, err := srv.Import(data, ImportOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// сделки уровня ORDER и конвертации валют пропускаются
+	checkImportedTrades(t, result.Trades, []core.MyTrade{
+		{SecurityCode: "AAPL", DateTime: testDateTime(2023, 3, 15, 9, 35, 1), ExecutionDate: testDate(2023, 3, 17),
+			Price: 150.25, Volume: 10, ExchangeComission: 0.5, BrokerComission: 0.55,
+			Account: "ib", TradeId: "111", Currency: "USD"},
+		{SecurityCode: "SAP", DateTime: testDateTime(2023, 6, 1, 10, 0, 0), ExecutionDate: testDate(2023, 6, 5),
+			Price: 121.4, Volume: -4, BrokerComission: 2.5,
+			Account: "ib", TradeId: "112", Currency: "EUR", Note: "commission 3 USD"},
+	})
+	// дивиденд и налог с него - только в Dividends
+	checkImportedCashMovements(t, result.CashMovements, []core.CashMovement{
+		{Account: "ib", Date: testDate(2023, 1, 10), Kind: core.CashDeposit, Sum: 5000, Currency: "USD"},
+		{Account: "ib", Date: testDate(2023, 7, 5), Kind: core.CashFee, Sum: -10, Currency: "USD"},
+		{Account: "ib", Date: testDate(2023, 1, 15), Kind: core.CashRedemption, Sum: 1000, Currency: "USD"},
+	})
+	checkImportedDividends(t, result.Dividends, []core.DividendSchedule{
+		{SecurityCode: "AAPL", RecordDate: testDate(2023, 5, 18), ReceivedDividend: &core.ReceivedDividend{
+			Account: "ib", Date: testDate(2023, 5, 18), Sum: 2.16, Tax: 0.24, Currency: "USD"}},
+	})
+	var wantErrors = []struct {
+		line    int
+		message string
+	}{
+		{5, "Trades: multiplier not supported"},
+		{6, "Trades: commission currency GBP differs from trade currency EUR"},
+		{6, "CashTransactions: parse date"},
+		{1, "CorporateActions: corporate action SO changes position"},
+		{3, "CorporateActions: corporate action type \"IC\" not supported"},
+	}
+	if len(result.Errors) != len(wantErrors) {
+		t.Fatalf("got errors %v, want %v", result.Errors, wantErrors)
+	}
+	for i, want := range wantErrors {
+		var got = result.Errors[i]
+		if got.Line != want.line || !strings.HasPrefix(got.Err.Error(), want.message) {
+			t.Errorf("error %v: got %v, want line %v: %v", i, got, want.line, want.message)
+		}
+	}
+}
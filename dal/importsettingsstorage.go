@@ -0,0 +1,89 @@
+package dal
+
+import (
+	"os"
+	"strings"
+)
+
+// importSettingsStorage настройки импорта отчетов брокеров в XML:
+//
+//	<ImportSettings>
+//	  <Account Broker="sberbank" Contract="4000XXX" Name="sber"/>
+//	  <Account Broker="quik" Contract="L01-00000F00" Name="sber"/>
+//	  <Security Broker="quik" ClassCode="TQOB" Code="SU26207RMFS9" Name="OFZ26207"/>
+//	</ImportSettings>
+//
+// Contract - номер договора (счета) в отчете брокера, Name - Account, используемый в отчетах.
+// Security - соответствие кода бумаги брокера SecurityCode, ClassCode можно не указывать.
+type importSettingsStorage struct {
+	path string
+}
+
+func NewImportSettingsStorage(path string) *importSettingsStorage {
+	return &importSettingsStorage{path}
+}
+
+type importSettingsXml struct {
+	Accounts []struct {
+		Broker   string `xml:",attr"`
+		Contract string `xml:",attr"`
+		Name     string `xml:",attr"`
+	} `xml:"Account"`
+	Securities []struct {
+		Broker    string `xml:",attr"`
+		ClassCode string `xml:",attr"`
+		Code      string `xml:",attr"`
+		Name      string `xml:",attr"`
+	} `xml:"Security"`
+}
+
+// ImportSettings настройки импорта по брокерам.
+type ImportSettings struct {
+	accounts   map[string]map[string]string
+	securities map[string]map[string]string
+}
+
+// Read если файла нет, настройки пустые: Account - номер договора из отчета.
+func (srv *importSettingsStorage) Read() (ImportSettings, error) {
+	var result = ImportSettings{
+		accounts:   make(map[string]map[string]string),
+		securities: make(map[string]map[string]string),
+	}
+	var obj importSettingsXml
+	var err = decodeXmlFile(srv.path, &obj)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return result, nil
+		}
+		return ImportSettings{}, err
+	}
+	for _, item := range obj.Accounts {
+		addImportSetting(result.accounts, item.Broker, item.Contract, item.Name)
+	}
+	for _, item := range obj.Securities {
+		var key = item.Code
+		if item.ClassCode != "" {
+			key = item.ClassCode + ":" + item.Code
+		}
+		addImportSetting(result.securities, item.Broker, key, item.Name)
+	}
+	return result, nil
+}
+
+func addImportSetting(settings map[string]map[string]string, broker, key, value string) {
+	broker = strings.ToLower(broker)
+	if settings[broker] == nil {
+		settings[broker] = make(map[string]string)
+	}
+	settings[broker][key] = value
+}
+
+// Accounts номер договора брокера -> Account.
+func (s ImportSettings) Accounts(broker string) map[string]string {
+	return s.accounts[strings.ToLower(broker)]
+}
+
+// Securities код бумаги брокера ("КОД_КЛАССА:КОД_БУМАГИ" или "КОД_БУМАГИ") -> SecurityCode.
+func (s ImportSettings) Securities(broker string) map[string]string {
+	return s.securities[strings.ToLower(broker)]
+}
@@ -1,10 +1,10 @@
 package dal
 
 import (
+	"bytes"
 	"encoding/csv"
 	"fmt"
 	"io"
-	"os"
 	"strconv"
 	"strings"
 	"time"
@@ -18,28 +18,45 @@ func NewSberbankImportTradeService() *sberbankImportTradeService {
 	return &sberbankImportTradeService{}
 }
 
-func (srv *sberbankImportTradeService) LoadTrades(fileName string) ([]core.MyTrade, error) {
-	file, err := os.Open(fileName)
+func (srv *sberbankImportTradeService) Name() string {
+	return "sberbank-csv"
+}
+
+func (srv *sberbankImportTradeService) Detect(head []byte) bool {
+	reader := csv.NewReader(bytes.NewReader(trimUtf8Bom(head)))
+	reader.FieldsPerRecord = -1
+	reader.Read()
+	rec, err := reader.Read()
 	if err != nil {
-		return nil, err
+		return false
 	}
-	defer file.Close()
-	reader := csv.NewReader(file)
+	_, err = parseMyTradeSberbank(rec)
+	return err == nil
+}
+
+func (srv *sberbankImportTradeService) Import(data []byte, options ImportOptions) (ImportResult, error) {
+	reader := csv.NewReader(bytes.NewReader(trimUtf8Bom(data)))
+	reader.FieldsPerRecord = -1
 	reader.Read() //TODO param
-	var result []core.MyTrade
-	for {
+	var result ImportResult
+	for line := 2; ; line++ {
 		rec, err := reader.Read()
 		if err == io.EOF {
 			break
 		}
 		if err != nil {
-			return nil, err
+			if _, ok := err.(*csv.ParseError); ok {
+				result.addError(line, err)
+				continue
+			}
+			return ImportResult{}, err
 		}
 		t, err := parseMyTradeSberbank(rec)
 		if err != nil {
-			return nil, err
+			result.addError(line, err)
+			continue
 		}
-		result = append(result, t)
+		result.Trades = append(result.Trades, t)
 	}
 	return result, nil
 }
@@ -0,0 +1,211 @@
+package dal
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ChizhovVadim/assets/core"
+)
+
+const (
+	moexIssBaseUrl    = "https://iss.moex.com/iss"
+	moexDateLayout    = "2006-01-02"
+	moexDefaultMarket = "shares"
+	moexDefaultBoard  = "TQBR"
+)
+
+type moexHistoryCandleProvider struct {
+	securityInfoDirectory core.SecurityInfoDirectory
+	client                *http.Client
+	baseUrl               string
+}
+
+func NewMoexHistoryCandleProvider(securityInfoDirectory core.SecurityInfoDirectory) *moexHistoryCandleProvider {
+	return &moexHistoryCandleProvider{
+		securityInfoDirectory: securityInfoDirectory,
+		client: &http.Client{
+			Timeout: 25 * time.Second,
+		},
+		baseUrl: moexIssBaseUrl,
+	}
+}
+
+func (srv *moexHistoryCandleProvider) Load(ctx context.Context, securityCode string,
+	beginDate, endDate time.Time) ([]core.HistoryCandle, error) {
+
+	var market, board = moexDefaultMarket, moexDefaultBoard
+	if secInfo, found := srv.securityInfoDirectory.Read(securityCode); found {
+		if secInfo.Market != "" {
+			market = secInfo.Market
+		}
+		if secInfo.Board != "" {
+			board = secInfo.Board
+		}
+	}
+
+	var result []core.HistoryCandle
+	var start = 0
+	for {
+		var url, err = historyCandlesMoexUrl(srv.baseUrl, securityCode, market, board, beginDate, endDate, start)
+		if err != nil {
+			return nil, err
+		}
+		page, err := srv.getHistoryPage(ctx, url)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, page.candles...)
+		start += page.rows
+		if page.rows == 0 || start >= page.total {
+			break
+		}
+	}
+	if len(result) == 0 {
+		return nil, core.ErrNoData
+	}
+	return result, nil
+}
+
+func moexEngine(market string) string {
+	switch market {
+	case "selt":
+		return "currency"
+	case "forts", "futures":
+		return "futures"
+	}
+	return "stock"
+}
+
+func historyCandlesMoexUrl(baseUrl, securityCode, market, board string,
+	beginDate, endDate time.Time, start int) (string, error) {
+	u, err := url.Parse(fmt.Sprintf("%v/history/engines/%v/markets/%v/boards/%v/securities/%v.json",
+		baseUrl, moexEngine(market), market, board, url.PathEscape(securityCode)))
+	if err != nil {
+		return "", err
+	}
+	var params = url.Values{}
+	params.Set("iss.meta", "off")
+	params.Set("history.columns", "TRADEDATE,OPEN,HIGH,LOW,CLOSE,VOLUME")
+	params.Set("from", beginDate.Format(moexDateLayout))
+	params.Set("till", endDate.Format(moexDateLayout))
+	params.Set("start", strconv.Itoa(start))
+	u.RawQuery = params.Encode()
+	return u.String(), nil
+}
+
+type moexTable struct {
+	Columns []string        `json:"columns"`
+	Data    [][]interface{} `json:"data"`
+}
+
+func (t *moexTable) columnIndex(name string) int {
+	for i, c := range t.Columns {
+		if strings.EqualFold(c, name) {
+			return i
+		}
+	}
+	return -1
+}
+
+type moexHistoryPage struct {
+	candles []core.HistoryCandle
+	rows    int
+	total   int
+}
+
+func (srv *moexHistoryCandleProvider) getHistoryPage(ctx context.Context,
+	url string) (moexHistoryPage, error) {
+	resp, err := httpGet(ctx, srv.client, url)
+	if err != nil {
+		return moexHistoryPage{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return moexHistoryPage{}, fmt.Errorf("http status %v", resp.Status)
+	}
+
+	var obj = struct {
+		History moexTable `json:"history"`
+		Cursor  moexTable `json:"history.cursor"`
+	}{}
+	err = json.NewDecoder(resp.Body).Decode(&obj)
+	if err != nil {
+		return moexHistoryPage{}, err
+	}
+
+	var page = moexHistoryPage{rows: len(obj.History.Data)}
+	page.candles, err = parseMoexHistoryCandles(&obj.History)
+	if err != nil {
+		return moexHistoryPage{}, err
+	}
+	page.total = page.rows
+	if len(obj.Cursor.Data) > 0 {
+		var row = obj.Cursor.Data[0]
+		if total, ok := moexFloat(row, obj.Cursor.columnIndex("TOTAL")); ok {
+			page.total = int(total)
+		}
+	}
+	return page, nil
+}
+
+func parseMoexHistoryCandles(table *moexTable) ([]core.HistoryCandle, error) {
+	var (
+		dateCol   = table.columnIndex("TRADEDATE")
+		openCol   = table.columnIndex("OPEN")
+		highCol   = table.columnIndex("HIGH")
+		lowCol    = table.columnIndex("LOW")
+		closeCol  = table.columnIndex("CLOSE")
+		volumeCol = table.columnIndex("VOLUME")
+	)
+	if dateCol == -1 || closeCol == -1 {
+		return nil, fmt.Errorf("moex history columns not found %v", table.Columns)
+	}
+	var result []core.HistoryCandle
+	for _, row := range table.Data {
+		if dateCol >= len(row) {
+			return nil, fmt.Errorf("moex history row %v", row)
+		}
+		s, ok := row[dateCol].(string)
+		if !ok {
+			return nil, fmt.Errorf("moex history date %v", row[dateCol])
+		}
+		d, err := time.Parse(moexDateLayout, s)
+		if err != nil {
+			return nil, err
+		}
+		c, ok := moexFloat(row, closeCol)
+		if !ok {
+			// торгов в этот день не было
+			continue
+		}
+		var candle = core.HistoryCandle{DateTime: d, O: c, H: c, L: c, C: c}
+		if v, ok := moexFloat(row, openCol); ok {
+			candle.O = v
+		}
+		if v, ok := moexFloat(row, highCol); ok {
+			candle.H = v
+		}
+		if v, ok := moexFloat(row, lowCol); ok {
+			candle.L = v
+		}
+		if v, ok := moexFloat(row, volumeCol); ok {
+			candle.V = v
+		}
+		result = append(result, candle)
+	}
+	return result, nil
+}
+
+func moexFloat(row []interface{}, index int) (float64, bool) {
+	if index < 0 || index >= len(row) {
+		return 0, false
+	}
+	v, ok := row[index].(float64)
+	return v, ok
+}
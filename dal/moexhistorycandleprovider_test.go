@@ -0,0 +1,93 @@
+package dal
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ChizhovVadim/assets/core"
+)
+
+func TestMoexHistoryCandleProviderPaging(t *testing.T) {
+	const path = "/history/engines/futures/markets/forts/boards/RFUD/securities/SiH4.json"
+	var starts []string
+	var server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != path {
+			http.NotFound(w, r)
+			return
+		}
+		var start = r.URL.Query().Get("start")
+		starts = append(starts, start)
+		http.ServeFile(w, r, filepath.Join("testdata", "moex", "history_start"+start+".json"))
+	}))
+	defer server.Close()
+
+	var srv = NewMoexHistoryCandleProvider(testSecurityInfoDirectory{
+		{SecurityCode: "SiH4", Market: "forts", Board: "RFUD"},
+	})
+	srv.baseUrl = server.URL
+	candles, err := srv.Load(context.Background(), "SiH4",
+		time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 1, 10, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(starts) != 2 || starts[0] != "0" || starts[1] != "2" {
+		t.Errorf("requested pages %v, want [0 2]", starts)
+	}
+	var want = []core.HistoryCandle{
+		{DateTime: time.Date(2024, 1, 3, 0, 0, 0, 0, time.UTC), O: 90100, H: 90850, L: 89900, C: 90611, V: 412345},
+		{DateTime: time.Date(2024, 1, 4, 0, 0, 0, 0, time.UTC), O: 90611, H: 91290, L: 90400, C: 91110, V: 398721},
+		{DateTime: time.Date(2024, 1, 5, 0, 0, 0, 0, time.UTC), O: 91110, H: 91500, L: 90700, C: 90980, V: 356002},
+	}
+	if len(candles) != len(want) {
+		t.Fatalf("got %v candles, want %v", len(candles), len(want))
+	}
+	for i := range want {
+		if !candles[i].DateTime.Equal(want[i].DateTime) || candles[i].O != want[i].O ||
+			candles[i].H != want[i].H || candles[i].L != want[i].L ||
+			candles[i].C != want[i].C || candles[i].V != want[i].V {
+			t.Errorf("candle %v: got %+v, want %+v", i, candles[i], want[i])
+		}
+	}
+}
+
+func TestMoexEngine(t *testing.T) {
+	var tests = []struct {
+		market, engine string
+	}{
+		{"shares", "stock"},
+		{"bonds", "stock"},
+		{"index", "stock"},
+		{"selt", "currency"},
+		{"forts", "futures"},
+		{"futures", "futures"},
+	}
+	for _, test := range tests {
+		if engine := moexEngine(test.market); engine != test.engine {
+			t.Errorf("moexEngine(%q) = %q, want %q", test.market, engine, test.engine)
+		}
+	}
+}
+
+func TestMoexHistoryCandleProviderCanceled(t *testing.T) {
+	var requests = 0
+	var server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+	}))
+	defer server.Close()
+
+	var srv = NewMoexHistoryCandleProvider(testSecurityInfoDirectory{})
+	srv.baseUrl = server.URL
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := srv.Load(withRateLimiter(ctx, newRateLimiter(1)), "SBER", time.Now(), time.Now())
+	if err != context.Canceled {
+		t.Errorf("got error %v, want %v", err, context.Canceled)
+	}
+	if requests != 0 {
+		t.Errorf("got %v requests after cancel", requests)
+	}
+}
@@ -1,6 +1,11 @@
 package dal
 
 import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"os"
 	"strings"
 	"time"
 
@@ -40,16 +45,125 @@ func (srv *myDividendStorage) ReadReceivedDividends(account string,
 	return result, nil
 }
 
-func loadMyDividends(path string) ([]core.DividendSchedule, error) {
-	const DateLayout = "2006-01-02"
-	type myDividend struct {
-		Account      string  `xml:",attr"`
-		SecurityCode string  `xml:"Name,attr"`
-		RecordDate   string  `xml:",attr"`
-		Rate         float64 `xml:",attr"`
-		RecieveDate  string  `xml:",attr"`
-		RecieveSum   float64 `xml:",attr"`
+const myDividendDateLayout = "2006-01-02"
+
+type myDividend struct {
+	XMLName      xml.Name `xml:"Dividend"`
+	Account      string   `xml:",attr,omitempty"`
+	SecurityCode string   `xml:"Name,attr"`
+	RecordDate   string   `xml:",attr"`
+	Rate         float64  `xml:",attr,omitempty"`
+	RecieveDate  string   `xml:",attr,omitempty"`
+	RecieveSum   float64  `xml:",attr,omitempty"`
+	RecieveTax   float64  `xml:",attr,omitempty"`
+	Currency     string   `xml:",attr,omitempty"`
+}
+
+// myDividendFile документ Dividends.xml целиком: элементы и атрибуты, неизвестные хранилищу,
+// сохраняются при перезаписи без изменений.
+type myDividendFile struct {
+	XMLName xml.Name
+	Attrs   []xml.Attr      `xml:",any,attr"`
+	Items   []xmlRawElement `xml:",any"`
+}
+
+type myDividendDocument struct {
+	XMLName xml.Name
+	Attrs   []xml.Attr    `xml:",any,attr"`
+	Items   []interface{} `xml:",any"`
+}
+
+type xmlRawElement struct {
+	XMLName xml.Name
+	Attrs   []xml.Attr `xml:",any,attr"`
+	Inner   []byte     `xml:",innerxml"`
+}
+
+// AddReceivedDividends добавляет полученные дивиденды в конец файла, существующие записи не меняются.
+// Выплата считается уже записанной, если совпадают счет, бумага, дата, валюта и сумма,
+// поэтому несколько разных выплат по бумаге в один день сохраняются.
+// Если файла нет, он создается. Возвращает число добавленных записей.
+func (srv *myDividendStorage) AddReceivedDividends(items []core.DividendSchedule) (int, error) {
+	unlock, err := lockFile(srv.path)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+	var doc = myDividendFile{XMLName: xml.Name{Local: "Dividends"}}
+	err = decodeXmlFile(srv.path, &doc)
+	if err != nil && !os.IsNotExist(err) {
+		return 0, err
+	}
+	existing, err := loadMyDividends(srv.path)
+	if err != nil && !os.IsNotExist(err) {
+		return 0, err
 	}
+	var keys = make(map[string]int)
+	for _, d := range existing {
+		if d.ReceivedDividend != nil {
+			keys[receivedDividendKey(d)]++
+		}
+	}
+	var result = myDividendDocument{XMLName: doc.XMLName, Attrs: doc.Attrs}
+	for _, item := range doc.Items {
+		result.Items = append(result.Items, item)
+	}
+	var added = 0
+	for _, d := range items {
+		if d.ReceivedDividend == nil {
+			continue
+		}
+		var key = receivedDividendKey(d)
+		if keys[key] > 0 {
+			keys[key]--
+			continue
+		}
+		result.Items = append(result.Items, myDividend{
+			Account:      d.ReceivedDividend.Account,
+			SecurityCode: d.SecurityCode,
+			RecordDate:   d.RecordDate.Format(myDividendDateLayout),
+			Rate:         d.Rate,
+			RecieveDate:  d.ReceivedDividend.Date.Format(myDividendDateLayout),
+			RecieveSum:   d.ReceivedDividend.Sum,
+			RecieveTax:   d.ReceivedDividend.Tax,
+			Currency:     d.ReceivedDividend.Currency,
+		})
+		added++
+	}
+	if added == 0 {
+		return 0, nil
+	}
+	err = writeFileAtomic(srv.path, func(w io.Writer) error {
+		if _, err := io.WriteString(w, xml.Header); err != nil {
+			return err
+		}
+		var encoder = xml.NewEncoder(w)
+		encoder.Indent("", "  ")
+		if err := encoder.Encode(result); err != nil {
+			return err
+		}
+		_, err := io.WriteString(w, "\n")
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return added, nil
+}
+
+// receivedDividendKey выплата: счет, бумага, дата, валюта и сумма в копейках.
+func receivedDividendKey(d core.DividendSchedule) string {
+	var currency = normalizeCurrency(d.ReceivedDividend.Currency)
+	if currency == "" {
+		currency = "RUB"
+	}
+	return fmt.Sprintf("%v|%v|%v|%v|%v", strings.ToLower(d.ReceivedDividend.Account), d.SecurityCode,
+		d.ReceivedDividend.Date.Format(myDividendDateLayout), currency,
+		int64(math.Round(d.ReceivedDividend.Sum*100)))
+}
+
+func loadMyDividends(path string) ([]core.DividendSchedule, error) {
+	const DateLayout = myDividendDateLayout
 	var obj = struct {
 		Items []myDividend `xml:"Dividend"`
 	}{}
@@ -73,9 +187,11 @@ func loadMyDividends(path string) ([]core.DividendSchedule, error) {
 				return nil, err
 			}
 			receivedDividend = &core.ReceivedDividend{
-				Account: item.Account,
-				Date:    recieveDate,
-				Sum:     item.RecieveSum,
+				Account:  item.Account,
+				Date:     recieveDate,
+				Sum:      item.RecieveSum,
+				Tax:      item.RecieveTax,
+				Currency: item.Currency,
 			}
 		}
 		dividends = append(dividends, core.DividendSchedule{
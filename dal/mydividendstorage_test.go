@@ -0,0 +1,131 @@
+package dal
+
+import (
+	"encoding/xml"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ChizhovVadim/assets/core"
+)
+
+func testReceivedDividend(securityCode, account string, sum float64, currency string) core.DividendSchedule {
+	return core.DividendSchedule{
+		SecurityCode: securityCode,
+		RecordDate:   testDate(2021, 5, 10),
+		ReceivedDividend: &core.ReceivedDividend{
+			Account:  account,
+			Date:     testDate(2021, 5, 20),
+			Sum:      sum,
+			Currency: currency,
+		},
+	}
+}
+
+func TestAddReceivedDividendsCreatesFile(t *testing.T) {
+	var srv = NewMyDividendStorage(filepath.Join(t.TempDir(), "Dividends.xml"))
+	added, err := srv.AddReceivedDividends([]core.DividendSchedule{
+		testReceivedDividend("SBER", "sber", 1834, "RUB"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if added != 1 {
+		t.Errorf("got %v added, want 1", added)
+	}
+	dd, err := srv.ReadReceivedDividends("sber", testDate(2021, 1, 1), testDate(2021, 12, 31))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dd) != 1 || dd[0].Sum != 1834 || dd[0].Currency != "RUB" {
+		t.Errorf("got %+v", dd)
+	}
+}
+
+func TestAddReceivedDividendsSameDay(t *testing.T) {
+	var srv = NewMyDividendStorage(filepath.Join(t.TempDir(), "Dividends.xml"))
+	var items = []core.DividendSchedule{
+		// специальный и обычный дивиденд в один день, две одинаковые частичные выплаты
+		testReceivedDividend("AAPL", "ib", 12.5, "USD"),
+		testReceivedDividend("AAPL", "ib", 3, "USD"),
+		testReceivedDividend("AAPL", "ib", 3, "USD"),
+		testReceivedDividend("AAPL", "ib", 3, "EUR"),
+		testReceivedDividend("AAPL", "tinkoff", 3, "USD"),
+	}
+	added, err := srv.AddReceivedDividends(items)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if added != len(items) {
+		t.Errorf("got %v added, want %v", added, len(items))
+	}
+	// повторный импорт того же отчета ничего не добавляет, новая выплата добавляется
+	added, err = srv.AddReceivedDividends(append(items, testReceivedDividend("AAPL", "ib", 3, "USD")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if added != 1 {
+		t.Errorf("reimport: got %v added, want 1", added)
+	}
+	dd, err := srv.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dd) != len(items)+1 {
+		t.Errorf("got %v dividends, want %v", len(dd), len(items)+1)
+	}
+}
+
+func TestAddReceivedDividendsKeepsDocument(t *testing.T) {
+	var path = filepath.Join(t.TempDir(), "Dividends.xml")
+	var content = `<?xml version="1.0" encoding="utf-8"?>
+<Dividends Owner="me">
+  <!-- ожидаемые выплаты -->
+  <Dividend Name="GAZP" RecordDate="2021-07-15" Rate="12.55" Comment="прогноз"/>
+  <Dividend Account="sber" Name="SBER" RecordDate="2021-05-10" RecieveDate="2021-05-20" RecieveSum="1834"/>
+  <Note>ручные записи</Note>
+</Dividends  >
+<!-- конец -->
+`
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var srv = NewMyDividendStorage(path)
+	added, err := srv.AddReceivedDividends([]core.DividendSchedule{
+		testReceivedDividend("SBER", "sber", 1834, ""),
+		testReceivedDividend("LKOH", "sber", 2130, "RUB"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if added != 1 {
+		t.Errorf("got %v added, want 1", added)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc struct {
+		XMLName xml.Name
+		Owner   string `xml:",attr"`
+		Items   []struct {
+			XMLName xml.Name
+			Name    string `xml:",attr"`
+			Comment string `xml:",attr"`
+			Text    string `xml:",chardata"`
+		} `xml:",any"`
+	}
+	if err := xml.Unmarshal(data, &doc); err != nil {
+		t.Fatalf("%v\n%s", err, data)
+	}
+	var names []string
+	for _, item := range doc.Items {
+		names = append(names, item.XMLName.Local+":"+item.Name+item.Text)
+	}
+	if doc.XMLName.Local != "Dividends" || doc.Owner != "me" ||
+		strings.Join(names, ",") != "Dividend:GAZP,Dividend:SBER,Note:ручные записи,Dividend:LKOH" ||
+		doc.Items[0].Comment != "прогноз" {
+		t.Errorf("document changed:\n%s", data)
+	}
+}
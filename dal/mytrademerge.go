@@ -0,0 +1,97 @@
+package dal
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/ChizhovVadim/assets/core"
+)
+
+type MyTradeConflict struct {
+	Existing core.MyTrade
+	Imported core.MyTrade
+}
+
+type MyTradeMergeResult struct {
+	New        []core.MyTrade
+	Duplicates []core.MyTrade
+	Conflicts  []MyTradeConflict
+	Merged     []core.MyTrade
+}
+
+// MergeMyTrades добавляет к existing новые сделки из imported.
+// Ключ сделки - номер сделки брокера. Если номера нет хотя бы у одной из сделок
+// (старые записи trades.csv), сделки сравниваются по счету, времени, бумаге, цене и объему.
+// Одинаковые сделки (частичные исполнения) учитываются по количеству.
+// Если по ключу сделка уже есть, но отличаются остальные поля, это конфликт
+// и сделка не добавляется.
+func MergeMyTrades(existing, imported []core.MyTrade) MyTradeMergeResult {
+	// существующие сделки индексируются по обоим ключам, каждая сопоставляется не более одного раза
+	var used = make([]bool, len(existing))
+	var byId = make(map[string][]int)
+	var byFields = make(map[string][]int)
+	for i, t := range existing {
+		if t.TradeId != "" {
+			var key = myTradeIdKey(t)
+			byId[key] = append(byId[key], i)
+		}
+		var key = myTradeFieldsKey(t)
+		byFields[key] = append(byFields[key], i)
+	}
+	var find = func(t core.MyTrade) int {
+		if t.TradeId != "" {
+			for _, i := range byId[myTradeIdKey(t)] {
+				if !used[i] {
+					return i
+				}
+			}
+		}
+		for _, i := range byFields[myTradeFieldsKey(t)] {
+			if !used[i] && (t.TradeId == "" || existing[i].TradeId == "") {
+				return i
+			}
+		}
+		return -1
+	}
+	var result MyTradeMergeResult
+	for _, t := range imported {
+		var i = find(t)
+		if i == -1 {
+			result.New = append(result.New, t)
+			continue
+		}
+		used[i] = true
+		if isSameMyTrade(existing[i], t) {
+			result.Duplicates = append(result.Duplicates, t)
+		} else {
+			result.Conflicts = append(result.Conflicts, MyTradeConflict{Existing: existing[i], Imported: t})
+		}
+	}
+	result.Merged = make([]core.MyTrade, 0, len(existing)+len(result.New))
+	result.Merged = append(result.Merged, existing...)
+	result.Merged = append(result.Merged, result.New...)
+	sort.SliceStable(result.Merged, func(i, j int) bool {
+		return result.Merged[i].DateTime.Before(result.Merged[j].DateTime)
+	})
+	return result
+}
+
+func myTradeIdKey(t core.MyTrade) string {
+	return fmt.Sprintf("%v|id|%v", strings.ToLower(t.Account), t.TradeId)
+}
+
+func myTradeFieldsKey(t core.MyTrade) string {
+	return fmt.Sprintf("%v|%v|%v|%v|%v",
+		strings.ToLower(t.Account), t.DateTime.Format(myTradeStorageDateTimeLayout),
+		t.SecurityCode, t.Price, t.Volume)
+}
+
+func isSameMyTrade(a, b core.MyTrade) bool {
+	return a.SecurityCode == b.SecurityCode &&
+		a.DateTime.Equal(b.DateTime) &&
+		a.Price == b.Price &&
+		a.Volume == b.Volume &&
+		a.ExchangeComission == b.ExchangeComission &&
+		a.BrokerComission == b.BrokerComission
+}
@@ -0,0 +1,83 @@
+package dal
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ChizhovVadim/assets/core"
+)
+
+func TestMergeMyTrades(t *testing.T) {
+	var d = time.Date(2020, 3, 2, 10, 30, 0, 0, time.UTC)
+	var legacy = []core.MyTrade{
+		{SecurityCode: "SBER", DateTime: d, Price: 250, Volume: 10, Account: "main", BrokerComission: 1},
+		{SecurityCode: "SBER", DateTime: d, Price: 250, Volume: 10, Account: "main", BrokerComission: 1},
+		{SecurityCode: "GAZP", DateTime: d.Add(time.Hour), Price: 180, Volume: -5, Account: "main", TradeId: "7"},
+	}
+	var tests = []struct {
+		name       string
+		imported   []core.MyTrade
+		new        int
+		duplicates int
+		conflicts  int
+	}{
+		{
+			name: "legacy rows without id vs imported with id",
+			imported: []core.MyTrade{
+				{SecurityCode: "SBER", DateTime: d, Price: 250, Volume: 10, Account: "MAIN", BrokerComission: 1, TradeId: "1"},
+				{SecurityCode: "SBER", DateTime: d, Price: 250, Volume: 10, Account: "main", BrokerComission: 1, TradeId: "2"},
+			},
+			duplicates: 2,
+		},
+		{
+			name: "third partial fill is new",
+			imported: []core.MyTrade{
+				{SecurityCode: "SBER", DateTime: d, Price: 250, Volume: 10, Account: "main", BrokerComission: 1, TradeId: "1"},
+				{SecurityCode: "SBER", DateTime: d, Price: 250, Volume: 10, Account: "main", BrokerComission: 1, TradeId: "2"},
+				{SecurityCode: "SBER", DateTime: d, Price: 250, Volume: 10, Account: "main", BrokerComission: 1, TradeId: "3"},
+			},
+			new:        1,
+			duplicates: 2,
+		},
+		{
+			name: "same id",
+			imported: []core.MyTrade{
+				{SecurityCode: "GAZP", DateTime: d.Add(time.Hour), Price: 180, Volume: -5, Account: "main", TradeId: "7"},
+			},
+			duplicates: 1,
+		},
+		{
+			name: "imported without id vs existing with id",
+			imported: []core.MyTrade{
+				{SecurityCode: "GAZP", DateTime: d.Add(time.Hour), Price: 180, Volume: -5, Account: "main"},
+			},
+			duplicates: 1,
+		},
+		{
+			name: "different ids with same fields",
+			imported: []core.MyTrade{
+				{SecurityCode: "GAZP", DateTime: d.Add(time.Hour), Price: 180, Volume: -5, Account: "main", TradeId: "8"},
+			},
+			new: 1,
+		},
+		{
+			name: "same id, other commission",
+			imported: []core.MyTrade{
+				{SecurityCode: "GAZP", DateTime: d.Add(time.Hour), Price: 180, Volume: -5, Account: "main", TradeId: "7", BrokerComission: 2},
+			},
+			conflicts: 1,
+		},
+	}
+	for _, test := range tests {
+		var result = MergeMyTrades(legacy, test.imported)
+		if len(result.New) != test.new ||
+			len(result.Duplicates) != test.duplicates ||
+			len(result.Conflicts) != test.conflicts {
+			t.Errorf("%v: new %v duplicates %v conflicts %v", test.name,
+				len(result.New), len(result.Duplicates), len(result.Conflicts))
+		}
+		if len(result.Merged) != len(legacy)+len(result.New) {
+			t.Errorf("%v: merged %v", test.name, len(result.Merged))
+		}
+	}
+}
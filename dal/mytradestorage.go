@@ -1,6 +1,7 @@
 package dal
 
 import (
+	"bufio"
 	"encoding/csv"
 	"fmt"
 	"io"
@@ -15,8 +16,51 @@ import (
 const (
 	myTradeStorageDateTimeLayout = "2006-01-02T15:04:05"
 	myTradeStorageDateLayout     = "2006-01-02"
+	myTradeStorageVersion        = 2
+	myTradeStorageVersionPrefix  = "#version="
 )
 
+const (
+	myTradeColumnSecurityCode      = "SecurityCode"
+	myTradeColumnDateTime          = "DateTime"
+	myTradeColumnExecutionDate     = "ExecutionDate"
+	myTradeColumnPrice             = "Price"
+	myTradeColumnVolume            = "Volume"
+	myTradeColumnExchangeComission = "ExchangeComission"
+	myTradeColumnBrokerComission   = "BrokerComission"
+	myTradeColumnAccount           = "Account"
+	myTradeColumnTradeId           = "TradeId"
+	myTradeColumnCurrency          = "Currency"
+	myTradeColumnAccruedInterest   = "AccruedInterest"
+	myTradeColumnLots              = "Lots"
+	myTradeColumnNote              = "Note"
+)
+
+// Порядок колонок при записи. Первые 8 совпадают с форматом версии 1 (без заголовка).
+var myTradeColumns = []string{
+	myTradeColumnSecurityCode,
+	myTradeColumnDateTime,
+	myTradeColumnExecutionDate,
+	myTradeColumnPrice,
+	myTradeColumnVolume,
+	myTradeColumnExchangeComission,
+	myTradeColumnBrokerComission,
+	myTradeColumnAccount,
+	myTradeColumnTradeId,
+	myTradeColumnCurrency,
+	myTradeColumnAccruedInterest,
+	myTradeColumnLots,
+	myTradeColumnNote,
+}
+
+var myTradeRequiredColumns = []string{
+	myTradeColumnSecurityCode,
+	myTradeColumnDateTime,
+	myTradeColumnExecutionDate,
+	myTradeColumnPrice,
+	myTradeColumnVolume,
+}
+
 type myTradeStorage struct {
 	path string
 }
@@ -31,35 +75,132 @@ func (srv *myTradeStorage) Read(account string) ([]core.MyTrade, error) {
 		return nil, err
 	}
 	defer file.Close()
-	csv := csv.NewReader(file)
-	csv.Read() // skip fst line
+	tt, err := readMyTrades(file)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", srv.path, err)
+	}
+	var result []core.MyTrade
+	for _, t := range tt {
+		if account == "" || strings.EqualFold(t.Account, account) {
+			result = append(result, t)
+		}
+	}
+	return result, nil
+}
+
+func readMyTrades(r io.Reader) ([]core.MyTrade, error) {
+	var reader = bufio.NewReader(r)
+	version, lineOffset, err := readMyTradeStorageVersion(reader)
+	if err != nil {
+		return nil, err
+	}
+	if version > myTradeStorageVersion {
+		return nil, fmt.Errorf("unsupported trades version %v", version)
+	}
+	csv := csv.NewReader(reader)
+	csv.FieldsPerRecord = -1
 	var result []core.MyTrade
+	first, err := csv.Read()
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, storageCsvError(err, lineOffset)
+	}
+	var columns myTradeColumnIndex
+	if isMyTradeHeader(first) {
+		columns, err = newMyTradeColumnIndex(first)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		// версия 1: колонки по позиции. Заголовок распознается только по именам колонок,
+		// иначе первая строка - сделка и ошибка разбора не скрывается.
+		columns = newLegacyMyTradeColumnIndex()
+		t, err := parseMyTrade(first, columns)
+		if err != nil {
+			return nil, fmt.Errorf("line %v: %v", storageCsvLine(csv, lineOffset), err)
+		}
+		result = append(result, t)
+	}
 	for {
 		rec, err := csv.Read()
 		if err == io.EOF {
 			break
 		}
 		if err != nil {
-			return nil, err
+			return nil, storageCsvError(err, lineOffset)
 		}
-		t, err := parseMyTrade(rec)
+		t, err := parseMyTrade(rec, columns)
 		if err != nil {
-			return nil, err
-		}
-		if account == "" || strings.EqualFold(t.Account, account) {
-			result = append(result, t)
+			return nil, fmt.Errorf("line %v: %v", storageCsvLine(csv, lineOffset), err)
 		}
+		result = append(result, t)
 	}
 	return result, nil
 }
 
-func (srv *myTradeStorage) Update(trades []core.MyTrade) error {
-	file, err := os.Create(srv.path)
+// readMyTradeStorageVersion читает строку версии, lines - количество прочитанных строк файла.
+func readMyTradeStorageVersion(reader *bufio.Reader) (version, lines int, err error) {
+	prefix, err := reader.Peek(len(myTradeStorageVersionPrefix))
+	if err != nil || string(prefix) != myTradeStorageVersionPrefix {
+		return 1, 0, nil
+	}
+	line, err := reader.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return 0, 0, err
+	}
+	version, err = strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, myTradeStorageVersionPrefix)))
+	return version, 1, err
+}
+
+// storageCsvLine номер строки файла текущей записи: csv.Reader не видит строку версии.
+func storageCsvLine(reader *csv.Reader, lineOffset int) int {
+	var line, _ = reader.FieldPos(0)
+	return line + lineOffset
+}
+
+func storageCsvError(err error, lineOffset int) error {
+	if parseError, ok := err.(*csv.ParseError); ok {
+		var result = *parseError
+		result.StartLine += lineOffset
+		result.Line += lineOffset
+		return &result
+	}
+	return err
+}
+
+// Update читает все сделки, передает их в update и записывает результат под одной блокировкой,
+// чтобы параллельные изменения не терялись. Если update вернул nil, файл не меняется.
+func (srv *myTradeStorage) Update(update func(trades []core.MyTrade) []core.MyTrade) error {
+	unlock, err := lockFile(srv.path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	existing, err := srv.Read("")
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	var trades = update(existing)
+	if trades == nil {
+		return nil
+	}
+	return writeFileAtomic(srv.path, func(w io.Writer) error {
+		return writeMyTrades(w, trades)
+	})
+}
+
+func writeMyTrades(w io.Writer, trades []core.MyTrade) error {
+	_, err := fmt.Fprintf(w, "%v%v\n", myTradeStorageVersionPrefix, myTradeStorageVersion)
+	if err != nil {
+		return err
+	}
+	writer := csv.NewWriter(w)
+	err = writer.Write(myTradeColumns)
 	if err != nil {
 		return err
 	}
-	defer file.Close()
-	writer := csv.NewWriter(file)
 	for _, t := range trades {
 		rec := []string{
 			t.SecurityCode,
@@ -70,8 +211,13 @@ func (srv *myTradeStorage) Update(trades []core.MyTrade) error {
 			strconv.FormatFloat(t.ExchangeComission, 'g', -1, 64),
 			strconv.FormatFloat(t.BrokerComission, 'g', -1, 64),
 			t.Account,
+			t.TradeId,
+			t.Currency,
+			strconv.FormatFloat(t.AccruedInterest, 'g', -1, 64),
+			formatLotSelections(t.Lots),
+			t.Note,
 		}
-		err = writer.Write(rec)
+		err := writer.Write(rec)
 		if err != nil {
 			return err
 		}
@@ -80,36 +226,88 @@ func (srv *myTradeStorage) Update(trades []core.MyTrade) error {
 	return writer.Error()
 }
 
-func parseMyTrade(record []string) (core.MyTrade, error) {
-	if len(record) < 8 {
-		return core.MyTrade{}, fmt.Errorf("parseMyTrade len %v", record)
+type myTradeColumnIndex map[string]int
+
+func isMyTradeHeader(record []string) bool {
+	return len(record) > 0 &&
+		strings.EqualFold(strings.TrimSpace(record[0]), myTradeColumnSecurityCode)
+}
+
+func newMyTradeColumnIndex(header []string) (myTradeColumnIndex, error) {
+	var result = make(myTradeColumnIndex)
+	for i, name := range header {
+		result[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, name := range myTradeRequiredColumns {
+		if _, found := result[strings.ToLower(name)]; !found {
+			return nil, fmt.Errorf("trades column not found %v", name)
+		}
+	}
+	return result, nil
+}
+
+func newLegacyMyTradeColumnIndex() myTradeColumnIndex {
+	var result = make(myTradeColumnIndex)
+	for i, name := range myTradeColumns[:8] {
+		result[strings.ToLower(name)] = i
+	}
+	return result
+}
+
+func (columns myTradeColumnIndex) get(record []string, name string) string {
+	var index, found = columns[strings.ToLower(name)]
+	if !found || index >= len(record) {
+		return ""
+	}
+	return record[index]
+}
+
+func parseMyTradeFloat(s string) (float64, error) {
+	if s == "" {
+		return 0, nil
 	}
-	securityCode := record[0]
-	d, err := time.Parse(myTradeStorageDateTimeLayout, record[1])
+	return strconv.ParseFloat(s, 64)
+}
+
+func parseMyTrade(record []string, columns myTradeColumnIndex) (core.MyTrade, error) {
+	for _, name := range myTradeRequiredColumns {
+		if columns.get(record, name) == "" {
+			return core.MyTrade{}, fmt.Errorf("parseMyTrade %v empty %v", name, record)
+		}
+	}
+	securityCode := columns.get(record, myTradeColumnSecurityCode)
+	d, err := time.Parse(myTradeStorageDateTimeLayout, columns.get(record, myTradeColumnDateTime))
+	if err != nil {
+		return core.MyTrade{}, err
+	}
+	execDate, err := time.Parse(myTradeStorageDateLayout, columns.get(record, myTradeColumnExecutionDate))
+	if err != nil {
+		return core.MyTrade{}, err
+	}
+	price, err := strconv.ParseFloat(columns.get(record, myTradeColumnPrice), 64)
 	if err != nil {
 		return core.MyTrade{}, err
 	}
-	execDate, err := time.Parse(myTradeStorageDateLayout, record[2])
+	volume, err := strconv.Atoi(columns.get(record, myTradeColumnVolume))
 	if err != nil {
 		return core.MyTrade{}, err
 	}
-	price, err := strconv.ParseFloat(record[3], 64)
+	exCom, err := parseMyTradeFloat(columns.get(record, myTradeColumnExchangeComission))
 	if err != nil {
 		return core.MyTrade{}, err
 	}
-	volume, err := strconv.Atoi(record[4])
+	brCom, err := parseMyTradeFloat(columns.get(record, myTradeColumnBrokerComission))
 	if err != nil {
 		return core.MyTrade{}, err
 	}
-	exCom, err := strconv.ParseFloat(record[5], 64)
+	accruedInterest, err := parseMyTradeFloat(columns.get(record, myTradeColumnAccruedInterest))
 	if err != nil {
 		return core.MyTrade{}, err
 	}
-	brCom, err := strconv.ParseFloat(record[6], 64)
+	lots, err := parseLotSelections(columns.get(record, myTradeColumnLots))
 	if err != nil {
 		return core.MyTrade{}, err
 	}
-	account := record[7]
 	return core.MyTrade{
 		SecurityCode:      securityCode,
 		DateTime:          d,
@@ -118,6 +316,43 @@ func parseMyTrade(record []string) (core.MyTrade, error) {
 		Volume:            volume,
 		ExchangeComission: exCom,
 		BrokerComission:   brCom,
-		Account:           account,
+		Account:           columns.get(record, myTradeColumnAccount),
+		TradeId:           columns.get(record, myTradeColumnTradeId),
+		Currency:          columns.get(record, myTradeColumnCurrency),
+		AccruedInterest:   accruedInterest,
+		Lots:              lots,
+		Note:              columns.get(record, myTradeColumnNote),
 	}, nil
 }
+
+// parseLotSelections разбирает выбор лотов вида "TradeId:10;2020-01-15:5".
+func parseLotSelections(s string) ([]core.LotSelection, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+	var result []core.LotSelection
+	for _, item := range strings.Split(s, ";") {
+		var index = strings.LastIndex(item, ":")
+		if index == -1 {
+			return nil, fmt.Errorf("parseLotSelections bad lot %q", item)
+		}
+		volume, err := strconv.Atoi(strings.TrimSpace(item[index+1:]))
+		if err != nil {
+			return nil, err
+		}
+		var lot = strings.TrimSpace(item[:index])
+		if lot == "" || volume <= 0 {
+			return nil, fmt.Errorf("parseLotSelections bad lot %q", item)
+		}
+		result = append(result, core.LotSelection{Lot: lot, Volume: volume})
+	}
+	return result, nil
+}
+
+func formatLotSelections(lots []core.LotSelection) string {
+	var items = make([]string, len(lots))
+	for i, lot := range lots {
+		items[i] = lot.Lot + ":" + strconv.Itoa(lot.Volume)
+	}
+	return strings.Join(items, ";")
+}
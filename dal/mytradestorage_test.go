@@ -0,0 +1,90 @@
+package dal
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestReadMyTradesErrorLine(t *testing.T) {
+	var tests = []struct {
+		name string
+		text string
+		line string
+	}{
+		{
+			name: "version 2",
+			text: "#version=2\n" +
+				"SecurityCode,DateTime,ExecutionDate,Price,Volume,ExchangeComission,BrokerComission,Account\n" +
+				"SBER,2020-03-02T10:30:00,2020-03-04,250,10,0.5,1,main\n" +
+				"GAZP,2020-03-02T11:30:00,2020-03-04,abc,5,0.5,1,main\n",
+			line: "line 4:",
+		},
+		{
+			name: "version 1",
+			text: "SBER,2020-03-02T10:30:00,2020-03-04,250,10,0.5,1,main\n" +
+				"GAZP,2020-03-02T11:30:00,2020-03-04,180,5,0.5,1,main\n" +
+				"LKOH,2020-03-02T12:30:00,,5000,1,0.5,1,main\n",
+			line: "line 3:",
+		},
+		{
+			name: "version 1 first trade",
+			text: "SBER,2020-03-02,2020-03-04,250,10,0.5,1,main\n" +
+				"GAZP,2020-03-02T11:30:00,2020-03-04,180,5,0.5,1,main\n",
+			line: "line 1:",
+		},
+		{
+			name: "version 1 unknown header",
+			text: "Ticker,Date,Settlement,Price,Volume\n" +
+				"GAZP,2020-03-02T11:30:00,2020-03-04,180,5,0.5,1,main\n",
+			line: "line 1:",
+		},
+		{
+			name: "csv error",
+			text: "#version=2\n" +
+				"SecurityCode,DateTime,ExecutionDate,Price,Volume\n" +
+				"SBER,2020-03-02T10:30:00,2020-03-04,250,10\n" +
+				"\"GAZP,2020-03-02T11:30:00,2020-03-04,180,5\n",
+			line: "line 4",
+		},
+	}
+	for _, test := range tests {
+		_, err := readMyTrades(strings.NewReader(test.text))
+		if err == nil {
+			t.Errorf("%v: expected error", test.name)
+			continue
+		}
+		if !strings.Contains(err.Error(), test.line) {
+			t.Errorf("%v: got %v, want %v", test.name, err, test.line)
+		}
+	}
+}
+
+func TestReadMyTradesVersion1Header(t *testing.T) {
+	var tests = []struct {
+		name string
+		text string
+	}{
+		{
+			name: "without header",
+			text: "SBER,2020-03-02T10:30:00,2020-03-04,250,10,0.5,1,main\n" +
+				"GAZP,2020-03-02T11:30:00,2020-03-04,180,-5,0.5,1,main\n",
+		},
+		{
+			name: "known header",
+			text: "securitycode,datetime,executiondate,price,volume,exchangecomission,brokercomission,account\n" +
+				"SBER,2020-03-02T10:30:00,2020-03-04,250,10,0.5,1,main\n" +
+				"GAZP,2020-03-02T11:30:00,2020-03-04,180,-5,0.5,1,main\n",
+		},
+	}
+	for _, test := range tests {
+		trades, err := readMyTrades(strings.NewReader(test.text))
+		if err != nil {
+			t.Errorf("%v: %v", test.name, err)
+			continue
+		}
+		if len(trades) != 2 || trades[0].SecurityCode != "SBER" || trades[1].Volume != -5 ||
+			trades[1].Account != "main" {
+			t.Errorf("%v: got %+v", test.name, trades)
+		}
+	}
+}
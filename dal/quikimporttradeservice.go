@@ -0,0 +1,234 @@
+package dal
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/ChizhovVadim/assets/core"
+)
+
+// quikImportTradeService разбирает экспорт таблицы "Сделки" терминала QUIK (CSV/TXT).
+type quikImportTradeService struct {
+	securityInfoDirectory core.SecurityInfoDirectory
+	accounts              map[string]string
+	securities            map[string]string
+}
+
+// accounts: торговый счет или код клиента QUIK -> Account.
+// securities: "КОД_КЛАССА:КОД_БУМАГИ" или "КОД_БУМАГИ" -> SecurityCode, для бумаг,
+// код которых в QUIK не совпадает с SecurityCode (например облигации TQOB/TQCB).
+func NewQuikImportTradeService(securityInfoDirectory core.SecurityInfoDirectory,
+	accounts, securities map[string]string) *quikImportTradeService {
+	return &quikImportTradeService{
+		securityInfoDirectory: securityInfoDirectory,
+		accounts:              accounts,
+		securities:            securities,
+	}
+}
+
+func (srv *quikImportTradeService) Name() string {
+	return "quik"
+}
+
+func (srv *quikImportTradeService) Detect(head []byte) bool {
+	var header = normalizeReportText(firstLine(decodeText(head)))
+	return strings.Contains(header, "код класса") &&
+		strings.Contains(header, "заявк") &&
+		strings.Contains(header, "цена")
+}
+
+var quikTradeColumns = reportTradeColumns{
+	date:              []string{"Дата", "Дата сделки", "Дата торгов"},
+	time:              []string{"Время"},
+	settlementDate:    []string{"Дата расчетов", "Дата исполнения"},
+	isin:              []string{"ISIN"},
+	securityCode:      []string{"Код бумаги", "Код инструмента", "Бумага код", "Инструмент"},
+	securityName:      []string{"Бумага", "Бумага сокр.", "Наименование"},
+	side:              []string{"Операция", "Направление"},
+	volume:            []string{"Кол-во", "Количество"},
+	price:             []string{"Цена"},
+	currency:          []string{"Валюта", "Валюта расчетов"},
+	brokerComission:   []string{"Комиссия брокера", "Ком. брокера"},
+	exchangeComission: []string{"Комиссия ТС", "Комиссия торговой системы", "Комиссия биржи"},
+	clearingComission: []string{"Клиринговая комиссия", "Комиссия за ИТС"},
+	accruedInterest:   []string{"НКД", "Накопленный купонный доход"},
+	tradeId:           []string{"Номер", "Номер сделки"},
+	account:           []string{"Торговый счет", "Счет", "Код клиента"},
+}
+
+var (
+	quikClassCodeColumns   = []string{"Код класса", "Класс"}
+	quikOrderColumns       = []string{"Заявка", "Номер заявки"}
+	quikSecurityVolColumns = []string{"Кол-во бумаг", "Количество бумаг"}
+)
+
+func (srv *quikImportTradeService) Import(data []byte, options ImportOptions) (ImportResult, error) {
+	var text = decodeText(data)
+	var reader = csv.NewReader(strings.NewReader(text))
+	reader.Comma = detectCsvDelimiter(firstLine(text))
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	header, err := reader.Read()
+	if err != nil {
+		return ImportResult{}, err
+	}
+	var columns = newReportColumnIndex(header)
+	if !quikTradeColumns.match(columns) {
+		return ImportResult{}, fmt.Errorf("quik trades columns not found %v", header)
+	}
+	var result ImportResult
+	var orders []string
+	for line := 2; ; line++ {
+		rec, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if _, ok := err.(*csv.ParseError); ok {
+				result.addError(line, err)
+				continue
+			}
+			return ImportResult{}, err
+		}
+		var row = reportRow{line: line, cells: rec}
+		if len(nonEmptyCells(row)) == 0 {
+			continue
+		}
+		t, err := srv.parseTrade(row, columns)
+		if err != nil {
+			result.addError(line, err)
+			continue
+		}
+		result.Trades = append(result.Trades, t)
+		orders = append(orders, row.get(columns.lookup(quikOrderColumns...)))
+	}
+	if options.AggregateOrders {
+		result.Trades = aggregateOrderTrades(result.Trades, orders)
+	}
+	return result, nil
+}
+
+func (srv *quikImportTradeService) parseTrade(row reportRow, columns reportColumnIndex) (core.MyTrade, error) {
+	t, err := parseReportTrade(srv.securityInfoDirectory, row, columns, &quikTradeColumns)
+	if err != nil {
+		return core.MyTrade{}, err
+	}
+	var classCode = strings.TrimSpace(row.get(columns.lookup(quikClassCodeColumns...)))
+	var ticker = strings.TrimSpace(row.get(columns.lookup(quikTradeColumns.securityCode...)))
+	t.SecurityCode = srv.securityCode(classCode, ticker, t.SecurityCode)
+
+	// "Кол-во" в QUIK - в лотах
+	if s := row.get(columns.lookup(quikSecurityVolColumns...)); s != "" {
+		volume, err := parseReportInt(s)
+		if err != nil {
+			return core.MyTrade{}, err
+		}
+		if t.Volume < 0 {
+			volume = -volume
+		}
+		t.Volume = volume
+	} else if info, found := srv.securityInfoDirectory.Read(t.SecurityCode); found && info.LotSize > 1 {
+		t.Volume *= info.LotSize
+	}
+	if t.Currency == "SUR" {
+		t.Currency = "RUB"
+	}
+	if account := row.get(columns.lookup(quikTradeColumns.account...)); account != "" {
+		t.Account = mapAccount(srv.accounts, account)
+	}
+	return t, nil
+}
+
+func (srv *quikImportTradeService) securityCode(classCode, ticker, defaultCode string) string {
+	if securityCode, found := srv.securities[classCode+":"+ticker]; found {
+		return securityCode
+	}
+	if securityCode, found := srv.securities[ticker]; found {
+		return securityCode
+	}
+	if info, found := srv.securityInfoDirectory.FindByIsin(ticker); found {
+		return info.SecurityCode
+	}
+	return defaultCode
+}
+
+// aggregateOrderTrades объединяет сделки одной заявки (orders[i] - номер заявки сделки trades[i])
+// в одну сделку со средневзвешенной ценой, суммарными комиссиями и НКД.
+func aggregateOrderTrades(trades []core.MyTrade, orders []string) []core.MyTrade {
+	type orderKey struct {
+		account, order, securityCode string
+		sell                         bool
+	}
+	type orderItem struct {
+		trade  core.MyTrade
+		amount float64
+	}
+	var items = make(map[orderKey]*orderItem)
+	var result []core.MyTrade
+	var keys []orderKey
+	for i, t := range trades {
+		if orders[i] == "" {
+			result = append(result, t)
+			continue
+		}
+		var key = orderKey{t.Account, orders[i], t.SecurityCode, t.Volume < 0}
+		var item, found = items[key]
+		if !found {
+			item = &orderItem{trade: t}
+			item.trade.Volume = 0
+			item.trade.ExchangeComission = 0
+			item.trade.BrokerComission = 0
+			item.trade.AccruedInterest = 0
+			item.trade.TradeId = "order-" + orders[i]
+			items[key] = item
+			keys = append(keys, key)
+		}
+		if t.DateTime.Before(item.trade.DateTime) {
+			item.trade.DateTime = t.DateTime
+		}
+		if t.ExecutionDate.After(item.trade.ExecutionDate) {
+			item.trade.ExecutionDate = t.ExecutionDate
+		}
+		item.trade.Volume += t.Volume
+		item.trade.ExchangeComission += t.ExchangeComission
+		item.trade.BrokerComission += t.BrokerComission
+		item.trade.AccruedInterest += t.AccruedInterest
+		item.amount += t.Price * float64(t.Volume)
+	}
+	for _, key := range keys {
+		var item = items[key]
+		if item.trade.Volume != 0 {
+			item.trade.Price = math.Round(item.amount/float64(item.trade.Volume)*1e6) / 1e6
+		}
+		item.trade.ExchangeComission = roundComission(item.trade.ExchangeComission)
+		item.trade.BrokerComission = roundComission(item.trade.BrokerComission)
+		item.trade.AccruedInterest = math.Round(item.trade.AccruedInterest*100) / 100
+		result = append(result, item.trade)
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].DateTime.Before(result[j].DateTime)
+	})
+	return result
+}
+
+func firstLine(text string) string {
+	if i := strings.IndexAny(text, "\r\n"); i != -1 {
+		return text[:i]
+	}
+	return text
+}
+
+func detectCsvDelimiter(header string) rune {
+	var result = ','
+	var best = strings.Count(header, ",")
+	for _, r := range []rune{';', '\t'} {
+		if n := strings.Count(header, string(r)); n > best {
+			result, best = r, n
+		}
+	}
+	return result
+}
@@ -0,0 +1,83 @@
+package dal
+
+import (
+	"bytes"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/ChizhovVadim/assets/core"
+)
+
+func TestQuikImportTradeService(t *testing.T) {
+	var data = readBrokerReport(t, "quik.csv")
+	var srv = NewQuikImportTradeService(testBrokerSecurities,
+		map[string]string{"L01-00000F00": "sber"},
+		map[string]string{"TQOB:SU26207RMFS9": "OFZ26207"})
+	if !srv.Detect(data) {
+		t.Fatal("quik export not detected")
+	}
+	var tests = []struct {
+		name    string
+		options ImportOptions
+		trades  []core.MyTrade
+	}{
+		{
+			name: "fills",
+			trades: []core.MyTrade{
+				{SecurityCode: "SBER", DateTime: testDateTime(2020, 3, 2, 10, 30, 1), ExecutionDate: testDate(2020, 3, 4),
+					Price: 250.5, Volume: 10, ExchangeComission: 0.25, BrokerComission: 0.75,
+					Account: "sber", TradeId: "5001", Currency: "RUB"},
+				{SecurityCode: "SBER", DateTime: testDateTime(2020, 3, 2, 10, 30, 2), ExecutionDate: testDate(2020, 3, 4),
+					Price: 250.6, Volume: 20, ExchangeComission: 0.5, BrokerComission: 1.5,
+					Account: "sber", TradeId: "5002", Currency: "RUB"},
+				{SecurityCode: "OFZ26207", DateTime: testDateTime(2020, 3, 3, 11, 0, 0), ExecutionDate: testDate(2020, 3, 4),
+					Price: 101.25, Volume: -3, ExchangeComission: 0.3, BrokerComission: 0.9, AccruedInterest: 12.3,
+					Account: "sber", TradeId: "5003", Currency: "RUB"},
+				{SecurityCode: "OFZ26207", DateTime: testDateTime(2020, 3, 3, 11, 0, 5), ExecutionDate: testDate(2020, 3, 4),
+					Price: 101.25, Volume: -2, ExchangeComission: 0.2, BrokerComission: 0.6, AccruedInterest: 8.2,
+					Account: "sber", TradeId: "5004", Currency: "RUB"},
+			},
+		},
+		{
+			name:    "aggregate orders",
+			options: ImportOptions{AggregateOrders: true},
+			trades: []core.MyTrade{
+				{SecurityCode: "SBER", DateTime: testDateTime(2020, 3, 2, 10, 30, 1), ExecutionDate: testDate(2020, 3, 4),
+					Price: 250.566667, Volume: 30, ExchangeComission: 0.75, BrokerComission: 2.25,
+					Account: "sber", TradeId: "order-77001", Currency: "RUB"},
+				{SecurityCode: "OFZ26207", DateTime: testDateTime(2020, 3, 3, 11, 0, 0), ExecutionDate: testDate(2020, 3, 4),
+					Price: 101.25, Volume: -5, ExchangeComission: 0.5, BrokerComission: 1.5, AccruedInterest: 20.5,
+					Account: "sber", TradeId: "order-77002", Currency: "RUB"},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result, err := srv.Import(data, test.options)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkImportedTrades(t, result.Trades, test.trades)
+			checkImportedCashMovements(t, result.CashMovements, nil)
+			// цена не число
+			checkImportErrors(t, result.Errors, []int{6})
+		})
+	}
+}
+
+// Начало выгрузки в UTF-8 для Detect обрезано посреди буквы.
+func TestQuikImportTradeServiceDetectTruncatedHead(t *testing.T) {
+	var data = []byte(decodeText(readBrokerReport(t, "quik.csv")))
+	var index = bytes.Index(data, []byte("Купля"))
+	if index == -1 {
+		t.Fatal("fixture changed")
+	}
+	var head = data[:index+1]
+	if utf8.Valid(head) {
+		t.Fatal("head must end inside a rune")
+	}
+	var srv = NewQuikImportTradeService(testBrokerSecurities, nil, nil)
+	if !srv.Detect(head) {
+		t.Error("quik export with truncated head not detected")
+	}
+}
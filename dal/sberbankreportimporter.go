@@ -0,0 +1,144 @@
+package dal
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/ChizhovVadim/assets/core"
+)
+
+// sberbankReportImporter разбирает стандартный отчет брокера Сбербанка (HTML и XML).
+type sberbankReportImporter struct {
+	securityInfoDirectory core.SecurityInfoDirectory
+	accounts              map[string]string
+}
+
+// accounts: номер договора из отчета -> Account. Если договор не найден, Account = номер договора.
+func NewSberbankReportImporter(securityInfoDirectory core.SecurityInfoDirectory,
+	accounts map[string]string) *sberbankReportImporter {
+	return &sberbankReportImporter{
+		securityInfoDirectory: securityInfoDirectory,
+		accounts:              accounts,
+	}
+}
+
+func (srv *sberbankReportImporter) Name() string {
+	return "sberbank"
+}
+
+func (srv *sberbankReportImporter) Detect(head []byte) bool {
+	var text = strings.ToLower(decodeText(head))
+	return (strings.Contains(text, "сбербанк") || strings.Contains(text, "sberbank")) &&
+		(strings.Contains(text, "<html") || strings.Contains(text, "<table") || strings.Contains(text, "<?xml"))
+}
+
+var sberbankTradeColumns = reportTradeColumns{
+	date:              []string{"Дата заключения", "deal_date", "conclusion_date"},
+	time:              []string{"Время заключения", "deal_time", "conclusion_time"},
+	settlementDate:    []string{"Дата расчетов", "Плановая дата поставки", "settlement_date", "execution_date"},
+	isin:              []string{"ISIN", "ISIN ценной бумаги", "isin"},
+	securityCode:      []string{"Код ЦБ", "Код финансового инструмента", "Тикер", "security_code", "ticker"},
+	securityName:      []string{"Наименование ЦБ", "Наименование", "security_name"},
+	side:              []string{"Вид", "Вид сделки", "Операция", "buy_sell", "direction"},
+	volume:            []string{"Количество, шт.", "Количество", "quantity"},
+	price:             []string{"Цена", "Цена за единицу", "price"},
+	currency:          []string{"Валюта цены", "Валюта", "currency"},
+	brokerComission:   []string{"Комиссия Брокера", "broker_commission"},
+	exchangeComission: []string{"Комиссия Биржи", "exchange_commission"},
+	accruedInterest:   []string{"НКД", "Накопленный купонный доход", "accrued_interest"},
+	tradeId:           []string{"Номер сделки", "deal_number", "trade_id"},
+	account:           []string{"Номер договора", "Договор", "account"},
+}
+
+var sberbankCashColumns = reportCashColumns{
+	date:      []string{"Дата", "Дата операции", "date"},
+	operation: []string{"Описание операции", "Операция", "Основание", "operation", "description"},
+	currency:  []string{"Валюта", "currency"},
+	credit:    []string{"Сумма зачисления", "credit"},
+	debit:     []string{"Сумма списания", "debit"},
+	sum:       []string{"Сумма", "amount"},
+	account:   []string{"Номер договора", "Договор", "account"},
+}
+
+var contractRegexp = regexp.MustCompile(`(?i)договор[^0-9A-ZА-Я]{0,20}([0-9][0-9A-Z/-]*)`)
+
+func findContract(text string) string {
+	var match = contractRegexp.FindStringSubmatch(text)
+	if len(match) < 2 {
+		return ""
+	}
+	return match[1]
+}
+
+func (srv *sberbankReportImporter) Import(data []byte, options ImportOptions) (ImportResult, error) {
+	var text = decodeText(data)
+	var tables []reportTable
+	var err error
+	if isHtmlReport(text) {
+		tables, err = parseHtmlTables(text)
+	} else {
+		tables, err = parseXmlTables(text)
+	}
+	if err != nil {
+		return ImportResult{}, err
+	}
+	var contract = findContract(text)
+	var result ImportResult
+	for i := range tables {
+		var table = &tables[i]
+		var columns = newReportColumnIndex(table.header)
+		if sberbankTradeColumns.match(columns) {
+			srv.importTrades(table, columns, contract, &result)
+		} else if sberbankCashColumns.match(columns) {
+			srv.importCashMovements(table, columns, contract, &result)
+		}
+	}
+	return result, nil
+}
+
+func (srv *sberbankReportImporter) rowAccount(row reportRow, index int, contract string) string {
+	if s := row.get(index); s != "" {
+		contract = s
+	}
+	if contract == "" {
+		return ""
+	}
+	return mapAccount(srv.accounts, contract)
+}
+
+func (srv *sberbankReportImporter) importTrades(table *reportTable, columns reportColumnIndex,
+	contract string, result *ImportResult) {
+	var accountIndex = columns.lookup(sberbankTradeColumns.account...)
+	for _, row := range table.rows {
+		if isReportTotalRow(row) {
+			continue
+		}
+		t, err := parseReportTrade(srv.securityInfoDirectory, row, columns, &sberbankTradeColumns)
+		if err != nil {
+			result.addError(row.line, err)
+			continue
+		}
+		t.Account = srv.rowAccount(row, accountIndex, contract)
+		result.Trades = append(result.Trades, t)
+	}
+}
+
+func (srv *sberbankReportImporter) importCashMovements(table *reportTable, columns reportColumnIndex,
+	contract string, result *ImportResult) {
+	var accountIndex = columns.lookup(sberbankCashColumns.account...)
+	for _, row := range table.rows {
+		if isReportTotalRow(row) {
+			continue
+		}
+		m, ok, err := parseReportCashMovement(srv.securityInfoDirectory, row, columns, &sberbankCashColumns)
+		if err != nil {
+			result.addError(row.line, err)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		m.Account = srv.rowAccount(row, accountIndex, contract)
+		result.addCashMovement(m)
+	}
+}
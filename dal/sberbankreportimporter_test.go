@@ -0,0 +1,75 @@
+package dal
+
+import (
+	"bytes"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/ChizhovVadim/assets/core"
+)
+
+func TestSberbankReportImporter(t *testing.T) {
+	var tests = []struct {
+		file string
+		// неизвестный вид сделки и несуществующая дата
+		errorLines []int
+	}{
+		// номер строки HTML - строка файла, XML - номер записи в разделе
+		{"sberbank.html", []int{10, 21}},
+		{"sberbank.xml", []int{3, 6}},
+	}
+	var srv = NewSberbankReportImporter(testBrokerSecurities, map[string]string{"4000T7Q": "sber"})
+	for _, test := range tests {
+		t.Run(test.file, func(t *testing.T) {
+			var data = readBrokerReport(t, test.file)
+			if !srv.Detect(data) {
+				t.Fatal("sberbank report not detected")
+			}
+			result, err := srv.Import(data, ImportOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkSberbankReport(t, result)
+			checkImportErrors(t, result.Errors, test.errorLines)
+		})
+	}
+}
+
+func checkSberbankReport(t *testing.T, result ImportResult) {
+	t.Helper()
+	checkImportedTrades(t, result.Trades, []core.MyTrade{
+		{SecurityCode: "SBER", DateTime: testDateTime(2020, 3, 2, 10, 30, 15), ExecutionDate: testDate(2020, 3, 4),
+			Price: 250.5, Volume: 10, ExchangeComission: 0.25, BrokerComission: 0.75,
+			Account: "sber", TradeId: "1001", Currency: "RUB"},
+		{SecurityCode: "OFZ26207", DateTime: testDateTime(2020, 3, 3, 11, 0, 0), ExecutionDate: testDate(2020, 3, 4),
+			Price: 101.25, Volume: -5, ExchangeComission: 0.51, BrokerComission: 1.27, AccruedInterest: 12.5,
+			Account: "sber", TradeId: "1002", Currency: "RUB"},
+	})
+	checkImportedCashMovements(t, result.CashMovements, []core.CashMovement{
+		{Account: "sber", Date: testDate(2020, 3, 1), Kind: core.CashDeposit, Sum: 10000, Currency: "RUB"},
+		{Account: "sber", Date: testDate(2020, 8, 5), Kind: core.CashCoupon, Sum: 204.45, Currency: "RUB",
+			SecurityCode: "OFZ26207"},
+		{Account: "sber", Date: testDate(2020, 8, 31), Kind: core.CashFee, Sum: -150, Currency: "RUB"},
+	})
+	checkImportedDividends(t, result.Dividends, []core.DividendSchedule{
+		{SecurityCode: "SBER", RecordDate: testDate(2020, 7, 15), ReceivedDividend: &core.ReceivedDividend{
+			Account: "sber", Date: testDate(2020, 7, 15), Sum: 162.54, Currency: "RUB"}},
+	})
+}
+
+// Detect получает начало файла, обрезанное по importSniffSize, в том числе посреди символа.
+func TestSberbankReportImporterDetectTruncatedHead(t *testing.T) {
+	var data = readBrokerReport(t, "sberbank.html")
+	var index = bytes.Index(data, []byte("Сделки"))
+	if index == -1 {
+		t.Fatal("fixture changed")
+	}
+	var head = data[:index+1]
+	if utf8.Valid(head) {
+		t.Fatal("head must end inside a rune")
+	}
+	var srv = NewSberbankReportImporter(testBrokerSecurities, nil)
+	if !srv.Detect(head) {
+		t.Error("sberbank report with truncated head not detected")
+	}
+}
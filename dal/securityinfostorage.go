@@ -2,7 +2,11 @@ package dal
 
 import (
 	"encoding/xml"
+	"fmt"
 	"os"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/ChizhovVadim/assets/core"
 )
@@ -15,15 +19,96 @@ func NewSecurityInfoStorage(path string) *securityInfoStorage {
 	return &securityInfoStorage{path}
 }
 
+// Параметры облигации задаются атрибутами и вложенными элементами:
+//
+//	<SecurityInfo Name="SU26207RMFS9" FaceValue="1000" Maturity="2027-02-03">
+//	  <Coupon Date="2021-08-18" Sum="40.89"/>
+//	  <Amortization Date="2027-02-03" Sum="1000"/>
+//	</SecurityInfo>
+type securityInfoXml struct {
+	core.SecurityInfo
+	FaceValue     float64          `xml:",attr"`
+	IssueDate     string           `xml:",attr"`
+	Maturity      string           `xml:",attr"`
+	Government    bool             `xml:",attr"`
+	Coupons       []bondPaymentXml `xml:"Coupon"`
+	Amortizations []bondPaymentXml `xml:"Amortization"`
+}
+
+type bondPaymentXml struct {
+	Date string  `xml:",attr"`
+	Sum  float64 `xml:",attr"`
+}
+
+const securityInfoDateLayout = "2006-01-02"
+
 func (srv *securityInfoStorage) ReadAll() ([]core.SecurityInfo, error) {
 	var obj = struct {
-		Items []core.SecurityInfo `xml:"SecurityInfo"`
+		Items []securityInfoXml `xml:"SecurityInfo"`
 	}{}
 	var err = decodeXmlFile(srv.path, &obj)
 	if err != nil {
 		return nil, err
 	}
-	return obj.Items, nil
+	var result = make([]core.SecurityInfo, 0, len(obj.Items))
+	for _, item := range obj.Items {
+		var info = item.SecurityInfo
+		if item.FaceValue != 0 {
+			info.Bond, err = parseBondInfo(item)
+			if err != nil {
+				return nil, fmt.Errorf("%v: %v", info.SecurityCode, err)
+			}
+		}
+		result = append(result, info)
+	}
+	return result, nil
+}
+
+func parseBondInfo(item securityInfoXml) (*core.BondInfo, error) {
+	var result = &core.BondInfo{
+		FaceValue:  item.FaceValue,
+		Government: item.Government,
+	}
+	var err error
+	result.IssueDate, err = parseOptionalDate(item.IssueDate)
+	if err != nil {
+		return nil, err
+	}
+	result.Maturity, err = parseOptionalDate(item.Maturity)
+	if err != nil {
+		return nil, err
+	}
+	result.Coupons, err = parseBondPayments(item.Coupons)
+	if err != nil {
+		return nil, err
+	}
+	result.Amortizations, err = parseBondPayments(item.Amortizations)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func parseOptionalDate(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(securityInfoDateLayout, s)
+}
+
+func parseBondPayments(items []bondPaymentXml) ([]core.BondPayment, error) {
+	var result []core.BondPayment
+	for _, item := range items {
+		d, err := time.Parse(securityInfoDateLayout, item.Date)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, core.BondPayment{Date: d, Sum: item.Sum})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Date.Before(result[j].Date)
+	})
+	return result, nil
 }
 
 func decodeXmlFile(filePath string, v interface{}) error {
@@ -36,21 +121,32 @@ func decodeXmlFile(filePath string, v interface{}) error {
 }
 
 type securityInfoDirectory struct {
-	items map[string]core.SecurityInfo
+	items  map[string]core.SecurityInfo
+	byIsin map[string]core.SecurityInfo
 }
 
-func NewSecurityInfoDirectory(securityInfoStorage core.SecurityInfoStorage) *securityInfoDirectory {
-	var items = make(map[string]core.SecurityInfo)
+func NewSecurityInfoDirectory(securityInfoStorage core.SecurityInfoStorage) (*securityInfoDirectory, error) {
 	var ss, err = securityInfoStorage.ReadAll()
-	if err == nil {
-		for _, s := range ss {
-			items[s.SecurityCode] = s
+	if err != nil {
+		return nil, err
+	}
+	var items = make(map[string]core.SecurityInfo)
+	var byIsin = make(map[string]core.SecurityInfo)
+	for _, s := range ss {
+		items[s.SecurityCode] = s
+		if s.Isin != "" {
+			byIsin[strings.ToUpper(s.Isin)] = s
 		}
 	}
-	return &securityInfoDirectory{items}
+	return &securityInfoDirectory{items, byIsin}, nil
 }
 
 func (srv *securityInfoDirectory) Read(securityCode string) (core.SecurityInfo, bool) {
 	var item, found = srv.items[securityCode]
 	return item, found
 }
+
+func (srv *securityInfoDirectory) FindByIsin(isin string) (core.SecurityInfo, bool) {
+	var item, found = srv.byIsin[strings.ToUpper(isin)]
+	return item, found
+}
@@ -0,0 +1,15 @@
+package dal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNewSecurityInfoDirectoryReadError(t *testing.T) {
+	var storage = NewSecurityInfoStorage(filepath.Join(t.TempDir(), "StockSettings.xml"))
+	var directory, err = NewSecurityInfoDirectory(storage)
+	if !os.IsNotExist(err) || directory != nil {
+		t.Errorf("got %v %v, want not exist error", directory, err)
+	}
+}
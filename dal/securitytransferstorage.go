@@ -0,0 +1,123 @@
+package dal
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/ChizhovVadim/assets/core"
+)
+
+const securityTransferStorageVersion = 1
+
+var securityTransferColumns = []string{
+	"Date",
+	"SecurityCode",
+	"Volume",
+	"FromAccount",
+	"ToAccount",
+	"Note",
+}
+
+// securityTransferStorage переводы бумаг между счетами в CSV с заголовком, как cash.csv.
+type securityTransferStorage struct {
+	path string
+}
+
+func NewSecurityTransferStorage(path string) *securityTransferStorage {
+	return &securityTransferStorage{path}
+}
+
+// Read возвращает переводы по дате. Если файла нет, переводов нет.
+func (srv *securityTransferStorage) Read() ([]core.SecurityTransfer, error) {
+	file, err := os.Open(srv.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+	items, err := readSecurityTransfers(file)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", srv.path, err)
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		return items[i].Date.Before(items[j].Date)
+	})
+	return items, nil
+}
+
+func readSecurityTransfers(r io.Reader) ([]core.SecurityTransfer, error) {
+	var reader = bufio.NewReader(r)
+	version, lineOffset, err := readMyTradeStorageVersion(reader)
+	if err != nil {
+		return nil, err
+	}
+	if version > securityTransferStorageVersion {
+		return nil, fmt.Errorf("unsupported transfers version %v", version)
+	}
+	csv := csv.NewReader(reader)
+	csv.FieldsPerRecord = -1
+	header, err := csv.Read()
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, storageCsvError(err, lineOffset)
+	}
+	var columns = newReportColumnIndex(header)
+	for _, name := range securityTransferColumns[:5] {
+		if columns.lookup(name) == -1 {
+			return nil, fmt.Errorf("transfers column not found %v", name)
+		}
+	}
+	var result []core.SecurityTransfer
+	for {
+		rec, err := csv.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, storageCsvError(err, lineOffset)
+		}
+		var line = storageCsvLine(csv, lineOffset)
+		item, err := parseSecurityTransfer(reportRow{line: line, cells: rec}, columns)
+		if err != nil {
+			return nil, fmt.Errorf("line %v: %v", line, err)
+		}
+		result = append(result, item)
+	}
+	return result, nil
+}
+
+func parseSecurityTransfer(row reportRow, columns reportColumnIndex) (core.SecurityTransfer, error) {
+	d, err := time.Parse(myTradeStorageDateLayout, row.get(columns.lookup("Date")))
+	if err != nil {
+		return core.SecurityTransfer{}, err
+	}
+	volume, err := strconv.Atoi(row.get(columns.lookup("Volume")))
+	if err != nil {
+		return core.SecurityTransfer{}, err
+	}
+	if volume <= 0 {
+		return core.SecurityTransfer{}, fmt.Errorf("bad volume %v", volume)
+	}
+	var item = core.SecurityTransfer{
+		Date:         d,
+		SecurityCode: row.get(columns.lookup("SecurityCode")),
+		Volume:       volume,
+		FromAccount:  row.get(columns.lookup("FromAccount")),
+		ToAccount:    row.get(columns.lookup("ToAccount")),
+		Note:         row.get(columns.lookup("Note")),
+	}
+	if item.SecurityCode == "" || item.FromAccount == "" || item.ToAccount == "" {
+		return core.SecurityTransfer{}, fmt.Errorf("empty security or account")
+	}
+	return item, nil
+}
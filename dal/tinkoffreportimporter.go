@@ -0,0 +1,201 @@
+package dal
+
+import (
+	"strings"
+
+	"github.com/ChizhovVadim/assets/core"
+)
+
+// tinkoffReportImporter разбирает брокерский отчет Тинькофф (Т-Банк) в формате XLSX.
+// Отчет - один лист с разделами, сделки берутся только из раздела исполненных сделок.
+type tinkoffReportImporter struct {
+	securityInfoDirectory core.SecurityInfoDirectory
+	accounts              map[string]string
+}
+
+// accounts: номер договора из отчета -> Account. Если договор не найден, Account = номер договора.
+func NewTinkoffReportImporter(securityInfoDirectory core.SecurityInfoDirectory,
+	accounts map[string]string) *tinkoffReportImporter {
+	return &tinkoffReportImporter{
+		securityInfoDirectory: securityInfoDirectory,
+		accounts:              accounts,
+	}
+}
+
+func (srv *tinkoffReportImporter) Name() string {
+	return "tinkoff"
+}
+
+// tinkoffReportSheet имя листа брокерского отчета Тинькофф.
+const tinkoffReportSheet = "broker_rep"
+
+// Detect принимает XLSX с листом broker_rep или с заголовком раздела сделок Тинькофф,
+// другие XLSX файлы не подходят.
+func (srv *tinkoffReportImporter) Detect(head []byte) bool {
+	if !isXlsx(head) {
+		return false
+	}
+	sheets, err := readXlsxSheets(head)
+	if err != nil {
+		return false
+	}
+	for _, sheet := range sheets {
+		if strings.EqualFold(sheet.name, tinkoffReportSheet) {
+			return true
+		}
+		for _, row := range sheet.rows {
+			if tinkoffTradeColumns.match(newReportColumnIndex(row.cells)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+var tinkoffTradeColumns = reportTradeColumns{
+	date:              []string{"Дата заключения"},
+	time:              []string{"Время", "Время заключения"},
+	settlementDate:    []string{"Дата расчетов"},
+	isin:              []string{"ISIN"},
+	securityCode:      []string{"Код актива"},
+	securityName:      []string{"Сокращенное наименование актива", "Сокращенное наименование"},
+	side:              []string{"Вид сделки"},
+	volume:            []string{"Количество"},
+	price:             []string{"Цена за единицу"},
+	currency:          []string{"Валюта цены"},
+	brokerComission:   []string{"Комиссия брокера"},
+	exchangeComission: []string{"Комиссия биржи"},
+	clearingComission: []string{"Комиссия клир. центра", "Комиссия клирингового центра"},
+	accruedInterest:   []string{"НКД"},
+	tradeId:           []string{"Номер сделки"},
+}
+
+var tinkoffCashColumns = reportCashColumns{
+	date:      []string{"Дата исполнения", "Дата"},
+	operation: []string{"Операция"},
+	note:      []string{"Примечание"},
+	credit:    []string{"Сумма зачисления"},
+	debit:     []string{"Сумма списания"},
+}
+
+const (
+	tinkoffSectionNone = iota
+	tinkoffSectionTrades
+	tinkoffSectionCash
+)
+
+func (srv *tinkoffReportImporter) Import(data []byte, options ImportOptions) (ImportResult, error) {
+	sheets, err := readXlsxSheets(data)
+	if err != nil {
+		return ImportResult{}, err
+	}
+	var result ImportResult
+	for _, sheet := range sheets {
+		srv.importSheet(sheet.rows, &result)
+	}
+	return result, nil
+}
+
+func (srv *tinkoffReportImporter) importSheet(rows []reportRow, result *ImportResult) {
+	var account string
+	for _, row := range rows {
+		if contract := findContract(strings.Join(row.cells, " ")); contract != "" {
+			account = mapAccount(srv.accounts, contract)
+			break
+		}
+	}
+	var section = tinkoffSectionNone
+	var header []string
+	var columns reportColumnIndex
+	var currency, title string
+	for _, row := range rows {
+		var nonEmpty = nonEmptyCells(row)
+		if len(nonEmpty) == 0 {
+			continue
+		}
+		if len(nonEmpty) == 1 {
+			// валюта подраздела движения денежных средств или заголовок нового раздела
+			if isCurrencyCode(nonEmpty[0]) {
+				currency = normalizeCurrency(nonEmpty[0])
+			} else {
+				title = strings.ToLower(nonEmpty[0])
+				section = tinkoffSectionNone
+			}
+			continue
+		}
+		var rowColumns = newReportColumnIndex(row.cells)
+		if tinkoffTradeColumns.match(rowColumns) {
+			section, header, columns = tinkoffSectionTrades, row.cells, rowColumns
+			if strings.Contains(title, "неисполн") {
+				section = tinkoffSectionNone
+			}
+			continue
+		}
+		if tinkoffCashColumns.match(rowColumns) {
+			section, header, columns = tinkoffSectionCash, row.cells, rowColumns
+			continue
+		}
+		if isSameReportRow(row.cells, header) || isReportTotalRow(row) {
+			continue
+		}
+		switch section {
+		case tinkoffSectionTrades:
+			t, err := parseReportTrade(srv.securityInfoDirectory, row, columns, &tinkoffTradeColumns)
+			if err != nil {
+				result.addError(row.line, err)
+				continue
+			}
+			t.Account = account
+			result.Trades = append(result.Trades, t)
+		case tinkoffSectionCash:
+			m, ok, err := parseReportCashMovement(srv.securityInfoDirectory, row, columns, &tinkoffCashColumns)
+			if err != nil {
+				result.addError(row.line, err)
+				continue
+			}
+			if !ok {
+				continue
+			}
+			m.Account = account
+			if m.Currency == "" {
+				m.Currency = currency
+			}
+			result.addCashMovement(m)
+		}
+	}
+}
+
+func nonEmptyCells(row reportRow) []string {
+	var result []string
+	for _, cell := range row.cells {
+		if cell != "" {
+			result = append(result, cell)
+		}
+	}
+	return result
+}
+
+func isSameReportRow(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func isCurrencyCode(s string) bool {
+	s = normalizeCurrency(s)
+	if len(s) != 3 {
+		return false
+	}
+	for _, r := range s {
+		if r < 'A' || r > 'Z' {
+			return false
+		}
+	}
+	return true
+}
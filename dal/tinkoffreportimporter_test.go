@@ -0,0 +1,114 @@
+package dal
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/ChizhovVadim/assets/core"
+)
+
+func TestTinkoffReportImporter(t *testing.T) {
+	var data = readBrokerReport(t, "tinkoff.xlsx")
+	var srv = NewTinkoffReportImporter(testBrokerSecurities, map[string]string{"7001234567": "tinkoff"})
+	if !srv.Detect(data) {
+		t.Fatal("xlsx not detected")
+	}
+	result, err := srv.Import(data, ImportOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// дата первой сделки хранится в XLSX числом, неисполненные сделки пропускаются
+	checkImportedTrades(t, result.Trades, []core.MyTrade{
+		{SecurityCode: "SBER", DateTime: testDateTime(2023, 3, 20, 10, 15, 0), ExecutionDate: testDate(2023, 3, 22),
+			Price: 250.5, Volume: 10, ExchangeComission: 0.25, BrokerComission: 0.75,
+			Account: "tinkoff", TradeId: "900001", Currency: "RUB"},
+		{SecurityCode: "OFZ26207", DateTime: testDateTime(2023, 3, 21, 11, 0, 0), ExecutionDate: testDate(2023, 3, 23),
+			Price: 99.5, Volume: -3, ExchangeComission: 0.1, BrokerComission: 0.5, AccruedInterest: 15.3,
+			Account: "tinkoff", TradeId: "900002", Currency: "RUB"},
+	})
+	// валюта движений - из строки подраздела, расчеты по сделкам и итоги пропускаются
+	checkImportedCashMovements(t, result.CashMovements, []core.CashMovement{
+		{Account: "tinkoff", Date: testDate(2023, 3, 1), Kind: core.CashDeposit, Sum: 50000, Currency: "RUB"},
+		{Account: "tinkoff", Date: testDate(2023, 7, 21), Kind: core.CashCoupon, Sum: 450, Currency: "RUB",
+			SecurityCode: "OFZ26207"},
+		{Account: "tinkoff", Date: testDate(2023, 7, 31), Kind: core.CashFee, Sum: -99, Currency: "RUB"},
+		{Account: "tinkoff", Date: testDate(2023, 8, 5), Kind: core.CashWithdrawal, Sum: -100, Currency: "USD"},
+	})
+	checkImportedDividends(t, result.Dividends, []core.DividendSchedule{
+		{SecurityCode: "SBER", RecordDate: testDate(2023, 7, 20), ReceivedDividend: &core.ReceivedDividend{
+			Account: "tinkoff", Date: testDate(2023, 7, 20), Sum: 3320.9, Currency: "RUB"}},
+	})
+	checkImportErrors(t, result.Errors, []int{7})
+}
+
+// testXlsx XLSX с одним листом, ячейки первой строки - inline строки.
+func testXlsx(t *testing.T, sheetName string, cells ...string) []byte {
+	var row strings.Builder
+	for i, cell := range cells {
+		fmt.Fprintf(&row, `<c r="%c1" t="inlineStr"><is><t>%v</t></is></c>`, 'A'+i, cell)
+	}
+	var parts = []struct{ name, content string }{
+		{"[Content_Types].xml", `<?xml version="1.0" encoding="UTF-8"?><Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types"/>`},
+		{"xl/workbook.xml", `<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets><sheet name="` +
+			sheetName + `" sheetId="1" r:id="rId1"/></sheets></workbook>`},
+		{"xl/_rels/workbook.xml.rels", `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Target="worksheets/sheet1.xml"/></Relationships>`},
+		{"xl/worksheets/sheet1.xml", `<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData><row r="1">` +
+			row.String() + `</row></sheetData></worksheet>`},
+	}
+	var buf bytes.Buffer
+	var archive = zip.NewWriter(&buf)
+	for _, part := range parts {
+		w, err := archive.Create(part.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = w.Write([]byte(part.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestTinkoffReportImporterDetect(t *testing.T) {
+	var tests = []struct {
+		name     string
+		data     []byte
+		detected bool
+	}{
+		{"other xlsx", testXlsx(t, "Sheet1", "Дата", "Сумма"), false},
+		{"tinkoff sheet", testXlsx(t, "broker_rep", "Отчет"), true},
+		{"tinkoff trades header", testXlsx(t, "Отчет", "Номер сделки", "Дата заключения", "Вид сделки",
+			"Код актива", "Цена за единицу", "Количество"), true},
+		{"not xlsx", []byte("Дата заключения;Вид сделки"), false},
+	}
+	var srv = NewTinkoffReportImporter(testBrokerSecurities, nil)
+	for _, test := range tests {
+		if detected := srv.Detect(test.data); detected != test.detected {
+			t.Errorf("%v: got %v, want %v", test.name, detected, test.detected)
+		}
+	}
+}
+
+func TestXlsxColumnIndex(t *testing.T) {
+	var tests = []struct {
+		ref   string
+		index int
+	}{
+		{"A1", 0},
+		{"O12", 14},
+		{"Z3", 25},
+		{"AA3", 26},
+		{"AB100", 27},
+	}
+	for _, test := range tests {
+		if index := xlsxColumnIndex(test.ref); index != test.index {
+			t.Errorf("xlsxColumnIndex(%q) = %v, want %v", test.ref, index, test.index)
+		}
+	}
+}
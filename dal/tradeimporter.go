@@ -0,0 +1,137 @@
+package dal
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/ChizhovVadim/assets/core"
+)
+
+const importSniffSize = 8192
+
+type ImportError struct {
+	Line int
+	Err  error
+}
+
+func (e ImportError) Error() string {
+	return fmt.Sprintf("line %v: %v", e.Line, e.Err)
+}
+
+type ImportResult struct {
+	Trades        []core.MyTrade
+	CashMovements []core.CashMovement
+	Dividends     []core.DividendSchedule
+	Errors        []ImportError
+}
+
+func (r *ImportResult) addError(line int, err error) {
+	r.Errors = append(r.Errors, ImportError{Line: line, Err: err})
+}
+
+// addCashMovement полученные дивиденды по известной бумаге попадают только в Dividends
+// (дата отсечки в отчетах брокеров отсутствует, используем дату выплаты),
+// остальные движения - в CashMovements. Так дивиденд не учитывается дважды.
+func (r *ImportResult) addCashMovement(m core.CashMovement) {
+	if m.Kind != core.CashDividend || m.SecurityCode == "" || m.Sum <= 0 {
+		r.CashMovements = append(r.CashMovements, m)
+		return
+	}
+	r.Dividends = append(r.Dividends, core.DividendSchedule{
+		SecurityCode: m.SecurityCode,
+		RecordDate:   m.Date,
+		ReceivedDividend: &core.ReceivedDividend{
+			Account:  m.Account,
+			Date:     m.Date,
+			Sum:      m.Sum,
+			Currency: m.Currency,
+		},
+	})
+}
+
+// TradeImporter разбирает отчет брокера.
+// Ошибки разбора отдельных строк возвращаются в ImportResult.Errors,
+// error означает, что файл не удалось прочитать целиком.
+type TradeImporter interface {
+	Name() string
+	Detect(head []byte) bool
+	Import(data []byte, options ImportOptions) (ImportResult, error)
+}
+
+type ImportOptions struct {
+	// AggregateOrders объединяет частичные исполнения одной заявки в одну сделку,
+	// если формат отчета содержит номер заявки.
+	AggregateOrders bool
+}
+
+type TradeImporterRegistry struct {
+	importers []TradeImporter
+}
+
+func NewTradeImporterRegistry(importers ...TradeImporter) *TradeImporterRegistry {
+	return &TradeImporterRegistry{importers}
+}
+
+func (srv *TradeImporterRegistry) Register(importer TradeImporter) {
+	srv.importers = append(srv.importers, importer)
+}
+
+func (srv *TradeImporterRegistry) Names() []string {
+	var result []string
+	for _, importer := range srv.importers {
+		result = append(result, importer.Name())
+	}
+	return result
+}
+
+func (srv *TradeImporterRegistry) Find(name string) (TradeImporter, bool) {
+	for _, importer := range srv.importers {
+		if strings.EqualFold(importer.Name(), name) {
+			return importer, true
+		}
+	}
+	return nil, false
+}
+
+func (srv *TradeImporterRegistry) Detect(head []byte) (TradeImporter, bool) {
+	for _, importer := range srv.importers {
+		if importer.Detect(head) {
+			return importer, true
+		}
+	}
+	return nil, false
+}
+
+// ImportFile загружает отчет брокера broker, при пустом broker формат определяется по содержимому.
+func (srv *TradeImporterRegistry) ImportFile(broker, fileName string,
+	options ImportOptions) (ImportResult, error) {
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return ImportResult{}, err
+	}
+	var importer TradeImporter
+	var found bool
+	if broker != "" {
+		importer, found = srv.Find(broker)
+		if !found {
+			return ImportResult{}, fmt.Errorf("broker not found %v, available %v", broker, srv.Names())
+		}
+	} else {
+		// XLSX - zip архив, признаки формата в сжатых частях, поэтому Detect получает файл целиком
+		var head = data
+		if len(head) > importSniffSize && !isXlsx(head) {
+			head = head[:importSniffSize]
+		}
+		importer, found = srv.Detect(head)
+		if !found {
+			return ImportResult{}, fmt.Errorf("broker report format not detected %v", fileName)
+		}
+	}
+	return importer.Import(data, options)
+}
+
+func trimUtf8Bom(data []byte) []byte {
+	return bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+}
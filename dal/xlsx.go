@@ -0,0 +1,197 @@
+package dal
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// Минимальное чтение OOXML (XLSX): значения ячеек листов в виде строк.
+// Форматирование, формулы и стили не поддерживаются.
+
+type xlsxSheet struct {
+	name string
+	rows []reportRow
+}
+
+func isXlsx(head []byte) bool {
+	return bytes.HasPrefix(head, []byte("PK\x03\x04")) && bytes.Contains(head, []byte("xl/"))
+}
+
+func readXlsxSheets(data []byte) ([]xlsxSheet, error) {
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+	var files = make(map[string]*zip.File)
+	for _, f := range archive.File {
+		files[f.Name] = f
+	}
+
+	var workbook struct {
+		Sheets []struct {
+			Name string `xml:"name,attr"`
+			Id   string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+		} `xml:"sheets>sheet"`
+	}
+	if err = decodeXlsxPart(files, "xl/workbook.xml", &workbook); err != nil {
+		return nil, err
+	}
+	var rels struct {
+		Items []struct {
+			Id     string `xml:"Id,attr"`
+			Target string `xml:"Target,attr"`
+		} `xml:"Relationship"`
+	}
+	if err = decodeXlsxPart(files, "xl/_rels/workbook.xml.rels", &rels); err != nil {
+		return nil, err
+	}
+	var targets = make(map[string]string)
+	for _, rel := range rels.Items {
+		var target = rel.Target
+		if strings.HasPrefix(target, "/") {
+			target = strings.TrimPrefix(target, "/")
+		} else {
+			target = path.Join("xl", target)
+		}
+		targets[rel.Id] = target
+	}
+	var sharedStrings []string
+	if _, found := files["xl/sharedStrings.xml"]; found {
+		sharedStrings, err = readXlsxSharedStrings(files)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var result []xlsxSheet
+	for _, sheet := range workbook.Sheets {
+		var target, found = targets[sheet.Id]
+		if !found {
+			return nil, fmt.Errorf("xlsx sheet not found %v", sheet.Name)
+		}
+		rows, err := readXlsxRows(files, target, sharedStrings)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, xlsxSheet{name: sheet.Name, rows: rows})
+	}
+	return result, nil
+}
+
+func decodeXlsxPart(files map[string]*zip.File, name string, v interface{}) error {
+	var f, found = files[name]
+	if !found {
+		return fmt.Errorf("xlsx part not found %v", name)
+	}
+	r, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	content, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	return xml.Unmarshal(content, v)
+}
+
+type xlsxText struct {
+	T string `xml:"t"`
+	R []struct {
+		T string `xml:"t"`
+	} `xml:"r"`
+}
+
+func (t *xlsxText) String() string {
+	if len(t.R) == 0 {
+		return t.T
+	}
+	var sb strings.Builder
+	sb.WriteString(t.T)
+	for _, r := range t.R {
+		sb.WriteString(r.T)
+	}
+	return sb.String()
+}
+
+func readXlsxSharedStrings(files map[string]*zip.File) ([]string, error) {
+	var sst struct {
+		Items []xlsxText `xml:"si"`
+	}
+	if err := decodeXlsxPart(files, "xl/sharedStrings.xml", &sst); err != nil {
+		return nil, err
+	}
+	var result = make([]string, len(sst.Items))
+	for i := range sst.Items {
+		result[i] = sst.Items[i].String()
+	}
+	return result, nil
+}
+
+func readXlsxRows(files map[string]*zip.File, name string, sharedStrings []string) ([]reportRow, error) {
+	var worksheet struct {
+		Rows []struct {
+			R     int `xml:"r,attr"`
+			Cells []struct {
+				R  string   `xml:"r,attr"`
+				T  string   `xml:"t,attr"`
+				V  string   `xml:"v"`
+				Is xlsxText `xml:"is"`
+			} `xml:"c"`
+		} `xml:"sheetData>row"`
+	}
+	if err := decodeXlsxPart(files, name, &worksheet); err != nil {
+		return nil, err
+	}
+	var result []reportRow
+	for i, row := range worksheet.Rows {
+		var line = row.R
+		if line == 0 {
+			line = i + 1
+		}
+		var cells []string
+		for j, c := range row.Cells {
+			var column = j
+			if c.R != "" {
+				column = xlsxColumnIndex(c.R)
+			}
+			var value string
+			switch c.T {
+			case "s":
+				index, err := strconv.Atoi(c.V)
+				if err != nil || index < 0 || index >= len(sharedStrings) {
+					return nil, fmt.Errorf("xlsx shared string %v %v", c.R, c.V)
+				}
+				value = sharedStrings[index]
+			case "inlineStr":
+				value = c.Is.String()
+			default:
+				value = c.V
+			}
+			for len(cells) <= column {
+				cells = append(cells, "")
+			}
+			cells[column] = strings.TrimSpace(value)
+		}
+		result = append(result, reportRow{line: line, cells: cells})
+	}
+	return result, nil
+}
+
+// xlsxColumnIndex переводит ссылку на ячейку (например "AB12") в индекс колонки с нуля.
+func xlsxColumnIndex(ref string) int {
+	var result = 0
+	for _, r := range ref {
+		if r < 'A' || r > 'Z' {
+			break
+		}
+		result = result*26 + int(r-'A') + 1
+	}
+	return result - 1
+}
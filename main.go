@@ -23,25 +23,63 @@ func main() {
 
 	assetsDir := path.Join(homeDir, "Projects/Assets/Assets/Content")
 	securityInfoStorage := dal.NewSecurityInfoStorage(path.Join(assetsDir, "StockSettings.xml"))
-	securityInfoDirectory := dal.NewSecurityInfoDirectory(securityInfoStorage)
+	securityInfoDirectory, err := dal.NewSecurityInfoDirectory(securityInfoStorage)
+	if err != nil {
+		log.Print(err)
+		return
+	}
 	myTradeStorage := dal.NewMyTradeStorage(path.Join(assetsDir, "trades.csv"))
 	myDividendStorage := dal.NewMyDividendStorage(path.Join(assetsDir, "Dividends.xml"))
-	historyCandleStorage := dal.NewHistoryCandleStorage(path.Join(homeDir, "TradingData/Portfolio"))
+	cashMovementStorage := dal.NewCashMovementStorage(path.Join(assetsDir, "cash.csv"))
+	corporateActionStorage := dal.NewCorporateActionStorage(path.Join(assetsDir, "CorporateActions.xml"))
+	securityTransferStorage := dal.NewSecurityTransferStorage(path.Join(assetsDir, "transfers.csv"))
+	historyCandleStorage := dal.NewCachedHistoryCandleStorage(
+		dal.NewHistoryCandleStorage(path.Join(homeDir, "TradingData/Portfolio")))
 
-	historyCandleService := dal.NewHistoryCandleService(historyCandleStorage,
-		dal.NewHistoryCandleProvider(securityInfoDirectory))
-	periodReportService := reports.NewPeriodReportService(myTradeStorage, historyCandleStorage, securityInfoDirectory, myDividendStorage)
-	dividendReportService := reports.NewDividendReportService(myTradeStorage, securityInfoDirectory, myDividendStorage)
-	ndflReportService := reports.NewNdflReportService(myTradeStorage, historyCandleStorage, securityInfoDirectory)
-	quoteReportService := reports.NewQuoteReportService(historyCandleStorage, securityInfoDirectory)
+	historyCandleProvider := dal.NewCompositeHistoryCandleProvider(securityInfoDirectory,
+		[]dal.NamedHistoryCandleProvider{
+			{Name: "finam", Provider: dal.NewHistoryCandleProvider(securityInfoDirectory)},
+			{Name: "moex", Provider: dal.NewMoexHistoryCandleProvider(securityInfoDirectory)},
+			{Name: "cbr", Provider: dal.NewCbrHistoryCandleProvider()},
+		},
+		map[string][]string{
+			"USDCB": {"cbr"},
+			"EURCB": {"cbr"},
+			"CNYCB": {"cbr"},
+			"index": {"moex", "finam"},
+			"bonds": {"moex"},
+		})
+	historyCandleService := dal.NewHistoryCandleService(historyCandleStorage, historyCandleProvider)
+	importSettings, err := dal.NewImportSettingsStorage(path.Join(assetsDir, "ImportSettings.xml")).Read()
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	tradeImporterRegistry := dal.NewTradeImporterRegistry(
+		dal.NewSberbankReportImporter(securityInfoDirectory, importSettings.Accounts("sberbank")),
+		dal.NewIBFlexReportImporter(securityInfoDirectory, importSettings.Accounts("ib")),
+		dal.NewTinkoffReportImporter(securityInfoDirectory, importSettings.Accounts("tinkoff")),
+		dal.NewQuikImportTradeService(securityInfoDirectory,
+			importSettings.Accounts("quik"), importSettings.Securities("quik")),
+		dal.NewSberbankImportTradeService())
+	periodReportService := reports.NewPeriodReportService(myTradeStorage, historyCandleStorage, securityInfoDirectory, myDividendStorage, cashMovementStorage, corporateActionStorage, securityTransferStorage)
+	dividendReportService := reports.NewDividendReportService(myTradeStorage, securityInfoDirectory, myDividendStorage, corporateActionStorage, securityTransferStorage)
+	ndflReportService := reports.NewNdflReportService(myTradeStorage, historyCandleStorage, securityInfoDirectory, myDividendStorage, corporateActionStorage, securityTransferStorage)
+	quoteReportService := reports.NewQuoteReportService(historyCandleStorage, securityInfoDirectory, corporateActionStorage)
+	bondReportService := reports.NewBondReportService(myTradeStorage, historyCandleStorage, securityInfoDirectory, corporateActionStorage, securityTransferStorage)
 
 	controller := &controller{
 		homeDir:               homeDir,
+		myTradeStorage:        myTradeStorage,
+		myDividendStorage:     myDividendStorage,
+		cashMovementStorage:   cashMovementStorage,
+		tradeImporterRegistry: tradeImporterRegistry,
 		historyCandleService:  historyCandleService,
 		periodReportService:   periodReportService,
 		dividendReportService: dividendReportService,
 		ndflReportService:     ndflReportService,
 		quoteReportService:    quoteReportService,
+		bondReportService:     bondReportService,
 	}
 
 	runCommands([]command{
@@ -52,5 +90,6 @@ func main() {
 		command{"taxfree", controller.taxfreeHandler},
 		command{"import", controller.importHandler},
 		command{"quote", controller.quoteHandler},
+		command{"bonds", controller.bondsHandler},
 	})
 }
@@ -0,0 +1,132 @@
+package reports
+
+import (
+	"math"
+	"time"
+
+	"github.com/ChizhovVadim/assets/core"
+)
+
+// bondCashflow выплата на одну облигацию в дату: купон и (или) часть номинала.
+type bondCashflow struct {
+	Date      time.Time
+	Coupon    float64
+	Principal float64
+}
+
+func securityBond(securityCode string,
+	securityInfoDirectory core.SecurityInfoDirectory) *core.BondInfo {
+	info, found := securityInfoDirectory.Read(securityCode)
+	if !found {
+		return nil
+	}
+	return info.Bond
+}
+
+// bondCashflows график выплат по датам. Номинал, не погашенный амортизациями,
+// выплачивается в дату погашения.
+func bondCashflows(bond *core.BondInfo) []bondCashflow {
+	var result []bondCashflow
+	var add = func(d time.Time) *bondCashflow {
+		for i := range result {
+			if result[i].Date.Equal(d) {
+				return &result[i]
+			}
+		}
+		var index = len(result)
+		for index > 0 && result[index-1].Date.After(d) {
+			index--
+		}
+		result = append(result, bondCashflow{})
+		copy(result[index+1:], result[index:])
+		result[index] = bondCashflow{Date: d}
+		return &result[index]
+	}
+	for _, c := range bond.Coupons {
+		add(c.Date).Coupon += c.Sum
+	}
+	var principal = 0.0
+	for _, a := range bond.Amortizations {
+		add(a.Date).Principal += a.Sum
+		principal += a.Sum
+	}
+	if !bond.Maturity.IsZero() && principal < bond.FaceValue {
+		add(bond.Maturity).Principal += bond.FaceValue - principal
+	}
+	return result
+}
+
+// bondFaceValue непогашенный номинал на дату.
+func bondFaceValue(bond *core.BondInfo, d time.Time) float64 {
+	var result = bond.FaceValue
+	for _, cf := range bondCashflows(bond) {
+		if cf.Date.After(d) {
+			break
+		}
+		result -= cf.Principal
+	}
+	return math.Max(0, result)
+}
+
+// bondAccruedInterest НКД на одну облигацию на дату: купон пропорционально дням купонного периода.
+func bondAccruedInterest(bond *core.BondInfo, d time.Time) float64 {
+	for i, c := range bond.Coupons {
+		if !c.Date.After(d) {
+			continue
+		}
+		var start time.Time
+		switch {
+		case i > 0:
+			start = bond.Coupons[i-1].Date
+		case !bond.IssueDate.IsZero():
+			start = bond.IssueDate
+		case len(bond.Coupons) > 1:
+			// длительность первого периода считаем как у следующего
+			start = c.Date.Add(-bond.Coupons[1].Date.Sub(c.Date))
+		default:
+			return 0
+		}
+		if d.Before(start) {
+			return 0
+		}
+		var days = c.Date.Sub(start).Hours() / 24
+		var elapsed = d.Sub(start).Hours() / 24
+		return math.Round(c.Sum*elapsed/days*100) / 100
+	}
+	return 0
+}
+
+// positionAmount стоимость позиции. Цена облигации - процент от номинала, к стоимости добавляется НКД.
+func positionAmount(bond *core.BondInfo, price float64, volume int, d time.Time) float64 {
+	if bond == nil {
+		return price * float64(volume)
+	}
+	return (price/100*bondFaceValue(bond, d) + bondAccruedInterest(bond, d)) * float64(volume)
+}
+
+// tradeAmount сумма сделки без комиссий, для облигаций с НКД. Знак совпадает со знаком Volume.
+func tradeAmount(bond *core.BondInfo, t core.MyTrade) float64 {
+	if bond == nil {
+		return t.Price * float64(t.Volume)
+	}
+	var sum = t.Price / 100 * bondFaceValue(bond, t.ExecutionDate) * float64(t.Volume)
+	if t.Volume < 0 {
+		return sum - t.AccruedInterest
+	}
+	return sum + t.AccruedInterest
+}
+
+// bondIncome купоны и погашения номинала за период по позициям из сделок.
+// Выплату получает владелец на конец дня, предшествующего дате выплаты.
+func bondIncome(bond *core.BondInfo, tt []core.MyTrade, securityCode string,
+	start, finish time.Time, visit func(cf bondCashflow, volume int)) {
+	for _, cf := range bondCashflows(bond) {
+		if cf.Date.Before(start) || cf.Date.After(finish) {
+			continue
+		}
+		var volume = calculateShares(tt, cf.Date.AddDate(0, 0, -1), securityCode, "")
+		if volume != 0 {
+			visit(cf, volume)
+		}
+	}
+}
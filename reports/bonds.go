@@ -0,0 +1,241 @@
+package reports
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/ChizhovVadim/assets/core"
+)
+
+type BondReportService struct {
+	myTradeStorage          core.MyTradeStorage
+	historyCandleStorage    core.HistoryCandleStorage
+	securityInfoDirectory   core.SecurityInfoDirectory
+	corporateActionStorage  core.CorporateActionStorage
+	securityTransferStorage core.SecurityTransferStorage
+}
+
+func NewBondReportService(
+	myTradeStorage core.MyTradeStorage,
+	historyCandleStorage core.HistoryCandleStorage,
+	securityInfoDirectory core.SecurityInfoDirectory,
+	corporateActionStorage core.CorporateActionStorage,
+	securityTransferStorage core.SecurityTransferStorage) *BondReportService {
+	return &BondReportService{
+		myTradeStorage:          myTradeStorage,
+		historyCandleStorage:    historyCandleStorage,
+		securityInfoDirectory:   securityInfoDirectory,
+		corporateActionStorage:  corporateActionStorage,
+		securityTransferStorage: securityTransferStorage,
+	}
+}
+
+type BondReport struct {
+	Account     string
+	Date        time.Time
+	Items       []BondItem
+	Months      []BondPaymentMonth
+	AmountTotal float64
+}
+
+type BondItem struct {
+	SecurityCode     string
+	Title            string
+	Currency         string
+	Volume           int
+	Price            float64 // процент от номинала
+	FaceValue        float64
+	AccruedInterest  float64
+	Amount           float64 // стоимость позиции с НКД
+	Maturity         time.Time
+	CurrentYield     float64
+	Ytm              float64
+	Duration         float64 // дюрация Маколея, лет
+	ModifiedDuration float64
+	Convexity        float64
+}
+
+// BondPaymentMonth предстоящие выплаты за месяц. Coupons и Principal - в рублях по курсу на дату отчета.
+type BondPaymentMonth struct {
+	Month     time.Time
+	Payments  []BondPaymentItem
+	Coupons   float64
+	Principal float64
+}
+
+type BondPaymentItem struct {
+	SecurityCode string
+	Date         time.Time
+	Currency     string
+	Coupon       float64
+	Principal    float64
+}
+
+func (srv *BondReportService) BuildBondReport(account string,
+	date time.Time) (BondReport, error) {
+	tt, err := srv.myTradeStorage.Read(account)
+	if err != nil {
+		return BondReport{}, err
+	}
+	actions, err := srv.corporateActionStorage.Read()
+	if err != nil {
+		return BondReport{}, err
+	}
+	transfers, err := srv.securityTransferStorage.Read()
+	if err != nil {
+		return BondReport{}, err
+	}
+	tt = append(tt, securityTransferTrades(transfers, account)...)
+	var candles = newAdjustedCandleStorage(srv.historyCandleStorage, actions, date)
+	tt = applyCorporateActions(tt, actions, date, corporateActionRules{prices: candles})
+	var curConv = newCurrencyConverter(srv.historyCandleStorage, rubCurrency)
+	var volumes = make(map[string]int)
+	for _, t := range tt {
+		if !t.ExecutionDate.After(date) {
+			volumes[t.SecurityCode] += t.Volume
+		}
+	}
+	var report = BondReport{
+		Account: account,
+		Date:    date,
+	}
+	var months = make(map[time.Time]*BondPaymentMonth)
+	for securityCode, volume := range volumes {
+		var bond = securityBond(securityCode, srv.securityInfoDirectory)
+		if bond == nil || volume == 0 {
+			continue
+		}
+		var faceValue = bondFaceValue(bond, date)
+		if faceValue == 0 {
+			continue
+		}
+		var currency = securityCurrency(securityCode, srv.securityInfoDirectory)
+		var item = BondItem{
+			SecurityCode:    securityCode,
+			Title:           securityTitle(securityCode, srv.securityInfoDirectory),
+			Currency:        currency,
+			Volume:          volume,
+			FaceValue:       faceValue,
+			AccruedInterest: bondAccruedInterest(bond, date),
+			Maturity:        bond.Maturity,
+		}
+		if c, err := candles.CandleByDate(securityCode, date); err == nil {
+			item.Price = c.C
+			computeBondYield(&item, bond, date)
+		}
+		item.Amount = positionAmount(bond, item.Price, volume, date)
+		report.AmountTotal += curConv.ConvertFrom(currency, date, item.Amount)
+		report.Items = append(report.Items, item)
+
+		for _, cf := range bondCashflows(bond) {
+			if !cf.Date.After(date) {
+				continue
+			}
+			var month = time.Date(cf.Date.Year(), cf.Date.Month(), 1, 0, 0, 0, 0, time.UTC)
+			var m, found = months[month]
+			if !found {
+				m = &BondPaymentMonth{Month: month}
+				months[month] = m
+			}
+			var payment = BondPaymentItem{
+				SecurityCode: securityCode,
+				Date:         cf.Date,
+				Currency:     currency,
+				Coupon:       cf.Coupon * float64(volume),
+				Principal:    cf.Principal * float64(volume),
+			}
+			m.Payments = append(m.Payments, payment)
+			m.Coupons += curConv.ConvertFrom(currency, date, payment.Coupon)
+			m.Principal += curConv.ConvertFrom(currency, date, payment.Principal)
+		}
+	}
+	if err = curConv.Err(); err != nil {
+		return BondReport{}, err
+	}
+	sort.Slice(report.Items, func(i, j int) bool {
+		return report.Items[i].Maturity.Before(report.Items[j].Maturity)
+	})
+	for _, m := range months {
+		sort.Slice(m.Payments, func(i, j int) bool {
+			return m.Payments[i].Date.Before(m.Payments[j].Date)
+		})
+		report.Months = append(report.Months, *m)
+	}
+	sort.Slice(report.Months, func(i, j int) bool {
+		return report.Months[i].Month.Before(report.Months[j].Month)
+	})
+	return report, nil
+}
+
+// computeBondYield доходность к погашению (эффективная годовая), дюрация и выпуклость
+// по будущим выплатам на одну облигацию при покупке по текущей цене с НКД.
+func computeBondYield(item *BondItem, bond *core.BondInfo, date time.Time) {
+	var dirtyPrice = item.Price/100*item.FaceValue + item.AccruedInterest
+	if dirtyPrice <= 0 {
+		return
+	}
+	var cashflows = []DateSum{{date, -dirtyPrice}}
+	var annualCoupons = 0.0
+	for _, cf := range bondCashflows(bond) {
+		if !cf.Date.After(date) {
+			continue
+		}
+		cashflows = append(cashflows, DateSum{cf.Date, cf.Coupon + cf.Principal})
+		if !cf.Date.After(date.AddDate(1, 0, 0)) {
+			annualCoupons += cf.Coupon
+		}
+	}
+	if len(cashflows) == 1 {
+		return
+	}
+	item.CurrentYield = annualCoupons / (item.Price / 100 * item.FaceValue)
+	var rate = InternalRateOfReturn(cashflows)
+	item.Ytm = rate - 1
+	var pv, duration, convexity float64
+	for _, cf := range cashflows[1:] {
+		var t = yearsBetween(date, cf.Date)
+		var discounted = cf.Sum * math.Pow(rate, -t)
+		pv += discounted
+		duration += t * discounted
+		convexity += t * (t + 1) * discounted / (rate * rate)
+	}
+	if pv == 0 {
+		return
+	}
+	item.Duration = duration / pv
+	item.ModifiedDuration = item.Duration / rate
+	item.Convexity = convexity / pv
+}
+
+func PrintBondReport(report BondReport) {
+	fmt.Printf("Облигации '%v' на дату %v\n",
+		report.Account, report.Date.Format(dateLayout))
+
+	var w = newTabWriter()
+	fmt.Fprintf(w, "Security\tVolume\tPrice\tFace\tNKD\tAmount\tCur\tMaturity\tCY\tYTM\tD\tMD\tC\t\n")
+	for _, item := range report.Items {
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%.f\t%v\t%v\t%.2f\t%.2f\t%.2f\t%.2f\t%.2f\t\n",
+			item.Title, item.Volume, item.Price, item.FaceValue, item.AccruedInterest,
+			item.Amount, item.Currency, formatZeroDate(item.Maturity),
+			item.CurrentYield*100, item.Ytm*100,
+			item.Duration, item.ModifiedDuration, item.Convexity)
+	}
+	w.Flush()
+	fmt.Printf("Стоимость облигаций: %.f\n", report.AmountTotal)
+
+	fmt.Println("График выплат")
+	w = newTabWriter()
+	fmt.Fprintf(w, "Month\tDate\tSecurity\tCoupon\tPrincipal\tCur\t\n")
+	for _, m := range report.Months {
+		for _, p := range m.Payments {
+			fmt.Fprintf(w, "\t%v\t%v\t%.2f\t%v\t%v\t\n",
+				p.Date.Format(dateLayout), p.SecurityCode, p.Coupon,
+				formatZeroFloat64(p.Principal), p.Currency)
+		}
+		fmt.Fprintf(w, "%v\t\tИтого\t%.f\t%.f\tRUB\t\n",
+			m.Month.Format("2006-01"), m.Coupons, m.Principal)
+	}
+	w.Flush()
+}
@@ -0,0 +1,436 @@
+package reports
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ChizhovVadim/assets/core"
+)
+
+// corporateActionRules как учитывать выделение и обмен бумаг.
+// Для оценки портфеля обмен - продажа исходных бумаг и покупка новых по рыночной стоимости,
+// выделенные бумаги получены бесплатно. В налоговом учете (tax) стоимость и даты открытия лотов
+// переходят в новые бумаги, денежная часть обмена - продажа исходных бумаг.
+// Обмен с признаком Taxable и в налоговом учете - продажа и покупка по рыночной стоимости.
+// method - способ выбора лотов, открытых на дату действия, пусто - FIFO.
+type corporateActionRules struct {
+	tax    bool
+	prices core.HistoryCandleStorage
+	method CostBasisMethod
+}
+
+// applyCorporateActions пересчитывает лоты, открытые на дату действия, в бумаги после действия.
+// Учитываются действия не позднее until. Стоимость лотов и даты открытия сохраняются,
+// меняются количество, цена и код бумаги. Дробные остатки отбрасываются.
+// Закрытые до действия части сделок остаются в исходных бумагах без изменений.
+func applyCorporateActions(tt []core.MyTrade, actions []core.CorporateAction,
+	until time.Time, rules corporateActionRules) []core.MyTrade {
+	if len(actions) == 0 {
+		return tt
+	}
+	var result = make([]core.MyTrade, len(tt))
+	copy(result, tt)
+	for _, action := range actions {
+		if action.Date.After(until) {
+			continue
+		}
+		result = applyCorporateAction(result, action, rules)
+	}
+	return removeEmptyTrades(result)
+}
+
+func applyCorporateAction(tt []core.MyTrade, action core.CorporateAction,
+	rules corporateActionRules) []core.MyTrade {
+	// позиции по счетам пересчитываем целиком, чтобы округление не зависело от числа сделок
+	for _, position := range corporateActionPositions(tt, action) {
+		var account, indexes = position.account, position.indexes
+		switch action.Kind {
+		case core.CorporateActionSplit, core.CorporateActionConversion:
+			var lots = takeOpenLots(tt, indexes, rules.method)
+			tt = append(tt, scaleTrades(lots, action.NewSecurityCode, action.To/action.From, 1)...)
+		case core.CorporateActionSpinOff, core.CorporateActionExchange:
+			if rules.tax && !action.Taxable {
+				var lots = takeOpenLots(tt, indexes, rules.method)
+				tt = append(tt, exchangeTrades(lots, action, rules)...)
+			} else {
+				tt = append(tt, marketExchangeTrades(tt, indexes, account, action, rules)...)
+			}
+		}
+	}
+	return tt
+}
+
+// corporateActionPosition сделки по бумаге действия на одном счете.
+type corporateActionPosition struct {
+	account string
+	indexes []int
+}
+
+// corporateActionPositions позиции по счетам в порядке первой сделки.
+// Счета сравниваются без учета регистра, account - как в первой сделке.
+func corporateActionPositions(tt []core.MyTrade,
+	action core.CorporateAction) []corporateActionPosition {
+	var positions = make(map[string]int)
+	var result []corporateActionPosition
+	for i, t := range tt {
+		if t.SecurityCode == action.SecurityCode && t.ExecutionDate.Before(action.Date) {
+			var key = strings.ToLower(t.Account)
+			var index, found = positions[key]
+			if !found {
+				index = len(result)
+				positions[key] = index
+				result = append(result, corporateActionPosition{account: t.Account})
+			}
+			result[index].indexes = append(result[index].indexes, i)
+		}
+	}
+	for _, position := range result {
+		var indexes = position.indexes
+		sort.SliceStable(indexes, func(i, j int) bool {
+			return tt[indexes[i]].ExecutionDate.Before(tt[indexes[j]].ExecutionDate)
+		})
+	}
+	return result
+}
+
+// takeOpenLots выделяет из сделок позиции лоты, открытые на дату действия.
+func takeOpenLots(tt []core.MyTrade, indexes []int, method CostBasisMethod) []core.MyTrade {
+	var lots, sources = openLots(tt, indexes, method)
+	for i, lot := range lots {
+		lots[i] = takeLot(tt, sources[i], lot, absInt(lot.Volume))
+	}
+	return lots
+}
+
+// scaleTrades лоты в новых бумагах: ratio новых бумаг на одну исходную,
+// costShare - доля стоимости и комиссий исходных лотов.
+func scaleTrades(lots []core.MyTrade, securityCode string,
+	ratio, costShare float64) []core.MyTrade {
+	var result = make([]core.MyTrade, 0, len(lots))
+	var volume, newVolume = 0, 0
+	for _, t := range lots {
+		volume += t.Volume
+		var next = int(math.Floor(float64(volume) * ratio))
+		var tradeVolume = next - newVolume
+		newVolume = next
+		if tradeVolume != 0 {
+			t.Price = t.Price * float64(t.Volume) * costShare / float64(tradeVolume)
+		}
+		t.Volume = tradeVolume
+		t.SecurityCode = securityCode
+		t.ExchangeComission *= costShare
+		t.BrokerComission *= costShare
+		t.AccruedInterest *= costShare
+		t.Lots = scaleLotSelections(t.Lots, ratio)
+		result = append(result, t)
+	}
+	return result
+}
+
+// scaleLotSelections выбор лотов в количестве новых бумаг.
+func scaleLotSelections(lots []core.LotSelection, ratio float64) []core.LotSelection {
+	if len(lots) == 0 {
+		return lots
+	}
+	var result = make([]core.LotSelection, len(lots))
+	for i, lot := range lots {
+		result[i] = core.LotSelection{Lot: lot.Lot, Volume: int(math.Floor(float64(lot.Volume) * ratio))}
+	}
+	return result
+}
+
+func positionVolume(tt []core.MyTrade, indexes []int) int {
+	var volume = 0
+	for _, index := range indexes {
+		volume += tt[index].Volume
+	}
+	return volume
+}
+
+func tradesVolume(tt []core.MyTrade) int {
+	var volume = 0
+	for _, t := range tt {
+		volume += t.Volume
+	}
+	return volume
+}
+
+func corporateActionTrade(action core.CorporateAction, account, securityCode string,
+	price float64, volume int) core.MyTrade {
+	return core.MyTrade{
+		SecurityCode:  securityCode,
+		DateTime:      action.Date,
+		ExecutionDate: action.Date,
+		Price:         price,
+		Volume:        volume,
+		Account:       account,
+		Note:          string(action.Kind) + " " + action.SecurityCode,
+	}
+}
+
+// price рыночная цена бумаги на дату действия. Для бумаг, которые начали торговаться позже,
+// берется первая известная цена.
+func (rules corporateActionRules) price(securityCode string, price float64, d time.Time) float64 {
+	if price > 0 || rules.prices == nil {
+		return price
+	}
+	if c, err := rules.prices.CandleByDate(securityCode, d); err == nil {
+		return c.C
+	}
+	if cc, err := rules.prices.Read(securityCode); err == nil && len(cc) != 0 {
+		return cc[0].C
+	}
+	return 0
+}
+
+// corporateActionPart бумаги, в которые переходит часть стоимости исходных бумаг.
+type corporateActionPart struct {
+	securityCode string
+	ratio        float64
+	costShare    float64
+	price        float64
+}
+
+// corporateActionParts доли стоимости по новым бумагам. Остаток стоимости при выделении
+// остается у исходных бумаг, при обмене - приходится на денежную часть.
+func corporateActionParts(action core.CorporateAction,
+	rules corporateActionRules) (parts []corporateActionPart, cashShare float64) {
+	var explicit = false
+	for _, r := range action.Results {
+		parts = append(parts, corporateActionPart{
+			securityCode: r.SecurityCode,
+			ratio:        r.To / r.From,
+			costShare:    r.CostShare,
+			price:        rules.price(r.SecurityCode, r.Price, action.Date),
+		})
+		explicit = explicit || r.CostShare != 0
+	}
+	var cash = action.Cash
+	if action.Kind == core.CorporateActionSpinOff {
+		parts = append(parts, corporateActionPart{
+			securityCode: action.SecurityCode,
+			ratio:        1,
+			price:        rules.price(action.SecurityCode, 0, action.Date),
+		})
+		cash = 0
+	}
+	if !explicit {
+		// распределение пропорционально рыночной стоимости
+		var total = cash
+		for _, p := range parts {
+			total += p.ratio * p.price
+		}
+		if total == 0 {
+			parts[len(parts)-1].costShare = 1
+			return parts, 0
+		}
+		for i := range parts {
+			parts[i].costShare = parts[i].ratio * parts[i].price / total
+		}
+		return parts, cash / total
+	}
+	var rest = 1.0
+	for _, p := range parts {
+		rest -= p.costShare
+	}
+	if action.Kind == core.CorporateActionSpinOff {
+		parts[len(parts)-1].costShare = rest
+		return parts, 0
+	}
+	return parts, math.Max(0, rest)
+}
+
+// exchangeTrades налоговый учет: открытые лоты исходных бумаг переходят в новые бумаги с долей стоимости,
+// денежная часть закрывает оставшуюся долю стоимости продажей по Cash.
+func exchangeTrades(lots []core.MyTrade, action core.CorporateAction,
+	rules corporateActionRules) []core.MyTrade {
+	if len(lots) == 0 {
+		return nil
+	}
+	var parts, cashShare = corporateActionParts(action, rules)
+	var result []core.MyTrade
+	for _, p := range parts {
+		result = append(result, scaleTrades(lots, p.securityCode, p.ratio, p.costShare)...)
+	}
+	if cashShare == 0 && action.Cash == 0 {
+		return result
+	}
+	result = append(result, scaleTrades(lots, action.SecurityCode, 1, cashShare)...)
+	if volume := tradesVolume(lots); volume > 0 {
+		result = append(result, corporateActionTrade(action, lots[0].Account, action.SecurityCode,
+			action.Cash, -volume))
+	}
+	return result
+}
+
+// marketExchangeTrades обмен по рыночной стоимости: продажа исходных бумаг на дату действия
+// по стоимости полученных бумаг и денег, покупка новых бумаг. Выделенные бумаги - покупка по нулевой цене.
+func marketExchangeTrades(tt []core.MyTrade, indexes []int, account string,
+	action core.CorporateAction, rules corporateActionRules) []core.MyTrade {
+	var volume = positionVolume(tt, indexes)
+	if volume <= 0 {
+		return nil
+	}
+	var result []core.MyTrade
+	var value = action.Cash
+	for _, r := range action.Results {
+		var price = rules.price(r.SecurityCode, r.Price, action.Date)
+		value += r.To / r.From * price
+		if action.Kind == core.CorporateActionSpinOff {
+			price = 0
+		}
+		var newVolume = int(math.Floor(float64(volume) * r.To / r.From))
+		if newVolume != 0 {
+			result = append(result, corporateActionTrade(action, account, r.SecurityCode, price, newVolume))
+		}
+	}
+	if action.Kind == core.CorporateActionExchange {
+		result = append(result, corporateActionTrade(action, account, action.SecurityCode, value, -volume))
+	}
+	return result
+}
+
+func removeEmptyTrades(tt []core.MyTrade) []core.MyTrade {
+	var result = tt[:0]
+	for _, t := range tt {
+		if t.Volume != 0 {
+			result = append(result, t)
+		}
+	}
+	return result
+}
+
+// adjustedCandleStorage цены бумаг с учетом корпоративных действий: цены до дробления
+// делятся на коэффициент, история новой бумаги после конвертации начинается с истории старой.
+type adjustedCandleStorage struct {
+	core.HistoryCandleStorage
+	actions []core.CorporateAction
+	series  map[string][]core.HistoryCandle
+}
+
+// Учитываются действия не позднее until, как и в applyCorporateActions.
+func newAdjustedCandleStorage(storage core.HistoryCandleStorage,
+	actions []core.CorporateAction, until time.Time) core.HistoryCandleStorage {
+	var items []core.CorporateAction
+	for _, action := range actions {
+		if !action.Date.After(until) {
+			items = append(items, action)
+		}
+	}
+	if len(items) == 0 {
+		return storage
+	}
+	return &adjustedCandleStorage{
+		HistoryCandleStorage: storage,
+		actions:              items,
+		series:               make(map[string][]core.HistoryCandle),
+	}
+}
+
+func (srv *adjustedCandleStorage) Read(securityCode string) ([]core.HistoryCandle, error) {
+	if cc, found := srv.series[securityCode]; found {
+		return cc, nil
+	}
+	cc, err := srv.adjustedCandles(securityCode, 0)
+	if err != nil {
+		return nil, err
+	}
+	srv.series[securityCode] = cc
+	return cc, nil
+}
+
+func (srv *adjustedCandleStorage) adjustedCandles(securityCode string,
+	depth int) ([]core.HistoryCandle, error) {
+	var candles, err = srv.HistoryCandleStorage.Read(securityCode)
+	for _, action := range srv.actions {
+		if action.Kind != core.CorporateActionConversion ||
+			action.NewSecurityCode != securityCode || depth > len(srv.actions) {
+			continue
+		}
+		prefix, prefixErr := srv.adjustedCandles(action.SecurityCode, depth+1)
+		if prefixErr != nil {
+			continue
+		}
+		var merged []core.HistoryCandle
+		for _, c := range prefix {
+			if c.DateTime.Before(action.Date) {
+				merged = append(merged, scaleCandle(c, action))
+			}
+		}
+		for _, c := range candles {
+			if !c.DateTime.Before(action.Date) {
+				merged = append(merged, c)
+			}
+		}
+		candles, err = merged, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, action := range srv.actions {
+		if action.Kind != core.CorporateActionSplit || action.SecurityCode != securityCode {
+			continue
+		}
+		var adjusted = make([]core.HistoryCandle, len(candles))
+		for i, c := range candles {
+			if c.DateTime.Before(action.Date) {
+				c = scaleCandle(c, action)
+			}
+			adjusted[i] = c
+		}
+		candles = adjusted
+	}
+	return candles, nil
+}
+
+func scaleCandle(c core.HistoryCandle, action core.CorporateAction) core.HistoryCandle {
+	var k = action.From / action.To
+	c.O *= k
+	c.H *= k
+	c.L *= k
+	c.C *= k
+	c.V /= k
+	return c
+}
+
+func (srv *adjustedCandleStorage) CandleBeforeDate(securityCode string, date time.Time) (core.HistoryCandle, error) {
+	var cc, err = srv.Read(securityCode)
+	if err != nil {
+		return core.HistoryCandle{}, err
+	}
+	var index = sort.Search(len(cc), func(i int) bool {
+		return !cc[i].DateTime.Before(date)
+	}) - 1
+	if index == -1 {
+		return core.HistoryCandle{}, core.ErrNoData
+	}
+	return cc[index], nil
+}
+
+func (srv *adjustedCandleStorage) CandleByDate(securityCode string, date time.Time) (core.HistoryCandle, error) {
+	var cc, err = srv.Read(securityCode)
+	if err != nil {
+		return core.HistoryCandle{}, err
+	}
+	var index = sort.Search(len(cc), func(i int) bool {
+		return cc[i].DateTime.After(date)
+	}) - 1
+	if index == -1 {
+		return core.HistoryCandle{}, core.ErrNoData
+	}
+	return cc[index], nil
+}
+
+func (srv *adjustedCandleStorage) Last(securityCode string) (core.HistoryCandle, error) {
+	var cc, err = srv.Read(securityCode)
+	if err != nil || len(cc) == 0 {
+		return core.HistoryCandle{}, core.ErrNoData
+	}
+	return cc[len(cc)-1], nil
+}
+
+func (srv *adjustedCandleStorage) Update(securityCode string, candles []core.HistoryCandle) error {
+	return core.ErrNotImplemented
+}
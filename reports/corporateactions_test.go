@@ -0,0 +1,102 @@
+package reports
+
+import (
+	"testing"
+
+	"github.com/ChizhovVadim/assets/core"
+)
+
+// Счет в сделках записан в разном регистре: консолидация считается по всей позиции счета.
+func TestApplyCorporateActionsAccountCase(t *testing.T) {
+	var tt = []core.MyTrade{
+		{SecurityCode: "VTBR", ExecutionDate: testDate(2023, 3, 1), Price: 0.02, Volume: 3, Account: "IIS"},
+		{SecurityCode: "VTBR", ExecutionDate: testDate(2023, 4, 1), Price: 0.02, Volume: 3, Account: "iis"},
+	}
+	var actions = []core.CorporateAction{{Date: testDate(2024, 7, 15), Kind: core.CorporateActionSplit,
+		SecurityCode: "VTBR", NewSecurityCode: "VTBR", From: 2, To: 1}}
+	var result = applyCorporateActions(tt, actions, testDate(2024, 12, 31), corporateActionRules{})
+	var volume = 0
+	for _, t := range result {
+		volume += t.Volume
+	}
+	if volume != 3 {
+		t.Errorf("got volume %v, want 3: %+v", volume, result)
+	}
+}
+
+// Действие меняет только лоты, открытые на его дату: закрытые раньше сделки
+// и результат прошлых лет остаются в исходных бумагах.
+func TestApplyCorporateActionsClosedLots(t *testing.T) {
+	var exchange = core.CorporateAction{Date: testDate(2023, 5, 1), Kind: core.CorporateActionExchange,
+		SecurityCode: "SBER", Results: []core.CorporateActionResult{{SecurityCode: "NEW", From: 1, To: 1, CostShare: 1}}}
+	var conversion = core.CorporateAction{Date: testDate(2023, 5, 1), Kind: core.CorporateActionConversion,
+		SecurityCode: "SBER", NewSecurityCode: "NEW", From: 1, To: 2}
+	var split = core.CorporateAction{Date: testDate(2023, 5, 1), Kind: core.CorporateActionSplit,
+		SecurityCode: "SBER", NewSecurityCode: "SBER", From: 1, To: 10}
+	var tests = []struct {
+		name   string
+		method CostBasisMethod
+		trades []core.MyTrade
+		action core.CorporateAction
+		closed []ClosedMyTrade
+		open   []testLot
+	}{
+		{
+			name:   "exchange after round trip",
+			method: CostBasisFifo,
+			trades: []core.MyTrade{
+				{SecurityCode: "SBER", ExecutionDate: testDate(2022, 3, 1), Price: 100, Volume: 10, BrokerComission: 10},
+				{SecurityCode: "SBER", ExecutionDate: testDate(2022, 6, 1), Price: 120, Volume: -10, BrokerComission: 12},
+			},
+			action: exchange,
+			closed: []ClosedMyTrade{{SecurityCode: "SBER", Volume: 10, OpenPrice: 100, ClosePrice: 120,
+				OpenComission: 10, CloseComission: 12}},
+		},
+		{
+			name:   "conversion after partial close",
+			method: CostBasisFifo,
+			trades: []core.MyTrade{
+				{SecurityCode: "SBER", ExecutionDate: testDate(2022, 3, 1), Price: 100, Volume: 10, BrokerComission: 10},
+				{SecurityCode: "SBER", ExecutionDate: testDate(2022, 6, 1), Price: 120, Volume: -4, BrokerComission: 4},
+			},
+			action: conversion,
+			closed: []ClosedMyTrade{{SecurityCode: "SBER", Volume: 4, OpenPrice: 100, ClosePrice: 120,
+				OpenComission: 4, CloseComission: 4}},
+			open: []testLot{{"NEW", 12, 50, 6}},
+		},
+		{
+			name:   "split after specific lot sale",
+			method: CostBasisSpecific,
+			trades: []core.MyTrade{
+				{SecurityCode: "SBER", ExecutionDate: testDate(2022, 1, 10), Price: 100, Volume: 5, TradeId: "1"},
+				{SecurityCode: "SBER", ExecutionDate: testDate(2022, 2, 10), Price: 200, Volume: 5, TradeId: "2"},
+				{SecurityCode: "SBER", ExecutionDate: testDate(2022, 6, 1), Price: 250, Volume: -5,
+					Lots: []core.LotSelection{{Lot: "2", Volume: 5}}},
+			},
+			action: split,
+			closed: []ClosedMyTrade{{SecurityCode: "SBER", Volume: 5, OpenPrice: 200, ClosePrice: 250}},
+			open:   []testLot{{"SBER", 50, 10, 0}},
+		},
+		{
+			name:   "split after average round trip",
+			method: CostBasisAverage,
+			trades: []core.MyTrade{
+				{SecurityCode: "SBER", ExecutionDate: testDate(2022, 1, 10), Price: 100, Volume: 10},
+				{SecurityCode: "SBER", ExecutionDate: testDate(2022, 2, 10), Price: 200, Volume: 10},
+				{SecurityCode: "SBER", ExecutionDate: testDate(2022, 6, 1), Price: 180, Volume: -20},
+			},
+			action: split,
+			closed: []ClosedMyTrade{
+				{SecurityCode: "SBER", Volume: 10, OpenPrice: 150, ClosePrice: 180},
+				{SecurityCode: "SBER", Volume: 10, OpenPrice: 150, ClosePrice: 180},
+			},
+		},
+	}
+	for _, test := range tests {
+		var result = applyCorporateActions(test.trades, []core.CorporateAction{test.action},
+			testDate(2023, 12, 31), corporateActionRules{tax: true, method: test.method})
+		var lots = matchLots(result, test.method)
+		checkClosedTrades(t, test.name, lots.Closed, test.closed)
+		checkOpenLots(t, test.name, lots.Open, test.open)
+	}
+}
@@ -1,47 +1,158 @@
 package reports
 
 import (
+	"fmt"
 	"math"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/ChizhovVadim/assets/core"
 )
 
+const (
+	rubCurrency = "RUB"
+	// курсы ЦБ хранятся как свечи USDCB, EURCB, ...
+	cbrRateSuffix = "CB"
+)
+
+// currencyConverter переводит суммы в валюту отчета по курсам ЦБ.
+// Курс между двумя иностранными валютами считается через рубль.
+// Если курса нет, сумма равна NaN, а первая такая ошибка возвращается Err.
 type currencyConverter struct {
 	historyCandleStorage core.HistoryCandleStorage
 	codeTo               string
-	candles              []core.HistoryCandle
+	rates                map[string][]core.HistoryCandle
+	err                  error
+}
+
+// codeTo: валюта отчета (USD), тикер курса (USDCB) или тикер бумаги, в ценах которой
+// строится отчет (например MCFTR). Пусто - рубли.
+func newCurrencyConverter(historyCandleStorage core.HistoryCandleStorage,
+	codeTo string) *currencyConverter {
+	return &currencyConverter{
+		historyCandleStorage: historyCandleStorage,
+		codeTo:               currencyCode(codeTo),
+		rates:                make(map[string][]core.HistoryCandle),
+	}
+}
+
+// currencyCode приводит код валюты к виду USD. Пусто и RUR - рубли.
+// Код, не похожий на валюту (тикер бумаги), возвращается без изменений.
+func currencyCode(s string) string {
+	s = strings.TrimSpace(s)
+	var code = strings.ToUpper(s)
+	if strings.HasSuffix(code, cbrRateSuffix) && isCurrency(strings.TrimSuffix(code, cbrRateSuffix)) {
+		code = strings.TrimSuffix(code, cbrRateSuffix)
+	}
+	if code == "" || code == "RUR" {
+		return rubCurrency
+	}
+	if isCurrency(code) {
+		return code
+	}
+	return s
+}
+
+// isCurrency код валюты - три латинские буквы.
+func isCurrency(code string) bool {
+	if len(code) != 3 {
+		return false
+	}
+	for _, r := range code {
+		if r < 'A' || r > 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
+// rateSecurityCode тикер свечей курса: для валюты - курс ЦБ (USDCB), иначе сам тикер.
+func rateSecurityCode(code string) string {
+	if isCurrency(code) {
+		return code + cbrRateSuffix
+	}
+	return code
+}
+
+// Err первая ошибка получения курса.
+func (srv *currencyConverter) Err() error {
+	return srv.err
 }
 
+// Convert переводит сумму в рублях в валюту отчета.
 func (srv *currencyConverter) Convert(d time.Time, v float64) float64 {
-	if srv.codeTo == "" {
+	return srv.ConvertFrom(rubCurrency, d, v)
+}
+
+// ConvertFrom переводит сумму в валюте currency в валюту отчета.
+func (srv *currencyConverter) ConvertFrom(currency string, d time.Time, v float64) float64 {
+	return srv.Exchange(currency, srv.codeTo, d, v)
+}
+
+// Exchange переводит сумму из валюты from в валюту to по курсам на дату d.
+func (srv *currencyConverter) Exchange(from, to string, d time.Time, v float64) float64 {
+	from, to = currencyCode(from), currencyCode(to)
+	if from == to || v == 0 {
 		return v
 	}
-	var candle, err = srv.Candle(d)
+	rateFrom, err := srv.Rate(from, d)
 	if err != nil {
-		return math.NaN()
+		return srv.fail(err)
 	}
-	return v / candle.C
+	rateTo, err := srv.Rate(to, d)
+	if err != nil {
+		return srv.fail(err)
+	}
+	return v * rateFrom / rateTo
 }
 
-func (srv *currencyConverter) Candle(d time.Time) (core.HistoryCandle, error) {
-	if srv.candles == nil {
-		var candles, err = srv.historyCandleStorage.Read(srv.codeTo)
-		if err != nil {
-			return core.HistoryCandle{}, err
-		}
-		srv.candles = candles
+func (srv *currencyConverter) fail(err error) float64 {
+	if srv.err == nil {
+		srv.err = err
 	}
-	var index = -1
-	for i := range srv.candles {
-		var item = &srv.candles[i]
-		if item.DateTime.After(d) {
-			break
+	return math.NaN()
+}
+
+// Rate курс валюты (или цена бумаги) в рублях на дату d (последний известный).
+func (srv *currencyConverter) Rate(currency string, d time.Time) (float64, error) {
+	currency = currencyCode(currency)
+	if currency == rubCurrency {
+		return 1, nil
+	}
+	var candles, found = srv.rates[currency]
+	if !found {
+		var err error
+		candles, err = srv.historyCandleStorage.Read(rateSecurityCode(currency))
+		if err != nil {
+			return 0, fmt.Errorf("rate not found %v: %v", currency, err)
 		}
-		index = i
+		srv.rates[currency] = candles
 	}
+	var index = sort.Search(len(candles), func(i int) bool {
+		return candles[i].DateTime.After(d)
+	}) - 1
 	if index == -1 {
-		return core.HistoryCandle{}, core.ErrNoData
+		return 0, fmt.Errorf("rate not found %v %v: %v", currency, d.Format(dateLayout), core.ErrNoData)
+	}
+	return candles[index].C, nil
+}
+
+// securityCurrency валюта цены бумаги. По умолчанию рубли.
+func securityCurrency(securityCode string,
+	securityInfoDirectory core.SecurityInfoDirectory) string {
+	info, found := securityInfoDirectory.Read(securityCode)
+	if !found {
+		return rubCurrency
+	}
+	return currencyCode(info.Currency)
+}
+
+// tradeCurrency валюта сделки: из сделки, иначе валюта цены бумаги.
+func tradeCurrency(t core.MyTrade,
+	securityInfoDirectory core.SecurityInfoDirectory) string {
+	if t.Currency != "" {
+		return currencyCode(t.Currency)
 	}
-	return srv.candles[index], nil
+	return securityCurrency(t.SecurityCode, securityInfoDirectory)
 }
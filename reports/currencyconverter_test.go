@@ -0,0 +1,97 @@
+package reports
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/ChizhovVadim/assets/core"
+)
+
+// testCandleStorage свечи по тикеру, остальные методы HistoryCandleStorage не нужны.
+type testCandleStorage struct {
+	core.HistoryCandleStorage
+	candles map[string][]core.HistoryCandle
+}
+
+func (s testCandleStorage) Read(securityCode string) ([]core.HistoryCandle, error) {
+	var candles, found = s.candles[securityCode]
+	if !found {
+		return nil, core.ErrNoData
+	}
+	return candles, nil
+}
+
+func testDate(year int, month time.Month, day int) time.Time {
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+func TestCurrencyCode(t *testing.T) {
+	var tests = []struct {
+		code, result string
+	}{
+		{"", "RUB"},
+		{"rur", "RUB"},
+		{" usd ", "USD"},
+		{"USDCB", "USD"},
+		{"eurcb", "EUR"},
+		{"MCFTR", "MCFTR"},
+		{"GLDRUB_TOM", "GLDRUB_TOM"},
+	}
+	for _, test := range tests {
+		if result := currencyCode(test.code); result != test.result {
+			t.Errorf("currencyCode(%q) = %v, want %v", test.code, result, test.result)
+		}
+	}
+}
+
+func TestCurrencyConverter(t *testing.T) {
+	var storage = testCandleStorage{candles: map[string][]core.HistoryCandle{
+		"USDCB": {
+			{DateTime: testDate(2024, 1, 10), C: 90},
+			{DateTime: testDate(2024, 1, 20), C: 100},
+		},
+		"EURCB": {{DateTime: testDate(2024, 1, 10), C: 99}},
+		"MCFTR": {{DateTime: testDate(2024, 1, 10), C: 8000}},
+	}}
+	var tests = []struct {
+		name          string
+		codeTo        string
+		from          string
+		date          time.Time
+		value, result float64
+	}{
+		{"rub", "", "RUR", testDate(2024, 1, 15), 1000, 1000},
+		{"to usd", "USDCB", "RUB", testDate(2024, 1, 15), 900, 10},
+		{"last known rate", "USD", "RUB", testDate(2024, 2, 1), 900, 9},
+		{"from usd", "", "USD", testDate(2024, 1, 20), 10, 1000},
+		{"cross rate", "EUR", "USD", testDate(2024, 1, 15), 11, 10},
+		{"ticker as unit", "MCFTR", "RUB", testDate(2024, 1, 15), 16000, 2},
+	}
+	for _, test := range tests {
+		var srv = newCurrencyConverter(storage, test.codeTo)
+		var result = srv.ConvertFrom(test.from, test.date, test.value)
+		if math.Abs(result-test.result) > 1e-9 || srv.Err() != nil {
+			t.Errorf("%v: got %v %v, want %v", test.name, result, srv.Err(), test.result)
+		}
+	}
+}
+
+func TestCurrencyConverterMissingRate(t *testing.T) {
+	var storage = testCandleStorage{candles: map[string][]core.HistoryCandle{
+		"USDCB": {{DateTime: testDate(2024, 1, 10), C: 90}},
+	}}
+	var tests = []struct {
+		name, from string
+		date       time.Time
+	}{
+		{"no series", "GBP", testDate(2024, 1, 15)},
+		{"before first rate", "USD", testDate(2024, 1, 5)},
+	}
+	for _, test := range tests {
+		var srv = newCurrencyConverter(storage, "")
+		if result := srv.ConvertFrom(test.from, test.date, 10); !math.IsNaN(result) || srv.Err() == nil {
+			t.Errorf("%v: got %v %v, want error", test.name, result, srv.Err())
+		}
+	}
+}
@@ -10,19 +10,25 @@ import (
 )
 
 type DividendReportService struct {
-	myTradeStorage        core.MyTradeStorage
-	securityInfoDirectory core.SecurityInfoDirectory
-	myDividendStorage     core.MyDividendStorage
+	myTradeStorage          core.MyTradeStorage
+	securityInfoDirectory   core.SecurityInfoDirectory
+	myDividendStorage       core.MyDividendStorage
+	corporateActionStorage  core.CorporateActionStorage
+	securityTransferStorage core.SecurityTransferStorage
 }
 
 func NewDividendReportService(
 	myTradeStorage core.MyTradeStorage,
 	securityInfoDirectory core.SecurityInfoDirectory,
-	myDividendStorage core.MyDividendStorage) *DividendReportService {
+	myDividendStorage core.MyDividendStorage,
+	corporateActionStorage core.CorporateActionStorage,
+	securityTransferStorage core.SecurityTransferStorage) *DividendReportService {
 	return &DividendReportService{
-		myTradeStorage:        myTradeStorage,
-		securityInfoDirectory: securityInfoDirectory,
-		myDividendStorage:     myDividendStorage,
+		myTradeStorage:          myTradeStorage,
+		securityInfoDirectory:   securityInfoDirectory,
+		myDividendStorage:       myDividendStorage,
+		corporateActionStorage:  corporateActionStorage,
+		securityTransferStorage: securityTransferStorage,
 	}
 }
 
@@ -53,6 +59,15 @@ func (srv *DividendReportService) BuildDividendReport(year int,
 	if err != nil {
 		return DividendReport{}, err
 	}
+	actions, err := srv.corporateActionStorage.Read()
+	if err != nil {
+		return DividendReport{}, err
+	}
+	transfers, err := srv.securityTransferStorage.Read()
+	if err != nil {
+		return DividendReport{}, err
+	}
+	tt = append(tt, securityTransferTrades(transfers, account)...)
 	var report = DividendReport{
 		Year:    year,
 		Account: account,
@@ -61,17 +76,20 @@ func (srv *DividendReportService) BuildDividendReport(year int,
 		if d.RecordDate.Year() != year {
 			continue
 		}
-		var shares = calculateShares(tt, d.RecordDate, d.SecurityCode, account)
+		// количество бумаг на дату отсечки с учетом действий до этой даты
+		var shares = calculateShares(applyCorporateActions(tt, actions, d.RecordDate, corporateActionRules{}),
+			d.RecordDate, d.SecurityCode, account)
 		if shares == 0 {
 			continue
 		}
 		var security = securityTitle(d.SecurityCode, srv.securityInfoDirectory)
+		var category = dividendIncomeCategory(d.SecurityCode, srv.securityInfoDirectory)
 		var item = DividendItem{
 			Security:   security,
 			RecordDate: d.RecordDate,
 			Rate:       d.Rate,
 			Shares:     shares,
-			Expected:   calculateExpectedDividend(d.Rate, shares, d.RecordDate), // or RecieveDate if exists?
+			Expected:   calculateExpectedDividend(d.Rate, shares, d.RecordDate, category), // or RecieveDate if exists?
 		}
 		if d.ReceivedDividend != nil {
 			item.PaymentDate = d.ReceivedDividend.Date
@@ -99,17 +117,20 @@ func calculateShares(tt []core.MyTrade, date time.Time, securityCode, account st
 	return shares
 }
 
-func calculateExpectedDividend(rate float64, shares int, date time.Time) float64 {
+func calculateExpectedDividend(rate float64, shares int, date time.Time,
+	category IncomeCategory) float64 {
 	var sum = math.Round(rate*float64(shares)*100) / 100
-	var ndfl = math.Round(sum * dividendTaxRate(date))
+	var ndfl = math.Round(sum * taxRate(date.Year(), true, category))
 	return math.Round((sum-ndfl)*100) / 100
 }
 
-func dividendTaxRate(d time.Time) float64 {
-	if d.Year() <= 2014 {
-		return 0.09
+// dividendIncomeCategory дивиденды по бумагам с ценой в валюте считаются иностранными.
+func dividendIncomeCategory(securityCode string,
+	securityInfoDirectory core.SecurityInfoDirectory) IncomeCategory {
+	if securityCurrency(securityCode, securityInfoDirectory) != rubCurrency {
+		return IncomeForeignDividends
 	}
-	return 0.13
+	return IncomeDividends
 }
 
 func PrintDividendReport(report DividendReport) {
@@ -15,25 +15,59 @@ type periodSum struct {
 	Sum    float64
 }
 
+// InternalRateOfReturn годовая доходность в виде множителя (1.1 = 10%).
+// Корень NPV ищется делением отрезка, если на концах отрезка NPV разного знака.
 func InternalRateOfReturn(cashflows []DateSum) float64 {
 	var items = calculatePeriodSums(cashflows)
+	var f = func(x float64) float64 {
+		return npv(items, x)
+	}
+	if rate, ok := findRoot(f, 0.01, 10, 1e-9); ok {
+		return rate
+	}
 	return minimizeF(func(x float64) float64 {
-		return math.Abs(npv(items, x))
+		return math.Abs(f(x))
 	}, 0.11, 5, 0.1, 4)
 }
 
+// calculatePeriodSums периоды в годах от самого раннего потока.
 func calculatePeriodSums(cashflows []DateSum) []periodSum {
-	var now = time.Now()
+	var start time.Time
+	for i, c := range cashflows {
+		if i == 0 || c.Date.Before(start) {
+			start = c.Date
+		}
+	}
 	var result []periodSum
 	for _, c := range cashflows {
 		result = append(result, periodSum{
-			period: yearsBetween(now, c.Date),
+			period: yearsBetween(start, c.Date),
 			Sum:    c.Sum,
 		})
 	}
 	return result
 }
 
+func findRoot(f func(float64) float64, a, b, eps float64) (float64, bool) {
+	var fa, fb = f(a), f(b)
+	if math.IsNaN(fa) || math.IsNaN(fb) || fa*fb > 0 {
+		return 0, false
+	}
+	for b-a > eps {
+		var m = (a + b) / 2
+		var fm = f(m)
+		if fm == 0 {
+			return m, true
+		}
+		if fa*fm < 0 {
+			b = m
+		} else {
+			a, fa = m, fm
+		}
+	}
+	return (a + b) / 2, true
+}
+
 func npv(source []periodSum, rate float64) float64 {
 	var sum = 0.0
 	for _, item := range source {
@@ -0,0 +1,262 @@
+package reports
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/ChizhovVadim/assets/core"
+)
+
+// CostBasisMethod способ выбора закрываемых лотов.
+type CostBasisMethod string
+
+const (
+	CostBasisFifo CostBasisMethod = "fifo"
+	// CostBasisAverage средняя цена открытых лотов, даты открытия - по FIFO.
+	CostBasisAverage CostBasisMethod = "average"
+	// CostBasisSpecific лоты из MyTrade.Lots закрывающей сделки, остаток - по FIFO.
+	CostBasisSpecific CostBasisMethod = "specific"
+)
+
+// ParseCostBasisMethod пусто - FIFO.
+func ParseCostBasisMethod(s string) (CostBasisMethod, error) {
+	switch method := CostBasisMethod(strings.ToLower(s)); method {
+	case "":
+		return CostBasisFifo, nil
+	case CostBasisFifo, CostBasisAverage, CostBasisSpecific:
+		return method, nil
+	default:
+		return "", fmt.Errorf("unknown cost basis method %q", s)
+	}
+}
+
+// LotAnomaly сделка, которую не удалось сопоставить обычным образом.
+// Обычно означает пропущенные сделки или переводы.
+type LotAnomaly struct {
+	Trade   core.MyTrade
+	Message string
+}
+
+// lotMatchResult результат сопоставления сделок: открытые лоты (короткие - с отрицательным количеством),
+// закрытые части лотов и замечания. Shorts - части продаж, открывшие короткую позицию:
+// короткие продажи допустимы, список справочный. openSources - индексы сделок открытых лотов в исходном списке.
+type lotMatchResult struct {
+	Open        []core.MyTrade
+	Closed      []ClosedMyTrade
+	Anomalies   []LotAnomaly
+	Shorts      []core.MyTrade
+	openSources []int
+}
+
+func (r *lotMatchResult) Len() int { return len(r.Open) }
+
+func (r *lotMatchResult) Less(i, j int) bool {
+	return r.Open[i].ExecutionDate.Before(r.Open[j].ExecutionDate)
+}
+
+func (r *lotMatchResult) Swap(i, j int) {
+	r.Open[i], r.Open[j] = r.Open[j], r.Open[i]
+	r.openSources[i], r.openSources[j] = r.openSources[j], r.openSources[i]
+}
+
+// matchLots сопоставляет сделки отдельно по каждому счету и бумаге в порядке исполнения.
+// Продажа без открытой длинной позиции открывает короткую позицию, которую закрывают последующие покупки.
+// Комиссии открытых лотов уменьшаются пропорционально закрытой части.
+func matchLots(tt []core.MyTrade, method CostBasisMethod) lotMatchResult {
+	type position struct {
+		account      string
+		securityCode string
+	}
+	var sorted = make([]int, 0, len(tt))
+	for i, t := range tt {
+		if t.Volume != 0 {
+			sorted = append(sorted, i)
+		}
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		var ti, tj = tt[sorted[i]], tt[sorted[j]]
+		if !ti.ExecutionDate.Equal(tj.ExecutionDate) {
+			return ti.ExecutionDate.Before(tj.ExecutionDate)
+		}
+		return ti.DateTime.Before(tj.DateTime)
+	})
+	var result lotMatchResult
+	var lots = make(map[position][]core.MyTrade)
+	// индексы сделок открытых лотов, параллельно lots
+	var sources = make(map[position][]int)
+	var positions []position
+	for _, source := range sorted {
+		var t = tt[source]
+		if t.Price < 0 {
+			result.Anomalies = append(result.Anomalies, LotAnomaly{t, "отрицательная цена"})
+		}
+		var key = position{strings.ToLower(t.Account), t.SecurityCode}
+		var open, found = lots[key]
+		var openSources = sources[key]
+		if !found {
+			positions = append(positions, key)
+		}
+		var rest = t
+		var closing = len(open) > 0 && (open[0].Volume > 0) != (t.Volume > 0)
+		if closing && method == CostBasisAverage {
+			averageLots(open)
+		}
+		var selections []core.LotSelection
+		if closing && method == CostBasisSpecific {
+			selections = append(selections, t.Lots...)
+		}
+		for len(open) > 0 && rest.Volume != 0 && (open[0].Volume > 0) != (rest.Volume > 0) {
+			var index, volume = 0, minInt(absInt(open[0].Volume), absInt(rest.Volume))
+			for len(selections) > 0 {
+				var selection = selections[0]
+				index = findLot(open, selection.Lot)
+				if index != -1 {
+					volume = minInt(minInt(absInt(open[index].Volume), absInt(rest.Volume)), selection.Volume)
+					selections[0].Volume -= volume
+					if selections[0].Volume == 0 {
+						selections = selections[1:]
+					}
+					break
+				}
+				result.Anomalies = append(result.Anomalies, LotAnomaly{t,
+					fmt.Sprintf("лот %v не найден, закрыто по FIFO %v", selection.Lot, selection.Volume)})
+				selections = selections[1:]
+				index = 0
+				volume = minInt(absInt(open[0].Volume), absInt(rest.Volume))
+			}
+			var lot = open[index]
+			var lotShare = float64(volume) / float64(absInt(lot.Volume))
+			result.Closed = append(result.Closed, ClosedMyTrade{
+				SecurityCode:   t.SecurityCode,
+				Account:        lot.Account,
+				OpenDate:       lot.ExecutionDate,
+				CloseDate:      t.ExecutionDate,
+				OpenPrice:      lot.Price,
+				ClosePrice:     t.Price,
+				Volume:         volume,
+				Short:          lot.Volume < 0,
+				OpenComission:  tradeComission(lot) * lotShare,
+				CloseComission: tradeComission(t) * float64(volume) / float64(absInt(t.Volume)),
+			})
+			if volume == absInt(lot.Volume) {
+				open = append(open[:index:index], open[index+1:]...)
+				openSources = append(openSources[:index:index], openSources[index+1:]...)
+			} else {
+				open[index] = reduceLot(lot, volume)
+			}
+			rest = reduceLot(rest, volume)
+		}
+		if rest.Volume != 0 {
+			if rest.Volume < 0 {
+				result.Shorts = append(result.Shorts, rest)
+			}
+			open = append(open, rest)
+			openSources = append(openSources, source)
+		}
+		lots[key] = open
+		sources[key] = openSources
+	}
+	for _, key := range positions {
+		for i, lot := range lots[key] {
+			result.Open = append(result.Open, lot)
+			result.openSources = append(result.openSources, sources[key][i])
+		}
+	}
+	sort.Stable(&result)
+	return result
+}
+
+// findLot индекс открытого лота по TradeId или дате исполнения.
+func findLot(open []core.MyTrade, lot string) int {
+	for i, t := range open {
+		if t.TradeId != "" && t.TradeId == lot {
+			return i
+		}
+	}
+	for i, t := range open {
+		if t.ExecutionDate.Format(dateLayout) == lot {
+			return i
+		}
+	}
+	return -1
+}
+
+// averageLots приводит цену и комиссии на бумагу открытых лотов к средним.
+// Закрытие части позиции не меняет среднюю цену остатка.
+func averageLots(open []core.MyTrade) {
+	var volume, amount, exchangeComission, brokerComission, accruedInterest = 0, 0.0, 0.0, 0.0, 0.0
+	for _, t := range open {
+		volume += t.Volume
+		amount += t.Price * float64(t.Volume)
+		exchangeComission += t.ExchangeComission
+		brokerComission += t.BrokerComission
+		accruedInterest += t.AccruedInterest
+	}
+	for i := range open {
+		var share = float64(open[i].Volume) / float64(volume)
+		open[i].Price = amount / float64(volume)
+		open[i].ExchangeComission = exchangeComission * share
+		open[i].BrokerComission = brokerComission * share
+		open[i].AccruedInterest = accruedInterest * share
+	}
+}
+
+// openLots открытые лоты позиции (сделки indexes по одному счету и бумаге) при способе method
+// и индексы их сделок в tt. У каждой сделки не больше одного открытого лота.
+func openLots(tt []core.MyTrade, indexes []int, method CostBasisMethod) ([]core.MyTrade, []int) {
+	var position = make([]core.MyTrade, len(indexes))
+	for i, index := range indexes {
+		position[i] = tt[index]
+	}
+	var lots = matchLots(position, method)
+	var sources = make([]int, len(lots.openSources))
+	for i, source := range lots.openSources {
+		sources[i] = indexes[source]
+	}
+	return lots.Open, sources
+}
+
+// takeLot выделяет volume бумаг открытого лота lot из его сделки tt[source].
+// В сделке остается закрытая часть и остаток лота, поэтому сопоставление
+// закрытых ранее частей не меняется.
+func takeLot(tt []core.MyTrade, source int, lot core.MyTrade, volume int) core.MyTrade {
+	tt[source] = reduceLot(tt[source], volume)
+	return reduceLot(lot, absInt(lot.Volume)-volume)
+}
+
+// reduceLot уменьшает лот на volume бумаг, комиссии и НКД уменьшаются пропорционально.
+func reduceLot(t core.MyTrade, volume int) core.MyTrade {
+	var rest = float64(absInt(t.Volume)-volume) / float64(absInt(t.Volume))
+	if t.Volume > 0 {
+		t.Volume -= volume
+	} else {
+		t.Volume += volume
+	}
+	t.ExchangeComission *= rest
+	t.BrokerComission *= rest
+	t.AccruedInterest *= rest
+	return t
+}
+
+func absInt(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+func printLotAnomalies(items []LotAnomaly) {
+	if len(items) == 0 {
+		return
+	}
+	fmt.Println("Замечания по сопоставлению сделок")
+	var w = newTabWriter()
+	fmt.Fprintf(w, "Account\tSecurity\tDate\tPrice\tVolume\tMessage\t\n")
+	for _, item := range items {
+		var t = item.Trade
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\t\n",
+			t.Account, t.SecurityCode, t.ExecutionDate.Format(dateLayout), t.Price, t.Volume, item.Message)
+	}
+	w.Flush()
+}
@@ -0,0 +1,186 @@
+package reports
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/ChizhovVadim/assets/core"
+)
+
+func sameAmount(a, b float64) bool {
+	return math.Abs(a-b) < 1e-6
+}
+
+// testLot открытый лот: бумага, количество, цена и комиссия.
+type testLot struct {
+	securityCode string
+	volume       int
+	price        float64
+	comission    float64
+}
+
+func checkClosedTrades(t *testing.T, name string, closed []ClosedMyTrade, expected []ClosedMyTrade) {
+	if len(closed) != len(expected) {
+		t.Errorf("%v: got %v closed trades, want %v: %+v", name, len(closed), len(expected), closed)
+		return
+	}
+	for i, c := range closed {
+		var e = expected[i]
+		if c.SecurityCode != e.SecurityCode || !strings.EqualFold(c.Account, e.Account) || c.Volume != e.Volume || c.Short != e.Short ||
+			!sameAmount(c.OpenPrice, e.OpenPrice) || !sameAmount(c.ClosePrice, e.ClosePrice) ||
+			!sameAmount(c.OpenComission, e.OpenComission) || !sameAmount(c.CloseComission, e.CloseComission) {
+			t.Errorf("%v: closed trade %v got %+v, want %+v", name, i, c, e)
+		}
+	}
+}
+
+func checkOpenLots(t *testing.T, name string, open []core.MyTrade, expected []testLot) {
+	if len(open) != len(expected) {
+		t.Errorf("%v: got %v open lots, want %v: %+v", name, len(open), len(expected), open)
+		return
+	}
+	for i, lot := range open {
+		var e = expected[i]
+		if lot.SecurityCode != e.securityCode || lot.Volume != e.volume ||
+			!sameAmount(lot.Price, e.price) || !sameAmount(tradeComission(lot), e.comission) {
+			t.Errorf("%v: open lot %v got %v %v %v %v, want %+v", name, i,
+				lot.SecurityCode, lot.Volume, lot.Price, tradeComission(lot), e)
+		}
+	}
+}
+
+func TestMatchLots(t *testing.T) {
+	// две покупки и частичная продажа: комиссии с обеих сторон делятся пропорционально
+	var partialClose = []core.MyTrade{
+		{SecurityCode: "SBER", ExecutionDate: testDate(2023, 1, 10), Price: 100, Volume: 10, BrokerComission: 10, TradeId: "1"},
+		{SecurityCode: "SBER", ExecutionDate: testDate(2023, 2, 10), Price: 110, Volume: 10, BrokerComission: 20, TradeId: "2"},
+		{SecurityCode: "SBER", ExecutionDate: testDate(2023, 3, 10), Price: 120, Volume: -15, BrokerComission: 30},
+	}
+	var withLots = func(tt []core.MyTrade, lots ...core.LotSelection) []core.MyTrade {
+		var result = append([]core.MyTrade(nil), tt...)
+		result[len(result)-1].Lots = lots
+		return result
+	}
+	var tests = []struct {
+		name      string
+		method    CostBasisMethod
+		trades    []core.MyTrade
+		closed    []ClosedMyTrade
+		open      []testLot
+		anomalies []string
+		shorts    []int // количество в частях продаж, открывших короткую позицию
+	}{
+		{
+			name:   "fifo partial close",
+			method: CostBasisFifo,
+			trades: partialClose,
+			closed: []ClosedMyTrade{
+				{SecurityCode: "SBER", Volume: 10, OpenPrice: 100, ClosePrice: 120, OpenComission: 10, CloseComission: 20},
+				{SecurityCode: "SBER", Volume: 5, OpenPrice: 110, ClosePrice: 120, OpenComission: 10, CloseComission: 10},
+			},
+			open: []testLot{{"SBER", 5, 110, 10}},
+		},
+		{
+			name:   "average partial close",
+			method: CostBasisAverage,
+			trades: partialClose,
+			closed: []ClosedMyTrade{
+				{SecurityCode: "SBER", Volume: 10, OpenPrice: 105, ClosePrice: 120, OpenComission: 15, CloseComission: 20},
+				{SecurityCode: "SBER", Volume: 5, OpenPrice: 105, ClosePrice: 120, OpenComission: 7.5, CloseComission: 10},
+			},
+			open: []testLot{{"SBER", 5, 105, 7.5}},
+		},
+		{
+			name:   "specific lot by trade id, rest by fifo",
+			method: CostBasisSpecific,
+			trades: withLots(partialClose, core.LotSelection{Lot: "2", Volume: 10}),
+			closed: []ClosedMyTrade{
+				{SecurityCode: "SBER", Volume: 10, OpenPrice: 110, ClosePrice: 120, OpenComission: 20, CloseComission: 20},
+				{SecurityCode: "SBER", Volume: 5, OpenPrice: 100, ClosePrice: 120, OpenComission: 5, CloseComission: 10},
+			},
+			open: []testLot{{"SBER", 5, 100, 5}},
+		},
+		{
+			name:   "specific lot by date",
+			method: CostBasisSpecific,
+			trades: withLots(partialClose, core.LotSelection{Lot: "2023-02-10", Volume: 5}),
+			closed: []ClosedMyTrade{
+				{SecurityCode: "SBER", Volume: 5, OpenPrice: 110, ClosePrice: 120, OpenComission: 10, CloseComission: 10},
+				{SecurityCode: "SBER", Volume: 10, OpenPrice: 100, ClosePrice: 120, OpenComission: 10, CloseComission: 20},
+			},
+			open: []testLot{{"SBER", 5, 110, 10}},
+		},
+		{
+			name:   "specific lot not found",
+			method: CostBasisSpecific,
+			trades: withLots(partialClose, core.LotSelection{Lot: "9", Volume: 5}),
+			closed: []ClosedMyTrade{
+				{SecurityCode: "SBER", Volume: 10, OpenPrice: 100, ClosePrice: 120, OpenComission: 10, CloseComission: 20},
+				{SecurityCode: "SBER", Volume: 5, OpenPrice: 110, ClosePrice: 120, OpenComission: 10, CloseComission: 10},
+			},
+			open:      []testLot{{"SBER", 5, 110, 10}},
+			anomalies: []string{"лот 9 не найден, закрыто по FIFO 5"},
+		},
+		{
+			name:   "long to short flip",
+			method: CostBasisFifo,
+			trades: []core.MyTrade{
+				{SecurityCode: "SBER", ExecutionDate: testDate(2023, 1, 10), Price: 100, Volume: 10, BrokerComission: 10},
+				{SecurityCode: "SBER", ExecutionDate: testDate(2023, 3, 10), Price: 120, Volume: -15, BrokerComission: 15},
+				{SecurityCode: "SBER", ExecutionDate: testDate(2023, 4, 10), Price: 90, Volume: 5, BrokerComission: 5},
+			},
+			closed: []ClosedMyTrade{
+				{SecurityCode: "SBER", Volume: 10, OpenPrice: 100, ClosePrice: 120, OpenComission: 10, CloseComission: 10},
+				{SecurityCode: "SBER", Volume: 5, Short: true, OpenPrice: 120, ClosePrice: 90, OpenComission: 5, CloseComission: 5},
+			},
+			shorts: []int{-5},
+		},
+		{
+			name:   "short to long flip",
+			method: CostBasisAverage,
+			trades: []core.MyTrade{
+				{SecurityCode: "SBER", ExecutionDate: testDate(2023, 1, 10), Price: 120, Volume: -10, BrokerComission: 10},
+				{SecurityCode: "SBER", ExecutionDate: testDate(2023, 3, 10), Price: 100, Volume: 12, BrokerComission: 12},
+			},
+			closed: []ClosedMyTrade{
+				{SecurityCode: "SBER", Volume: 10, Short: true, OpenPrice: 120, ClosePrice: 100, OpenComission: 10, CloseComission: 10},
+			},
+			open:   []testLot{{"SBER", 2, 100, 2}},
+			shorts: []int{-10},
+		},
+		{
+			name:   "accounts matched separately, case insensitive",
+			method: CostBasisFifo,
+			trades: []core.MyTrade{
+				{SecurityCode: "SBER", ExecutionDate: testDate(2023, 1, 10), Price: 100, Volume: 10, Account: "IIS"},
+				{SecurityCode: "SBER", ExecutionDate: testDate(2023, 2, 10), Price: 110, Volume: 10, Account: "broker"},
+				{SecurityCode: "SBER", ExecutionDate: testDate(2023, 3, 10), Price: 120, Volume: -10, Account: "iis"},
+			},
+			closed: []ClosedMyTrade{
+				{SecurityCode: "SBER", Account: "IIS", Volume: 10, OpenPrice: 100, ClosePrice: 120},
+			},
+			open: []testLot{{"SBER", 10, 110, 0}},
+		},
+	}
+	for _, test := range tests {
+		var lots = matchLots(test.trades, test.method)
+		checkClosedTrades(t, test.name, lots.Closed, test.closed)
+		checkOpenLots(t, test.name, lots.Open, test.open)
+		var anomalies []string
+		for _, a := range lots.Anomalies {
+			anomalies = append(anomalies, a.Message)
+		}
+		if strings.Join(anomalies, "; ") != strings.Join(test.anomalies, "; ") {
+			t.Errorf("%v: got anomalies %v, want %v", test.name, anomalies, test.anomalies)
+		}
+		var shorts []int
+		for _, s := range lots.Shorts {
+			shorts = append(shorts, s.Volume)
+		}
+		if fmt.Sprint(shorts) != fmt.Sprint(test.shorts) {
+			t.Errorf("%v: got shorts %v, want %v", test.name, shorts, test.shorts)
+		}
+	}
+}
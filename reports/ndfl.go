@@ -3,44 +3,116 @@ package reports
 import (
 	"fmt"
 	"math"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/ChizhovVadim/assets/core"
 )
 
 type NdflReportService struct {
-	myTradeStorage        core.MyTradeStorage
-	historyCandleStorage  core.HistoryCandleStorage
-	securityInfoDirectory core.SecurityInfoDirectory
+	myTradeStorage          core.MyTradeStorage
+	historyCandleStorage    core.HistoryCandleStorage
+	securityInfoDirectory   core.SecurityInfoDirectory
+	myDividendStorage       core.MyDividendStorage
+	corporateActionStorage  core.CorporateActionStorage
+	securityTransferStorage core.SecurityTransferStorage
 }
 
 func NewNdflReportService(
 	myTradeStorage core.MyTradeStorage,
 	historyCandleStorage core.HistoryCandleStorage,
-	securityInfoDirectory core.SecurityInfoDirectory) *NdflReportService {
+	securityInfoDirectory core.SecurityInfoDirectory,
+	myDividendStorage core.MyDividendStorage,
+	corporateActionStorage core.CorporateActionStorage,
+	securityTransferStorage core.SecurityTransferStorage) *NdflReportService {
 	return &NdflReportService{
-		myTradeStorage:        myTradeStorage,
-		historyCandleStorage:  historyCandleStorage,
-		securityInfoDirectory: securityInfoDirectory,
+		myTradeStorage:          myTradeStorage,
+		historyCandleStorage:    historyCandleStorage,
+		securityInfoDirectory:   securityInfoDirectory,
+		myDividendStorage:       myDividendStorage,
+		corporateActionStorage:  corporateActionStorage,
+		securityTransferStorage: securityTransferStorage,
 	}
 }
 
 type NdflReport struct {
 	Year              int
 	Account           string
+	Method            CostBasisMethod
 	Trades            []ClosedMyTrade
+	Anomalies         []LotAnomaly
+	Shorts            []core.MyTrade // открытие коротких позиций за год
+	Proceeds          float64        // доходы от продажи
+	Cost              float64        // расходы на покупку
+	Expenses          float64        // комиссии покупки и продажи
 	PnLTotal          float64
 	Ndfl              float64
 	NdflWithDeduction float64
+	// льгота за длительное владение (ЛДВ): предельный размер и остаток - по всем счетам,
+	// использованная часть - по счету отчета
+	LdvCap         float64
+	LdvUsed        float64
+	LdvRemaining   float64
+	Coupons        []NdflCoupon
+	CouponsTotal   float64
+	CouponsTaxable float64
+	NdflCoupons    float64
+	// дивиденды до удержания налога, иностранные - в рублях по курсу ЦБ на дату выплаты
+	Dividends            float64
+	NdflDividends        float64
+	ForeignDividends     float64
+	ForeignDividendsTax  float64 // налог, удержанный за рубежом
+	NdflForeignDividends float64 // к доплате в РФ
+	Resident             bool
+	IncomeTotal          float64 // доходы за год по всем счетам, по которым определяется ставка
 }
 
+type NdflCoupon struct {
+	SecurityCode string
+	Date         time.Time
+	Volume       int
+	Sum          float64
+	Taxable      bool
+}
+
+// ClosedMyTrade закрытая часть лота. Для короткой позиции (Short) открытие - продажа, закрытие - покупка.
 type ClosedMyTrade struct {
 	SecurityCode string
+	Account      string
 	OpenDate     time.Time
 	CloseDate    time.Time
 	OpenPrice    float64
 	ClosePrice   float64
 	Volume       int
+	Short        bool
+	// комиссии, приходящиеся на закрытую часть сделок покупки и продажи
+	OpenComission  float64
+	CloseComission float64
+	Ldv            float64 // часть вычета ЛДВ, приходящаяся на лот
+}
+
+func (t ClosedMyTrade) Proceeds() float64 {
+	if t.Short {
+		return t.OpenPrice * float64(t.Volume)
+	}
+	return t.ClosePrice * float64(t.Volume)
+}
+
+func (t ClosedMyTrade) Cost() float64 {
+	if t.Short {
+		return t.ClosePrice * float64(t.Volume)
+	}
+	return t.OpenPrice * float64(t.Volume)
+}
+
+func (t ClosedMyTrade) Expenses() float64 {
+	return t.OpenComission + t.CloseComission
+}
+
+// PnL финансовый результат: доходы за вычетом стоимости покупки и комиссий.
+func (t ClosedMyTrade) PnL() float64 {
+	return t.Proceeds() - t.Cost() - t.Expenses()
 }
 
 func minInt(a, b int) int {
@@ -50,37 +122,169 @@ func minInt(a, b int) int {
 	return b
 }
 
-func (srv *NdflReportService) BuildNdflReport(year int, account string) (NdflReport, error) {
-	var tt, err = srv.myTradeStorage.Read(account)
+// BuildNdflReport НДФЛ за год. resident - налоговый резидент РФ.
+func (srv *NdflReportService) BuildNdflReport(year int, account string,
+	method CostBasisMethod, resident bool) (NdflReport, error) {
+	// сделки всех счетов: лоты могли быть переведены с другого счета
+	var tt, err = srv.myTradeStorage.Read("")
+	if err != nil {
+		return NdflReport{}, err
+	}
+	actions, err := srv.corporateActionStorage.Read()
 	if err != nil {
 		return NdflReport{}, err
 	}
-	var _, closedTrades = splitOpenAndClosedTrades(tt)
-	closedTrades = filterClosedTrades(closedTrades, year)
-	var pnLTotal = totalPnL(closedTrades)
-	var ndlf = computeNdfl(pnLTotal)
-	var pnlDeduction = computePnLDeduction(closedTrades)
-	var ndflDeduction = computeNdfl(pnLTotal - pnlDeduction)
+	transfers, err := srv.securityTransferStorage.Read()
+	if err != nil {
+		return NdflReport{}, err
+	}
+	// лоты переносятся через конвертации, необлагаемые обмены и переводы между счетами
+	// с сохранением даты открытия
+	var now = time.Now()
+	tt, transferAnomalies := applyTransfersAndCorporateActions(tt, actions, transfers, now,
+		corporateActionRules{
+			tax:    true,
+			prices: newAdjustedCandleStorage(srv.historyCandleStorage, actions, now),
+			method: method,
+		})
+	var curConv = newCurrencyConverter(srv.historyCandleStorage, rubCurrency)
+	tt = srv.taxTrades(tt, curConv, time.Now())
+	var lots = matchLots(tt, method)
+	lots.Anomalies = append(transferAnomalies, lots.Anomalies...)
 	var report = NdflReport{
-		Year:              year,
-		Account:           account,
-		Trades:            closedTrades,
-		PnLTotal:          pnLTotal,
-		Ndfl:              ndlf,
-		NdflWithDeduction: ndflDeduction,
+		Year:     year,
+		Account:  account,
+		Method:   method,
+		Resident: resident,
+	}
+	err = srv.addIncomes(&report, tt, lots, curConv)
+	if err != nil {
+		return NdflReport{}, err
+	}
+	if err = curConv.Err(); err != nil {
+		return NdflReport{}, err
+	}
+	if account == "" {
+		srv.computeTaxes(&report, report)
+		return report, nil
+	}
+	// ставка зависит от доходов за год по всем счетам
+	var total = NdflReport{Year: year, Resident: resident}
+	err = srv.addIncomes(&total, tt, lots, curConv)
+	if err != nil {
+		return NdflReport{}, err
 	}
+	srv.computeTaxes(&report, total)
 	return report, nil
 }
 
+// addIncomes доходы за год по счету отчета: закрытые сделки с ЛДВ, купоны и дивиденды.
+func (srv *NdflReportService) addIncomes(report *NdflReport, tt []core.MyTrade,
+	lots lotMatchResult, curConv *currencyConverter) error {
+	// ЛДВ предоставляется налогоплательщику за год: предельный размер и вычет считаются
+	// по лотам всех счетов, счету отчета достается вычет по его лотам
+	var closed = filterClosedTrades(lots.Closed, report.Year)
+	var ldvLimit, ldvUsed = computeLdv(closed)
+	for _, t := range closed {
+		if report.Account == "" || strings.EqualFold(t.Account, report.Account) {
+			report.Trades = append(report.Trades, t)
+			report.LdvUsed += t.Ldv
+		}
+	}
+	report.LdvCap = ldvLimit
+	report.LdvRemaining = ldvLimit - ldvUsed
+	for _, a := range lots.Anomalies {
+		if report.Account == "" || strings.EqualFold(a.Trade.Account, report.Account) {
+			report.Anomalies = append(report.Anomalies, a)
+		}
+	}
+	for _, t := range lots.Shorts {
+		if t.ExecutionDate.Year() == report.Year &&
+			(report.Account == "" || strings.EqualFold(t.Account, report.Account)) {
+			report.Shorts = append(report.Shorts, t)
+		}
+	}
+	report.PnLTotal = totalPnL(report.Trades)
+	for _, t := range report.Trades {
+		report.Proceeds += t.Proceeds()
+		report.Cost += t.Cost()
+		report.Expenses += t.Expenses()
+	}
+	srv.addCoupons(report, filterAccountTrades(tt, report.Account), curConv)
+	return srv.addDividends(report, curConv)
+}
+
+// addDividends полученные за год дивиденды до удержания налога в рублях. Дивиденды в валюте
+// считаются иностранными. Если налог не указан, сумма - после удержания налога по ставке для резидентов.
+func (srv *NdflReportService) addDividends(report *NdflReport, curConv *currencyConverter) error {
+	var start = time.Date(report.Year, 1, 1, 0, 0, 0, 0, time.UTC)
+	var finish = time.Date(report.Year, 12, 31, 0, 0, 0, 0, time.UTC)
+	dd, err := srv.myDividendStorage.ReadReceivedDividends(report.Account, start, finish)
+	if err != nil {
+		return err
+	}
+	for _, d := range dd {
+		var currency = currencyCode(d.Currency)
+		var sum = curConv.ConvertFrom(currency, d.Date, d.Sum)
+		var tax = curConv.ConvertFrom(currency, d.Date, d.Tax)
+		if currency != rubCurrency {
+			report.ForeignDividends += sum + tax
+			report.ForeignDividendsTax += tax
+			continue
+		}
+		if d.Tax == 0 {
+			sum = math.Round(sum / (1 - taxRate(d.Date.Year(), true, IncomeDividends)))
+		}
+		report.Dividends += sum + tax
+	}
+	return nil
+}
+
+func (report NdflReport) incomes(ldv bool) map[IncomeCategory]float64 {
+	var trades = report.PnLTotal
+	if ldv {
+		trades -= report.LdvUsed
+	}
+	return map[IncomeCategory]float64{
+		IncomeTrades:           trades,
+		IncomeCoupons:          report.CouponsTaxable,
+		IncomeDividends:        report.Dividends,
+		IncomeForeignDividends: report.ForeignDividends,
+	}
+}
+
+// computeTaxes налог по счету отчета: ставки по шкале определяются по доходам total за год по всем счетам,
+// налог по виду дохода делится пропорционально доходу счета. С иностранных дивидендов доплачивается
+// налог за вычетом удержанного за рубежом.
+func (srv *NdflReportService) computeTaxes(report *NdflReport, total NdflReport) {
+	var incomesTotal = total.incomes(true)
+	var taxes = computeIncomeTax(report.Year, report.Resident, incomesTotal)
+	var incomes = report.incomes(true)
+	for _, income := range incomesTotal {
+		report.IncomeTotal += math.Max(0, income)
+	}
+	report.NdflWithDeduction = accountIncomeTax(taxes, incomesTotal, IncomeTrades, incomes[IncomeTrades])
+	report.NdflCoupons = accountIncomeTax(taxes, incomesTotal, IncomeCoupons, incomes[IncomeCoupons])
+	report.NdflDividends = accountIncomeTax(taxes, incomesTotal, IncomeDividends, incomes[IncomeDividends])
+	report.NdflForeignDividends = math.Max(0, accountIncomeTax(taxes, incomesTotal,
+		IncomeForeignDividends, incomes[IncomeForeignDividends])-math.Round(report.ForeignDividendsTax))
+
+	var incomesTotalNoLdv = total.incomes(false)
+	report.Ndfl = accountIncomeTax(computeIncomeTax(report.Year, report.Resident, incomesTotalNoLdv),
+		incomesTotalNoLdv, IncomeTrades, report.PnLTotal)
+}
+
 type PlannedTaxReport struct {
 	Account         string
+	Method          CostBasisMethod
 	Date            time.Time
 	OpenTrades      []core.MyTrade
+	Anomalies       []LotAnomaly
 	ItemsYear3      []PlannedTaxReportItem
 	Items           []PlannedTaxReportItem
 	AmountTotal     float64
 	PnLTotal        float64
-	PlannedTaxTotal float64
+	PlannedTaxTotal float64 // на сколько вырастет НДФЛ за год по всем счетам при продаже лотов счета
 }
 
 type PlannedTaxReportItem struct {
@@ -92,29 +296,58 @@ type PlannedTaxReportItem struct {
 }
 
 func (srv *NdflReportService) BuildPlannedTaxReport(account string,
-	date time.Time) (PlannedTaxReport, error) {
-	var tt, err = srv.myTradeStorage.Read(account)
+	date time.Time, method CostBasisMethod, resident bool) (PlannedTaxReport, error) {
+	var tt, err = srv.myTradeStorage.Read("")
+	if err != nil {
+		return PlannedTaxReport{}, err
+	}
+	actions, err := srv.corporateActionStorage.Read()
+	if err != nil {
+		return PlannedTaxReport{}, err
+	}
+	transfers, err := srv.securityTransferStorage.Read()
+	if err != nil {
+		return PlannedTaxReport{}, err
+	}
+	var candles = newAdjustedCandleStorage(srv.historyCandleStorage, actions, date)
+	tt, transferAnomalies := applyTransfersAndCorporateActions(tt, actions, transfers, date,
+		corporateActionRules{tax: true, prices: candles, method: method})
+	var curConv = newCurrencyConverter(srv.historyCandleStorage, rubCurrency)
+	tt = srv.taxTrades(tt, curConv, date)
+	var lots = matchLots(tt, method)
+	var openTrades = filterAccountTrades(lots.Open, account)
+	// ставка зависит от доходов за год по всем счетам, полученных до продажи открытых лотов
+	var realized = NdflReport{Year: date.Year(), Resident: resident}
+	err = srv.addIncomes(&realized, tt, lots, curConv)
 	if err != nil {
 		return PlannedTaxReport{}, err
 	}
-	var openTrades, _ = splitOpenAndClosedTrades(tt)
 
 	var report = PlannedTaxReport{
 		Account:    account,
+		Method:     method,
 		Date:       date,
 		OpenTrades: openTrades,
 	}
-	report.Items = srv.buildPlannedTaxItems(openTrades)
+	for _, a := range append(transferAnomalies, lots.Anomalies...) {
+		if account == "" || strings.EqualFold(a.Trade.Account, account) {
+			report.Anomalies = append(report.Anomalies, a)
+		}
+	}
+	report.Items = srv.buildPlannedTaxItems(openTrades, candles, curConv)
 	report.ItemsYear3 = srv.buildPlannedTaxItems(
 		filterTrades(openTrades, func(t core.MyTrade) bool {
-			return t.ExecutionDate.Year() >= 2014 &&
+			return t.Volume > 0 && t.ExecutionDate.Year() >= 2014 &&
 				t.ExecutionDate.AddDate(3, 0, 0).Before(date)
-		}))
+		}), candles, curConv)
+	if err = curConv.Err(); err != nil {
+		return PlannedTaxReport{}, err
+	}
 	for _, item := range report.Items {
 		report.AmountTotal += item.Amount
 		report.PnLTotal += item.PnL
 	}
-	report.PlannedTaxTotal = computeNdfl(report.PnLTotal)
+	report.PlannedTaxTotal = plannedIncomeTax(date.Year(), resident, realized.incomes(true), report.PnLTotal)
 
 	return report, nil
 }
@@ -130,7 +363,116 @@ func filterTrades(tt []core.MyTrade,
 	return result
 }
 
-func (srv *NdflReportService) buildPlannedTaxItems(tt []core.MyTrade) []PlannedTaxReportItem {
+// taxTrades приводит сделки к виду для расчета НДФЛ: цена - рубли за бумагу по курсу ЦБ
+// на дату исполнения, для облигаций цена в деньгах с НКД (уплаченный НКД - расход,
+// полученный - доход). Облигации, погашенные до даты date, закрываются сделкой погашения
+// по номиналу, амортизации учитываются в этой же сделке.
+func (srv *NdflReportService) taxTrades(tt []core.MyTrade,
+	curConv *currencyConverter, date time.Time) []core.MyTrade {
+	var result = make([]core.MyTrade, 0, len(tt))
+	for _, t := range tt {
+		var currency = tradeCurrency(t, srv.securityInfoDirectory)
+		if bond := securityBond(t.SecurityCode, srv.securityInfoDirectory); bond != nil && t.Volume != 0 {
+			t.Price = tradeAmount(bond, t) / float64(t.Volume)
+			t.AccruedInterest = 0
+		}
+		if currency != rubCurrency {
+			t.Price = curConv.ConvertFrom(currency, t.ExecutionDate, t.Price)
+			t.ExchangeComission = curConv.ConvertFrom(currency, t.ExecutionDate, t.ExchangeComission)
+			t.BrokerComission = curConv.ConvertFrom(currency, t.ExecutionDate, t.BrokerComission)
+			t.Currency = rubCurrency
+		}
+		result = append(result, t)
+	}
+	return append(result, srv.redemptionTrades(tt, curConv, date)...)
+}
+
+func (srv *NdflReportService) redemptionTrades(tt []core.MyTrade,
+	curConv *currencyConverter, date time.Time) []core.MyTrade {
+	type position struct {
+		securityCode string
+		account      string
+	}
+	var volumes = make(map[position]int)
+	var accounts = make(map[position]string)
+	var positions []position
+	for _, t := range tt {
+		var bond = securityBond(t.SecurityCode, srv.securityInfoDirectory)
+		if bond == nil || bond.Maturity.IsZero() || bond.Maturity.After(date) ||
+			t.ExecutionDate.After(bond.Maturity) {
+			continue
+		}
+		var key = position{t.SecurityCode, strings.ToLower(t.Account)}
+		if _, found := volumes[key]; !found {
+			positions = append(positions, key)
+			accounts[key] = t.Account
+		}
+		volumes[key] += t.Volume
+	}
+	var result []core.MyTrade
+	for _, key := range positions {
+		var volume = volumes[key]
+		if volume <= 0 {
+			continue
+		}
+		var bond = securityBond(key.securityCode, srv.securityInfoDirectory)
+		var currency = securityCurrency(key.securityCode, srv.securityInfoDirectory)
+		result = append(result, core.MyTrade{
+			SecurityCode:  key.securityCode,
+			DateTime:      bond.Maturity,
+			ExecutionDate: bond.Maturity,
+			Price:         curConv.Exchange(currency, rubCurrency, bond.Maturity, bond.FaceValue),
+			Volume:        -volume,
+			Account:       accounts[key],
+			Currency:      rubCurrency,
+			Note:          "погашение",
+		})
+	}
+	return result
+}
+
+// addCoupons купоны за год по графику выплат. Купоны ОФЗ и муниципальных облигаций,
+// выплаченные до 2021 года, не облагаются НДФЛ.
+func (srv *NdflReportService) addCoupons(report *NdflReport, tt []core.MyTrade,
+	curConv *currencyConverter) {
+	var start = time.Date(report.Year, 1, 1, 0, 0, 0, 0, time.UTC)
+	var finish = time.Date(report.Year, 12, 31, 0, 0, 0, 0, time.UTC)
+	var securityCodes = make(map[string]bool)
+	for _, t := range tt {
+		if securityCodes[t.SecurityCode] {
+			continue
+		}
+		securityCodes[t.SecurityCode] = true
+		var bond = securityBond(t.SecurityCode, srv.securityInfoDirectory)
+		if bond == nil {
+			continue
+		}
+		var currency = securityCurrency(t.SecurityCode, srv.securityInfoDirectory)
+		bondIncome(bond, tt, t.SecurityCode, start, finish, func(cf bondCashflow, volume int) {
+			if cf.Coupon == 0 || volume < 0 {
+				return
+			}
+			var coupon = NdflCoupon{
+				SecurityCode: t.SecurityCode,
+				Date:         cf.Date,
+				Volume:       volume,
+				Sum:          curConv.Exchange(currency, rubCurrency, cf.Date, cf.Coupon*float64(volume)),
+				Taxable:      !bond.Government || cf.Date.Year() >= 2021,
+			}
+			report.Coupons = append(report.Coupons, coupon)
+			report.CouponsTotal += coupon.Sum
+			if coupon.Taxable {
+				report.CouponsTaxable += coupon.Sum
+			}
+		})
+	}
+	sort.Slice(report.Coupons, func(i, j int) bool {
+		return report.Coupons[i].Date.Before(report.Coupons[j].Date)
+	})
+}
+
+func (srv *NdflReportService) buildPlannedTaxItems(tt []core.MyTrade,
+	candles core.HistoryCandleStorage, curConv *currencyConverter) []PlannedTaxReportItem {
 	type buyItem struct {
 		securityCode string
 		volume       int
@@ -148,8 +490,10 @@ func (srv *NdflReportService) buildPlannedTaxItems(tt []core.MyTrade) []PlannedT
 	}
 	var result []PlannedTaxReportItem
 	for k, v := range m {
-		var c, _ = srv.historyCandleStorage.Last(k)
-		var amount = c.C * float64(v.volume)
+		var c, _ = candles.Last(k)
+		var currency = securityCurrency(k, srv.securityInfoDirectory)
+		var bond = securityBond(k, srv.securityInfoDirectory)
+		var amount = curConv.ConvertFrom(currency, c.DateTime, positionAmount(bond, c.C, v.volume, c.DateTime))
 		result = append(result, PlannedTaxReportItem{
 			SecuirtyCode: k,
 			Volume:       v.volume,
@@ -162,8 +506,8 @@ func (srv *NdflReportService) buildPlannedTaxItems(tt []core.MyTrade) []PlannedT
 }
 
 func PrintPlannedTaxReport(report PlannedTaxReport) {
-	fmt.Printf("Отчет по 3-летней льготе '%v' на дату %v\n",
-		report.Account, report.Date.Format(dateLayout))
+	fmt.Printf("Отчет по 3-летней льготе '%v' на дату %v, лоты %v\n",
+		report.Account, report.Date.Format(dateLayout), report.Method)
 	printPlannedTaxItems(report.ItemsYear3)
 
 	fmt.Printf("Отчет о потенциальном НФДЛ '%v'\n", report.Account)
@@ -173,6 +517,7 @@ func PrintPlannedTaxReport(report PlannedTaxReport) {
 	fmt.Printf("Tax: %.f\n", report.PlannedTaxTotal)
 	fmt.Println("Незакрытые сделки")
 	printTrades(report.OpenTrades)
+	printLotAnomalies(report.Anomalies)
 }
 
 func printPlannedTaxItems(items []PlannedTaxReportItem) {
@@ -185,54 +530,8 @@ func printPlannedTaxItems(items []PlannedTaxReportItem) {
 	w.Flush()
 }
 
-func splitOpenAndClosedTrades(tt []core.MyTrade) ([]core.MyTrade, []ClosedMyTrade) {
-	//TODO sort tt by date
-	type info struct {
-		buyTrades  []core.MyTrade
-		sellTrades []core.MyTrade
-	}
-	var m = make(map[string]*info)
-	for _, t := range tt {
-		var item, found = m[t.SecurityCode]
-		if !found {
-			item = &info{}
-			m[t.SecurityCode] = item
-		}
-		if t.Volume > 0 {
-			item.buyTrades = append(item.buyTrades, t)
-		} else {
-			item.sellTrades = append(item.sellTrades, t)
-		}
-	}
-	var closedTrades []ClosedMyTrade
-	for _, v := range m {
-		for _, sellTrade := range v.sellTrades {
-			var sellVolume = -sellTrade.Volume
-			for len(v.buyTrades) > 0 && sellVolume > 0 {
-				var buyTrade = v.buyTrades[0]
-				var volume = minInt(buyTrade.Volume, sellVolume)
-				closedTrades = append(closedTrades, ClosedMyTrade{
-					SecurityCode: buyTrade.SecurityCode,
-					OpenDate:     buyTrade.ExecutionDate,
-					CloseDate:    sellTrade.ExecutionDate,
-					OpenPrice:    buyTrade.Price,
-					ClosePrice:   sellTrade.Price,
-					Volume:       volume,
-				})
-				sellVolume -= volume
-				if volume >= buyTrade.Volume {
-					v.buyTrades = v.buyTrades[1:]
-				} else {
-					v.buyTrades[0].Volume -= volume
-				}
-			}
-		}
-	}
-	var openTrades []core.MyTrade
-	for _, v := range m {
-		openTrades = append(openTrades, v.buyTrades...)
-	}
-	return openTrades, closedTrades
+func tradeComission(t core.MyTrade) float64 {
+	return t.ExchangeComission + t.BrokerComission
 }
 
 func filterClosedTrades(source []ClosedMyTrade, year int) []ClosedMyTrade {
@@ -248,41 +547,107 @@ func filterClosedTrades(source []ClosedMyTrade, year int) []ClosedMyTrade {
 func totalPnL(closedTrades []ClosedMyTrade) float64 {
 	var sum = 0.0
 	for _, t := range closedTrades {
-		sum += (t.ClosePrice - t.OpenPrice) * float64(t.Volume) //TODO finished trades comission!
+		sum += t.PnL()
 	}
 	return sum
 }
 
-func computePnLDeduction(closedTrades []ClosedMyTrade) float64 {
-	var sum = 0.0
+// ldvYearLimit предельный размер ЛДВ за каждый полный год владения.
+const ldvYearLimit = 3000000
+
+// isLdvEligible бумага куплена не ранее 2014 года и находилась в собственности более 3 лет.
+func isLdvEligible(t ClosedMyTrade) bool {
+	return !t.Short && t.OpenDate.Year() >= 2014 &&
+		t.OpenDate.AddDate(3, 0, 0).Before(t.CloseDate)
+}
+
+// fullYearsHeld количество полных лет владения.
+func fullYearsHeld(t ClosedMyTrade) int {
+	var years = 0
+	for !t.OpenDate.AddDate(years+1, 0, 0).After(t.CloseDate) {
+		years++
+	}
+	return years
+}
+
+// computeLdv льгота за длительное владение (ст. 219.1 НК РФ).
+// Предельный размер: 3 млн руб. * Кцб, Кцб = Σ(Дi * Кi) / ΣДi, где Дi - доход от реализации лота,
+// Кi - полные годы владения. Вычет не больше положительного финансового результата по льготным лотам
+// и распределяется по лотам с прибылью пропорционально прибыли (ClosedMyTrade.Ldv).
+// http://www.consultant.ru/document/cons_doc_LAW_28165/2b69106f66601ba5b58aaeb82395674581c66c20/#dst9545
+func computeLdv(closedTrades []ClosedMyTrade) (limit, used float64) {
+	var proceeds, weightedProceeds, pnl, profit float64
 	for _, t := range closedTrades {
-		if t.OpenDate.Year() >= 2014 &&
-			t.OpenDate.AddDate(3, 0, 0).Before(t.CloseDate) {
-			sum += (t.ClosePrice - t.OpenPrice) * float64(t.Volume)
+		if !isLdvEligible(t) {
+			continue
 		}
+		proceeds += t.Proceeds()
+		weightedProceeds += t.Proceeds() * float64(fullYearsHeld(t))
+		pnl += t.PnL()
+		profit += math.Max(0, t.PnL())
 	}
-	if sum <= 0 {
-		return 0
+	if proceeds <= 0 {
+		return 0, 0
 	}
-	//TODO Учесть макс размер вычета
-	// http://www.consultant.ru/document/cons_doc_LAW_28165/2b69106f66601ba5b58aaeb82395674581c66c20/#dst9545
-	return sum
-}
-
-func computeNdfl(pnl float64) float64 {
-	if pnl < 0 {
-		return 0
+	limit = math.Round(ldvYearLimit * weightedProceeds / proceeds)
+	if pnl <= 0 {
+		return limit, 0
+	}
+	used = math.Min(pnl, limit)
+	for i := range closedTrades {
+		if isLdvEligible(closedTrades[i]) && closedTrades[i].PnL() > 0 {
+			closedTrades[i].Ldv = used * closedTrades[i].PnL() / profit
+		}
 	}
-	return math.Round(pnl * 0.13)
+	return limit, used
 }
 
 func PrintNdflReport(report NdflReport) {
-	fmt.Printf("Отчет '%v' НДФЛ за %v год\n",
-		report.Account, report.Year)
+	fmt.Printf("Отчет '%v' НДФЛ за %v год, лоты %v\n",
+		report.Account, report.Year, report.Method)
+	if !report.Resident {
+		fmt.Println("Нерезидент")
+	}
+	fmt.Printf("Доходы за год по всем счетам для определения ставки: %.f\n", report.IncomeTotal)
+	fmt.Printf("Доходы от реализации: %.2f\n", report.Proceeds)
+	fmt.Printf("Расходы на приобретение: %.2f\n", report.Cost)
+	fmt.Printf("Комиссии: %.2f\n", report.Expenses)
 	fmt.Printf("Доход: %.f\n", report.PnLTotal)
 	fmt.Printf("НДФЛ: %.f\n", report.Ndfl)
+	fmt.Printf("ЛДВ: предельный размер %.f, использовано %.f, остаток %.f\n",
+		report.LdvCap, report.LdvUsed, report.LdvRemaining)
 	fmt.Printf("НДФЛ с 3 летней льготой: %.f\n", report.NdflWithDeduction)
 	printClosedTrades(report.Trades)
+	if len(report.Shorts) != 0 {
+		fmt.Println("Открытие коротких позиций")
+		printTrades(report.Shorts)
+	}
+	printLotAnomalies(report.Anomalies)
+	if len(report.Coupons) != 0 {
+		fmt.Printf("Купоны: %.f\n", report.CouponsTotal)
+		fmt.Printf("Купоны, облагаемые НДФЛ: %.f\n", report.CouponsTaxable)
+		fmt.Printf("НДФЛ с купонов: %.f\n", report.NdflCoupons)
+		printNdflCoupons(report.Coupons)
+	}
+	if report.Dividends != 0 {
+		fmt.Printf("Дивиденды: %.f\n", report.Dividends)
+		fmt.Printf("НДФЛ с дивидендов: %.f\n", report.NdflDividends)
+	}
+	if report.ForeignDividends != 0 {
+		fmt.Printf("Иностранные дивиденды: %.f\n", report.ForeignDividends)
+		fmt.Printf("Налог, удержанный за рубежом: %.f\n", report.ForeignDividendsTax)
+		fmt.Printf("НДФЛ с иностранных дивидендов к доплате: %.f\n", report.NdflForeignDividends)
+	}
+}
+
+func printNdflCoupons(items []NdflCoupon) {
+	var w = newTabWriter()
+	fmt.Fprintf(w, "Security\tDate\tVolume\tSum\tTaxable\t\n")
+	for _, item := range items {
+		fmt.Fprintf(w, "%v\t%v\t%v\t%.2f\t%v\t\n",
+			item.SecurityCode, item.Date.Format(dateLayout), item.Volume, item.Sum, item.Taxable)
+	}
+	w.Flush()
 }
 
 func printTrades(tt []core.MyTrade) {
@@ -297,11 +662,12 @@ func printTrades(tt []core.MyTrade) {
 
 func printClosedTrades(tt []ClosedMyTrade) {
 	var w = newTabWriter()
-	fmt.Fprintf(w, "Security\tOpenDate\tOpenPrice\tCloseDate\tClosePrice\tVolume\t\n")
+	fmt.Fprintf(w, "Security\tOpenDate\tOpenPrice\tCloseDate\tClosePrice\tVolume\tProceeds\tCost\tExpenses\tPnL\tLDV\t\n")
 	for _, t := range tt {
-		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\t\n",
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\t%.2f\t%.2f\t%.2f\t%.2f\t%v\t\n",
 			t.SecurityCode, t.OpenDate.Format(dateLayout), t.OpenPrice,
-			t.CloseDate.Format(dateLayout), t.ClosePrice, t.Volume)
+			t.CloseDate.Format(dateLayout), t.ClosePrice, t.Volume,
+			t.Proceeds(), t.Cost(), t.Expenses(), t.PnL(), formatZeroFloat64(math.Round(t.Ldv)))
 	}
 	w.Flush()
 }
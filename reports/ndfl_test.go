@@ -0,0 +1,126 @@
+package reports
+
+import (
+	"testing"
+
+	"github.com/ChizhovVadim/assets/core"
+)
+
+type testSecurityInfoDirectory []core.SecurityInfo
+
+func (d testSecurityInfoDirectory) Read(securityCode string) (core.SecurityInfo, bool) {
+	for _, info := range d {
+		if info.SecurityCode == securityCode {
+			return info, true
+		}
+	}
+	return core.SecurityInfo{}, false
+}
+
+func (d testSecurityInfoDirectory) FindByIsin(isin string) (core.SecurityInfo, bool) {
+	for _, info := range d {
+		if isin != "" && info.Isin == isin {
+			return info, true
+		}
+	}
+	return core.SecurityInfo{}, false
+}
+
+// Погашение по счету, записанному в сделках в разном регистре, - одна сделка на всю позицию.
+func TestRedemptionTradesAccountCase(t *testing.T) {
+	var srv = &NdflReportService{securityInfoDirectory: testSecurityInfoDirectory{
+		{SecurityCode: "OFZ26207", Bond: &core.BondInfo{FaceValue: 1000, Maturity: testDate(2023, 2, 1)}},
+	}}
+	var tt = []core.MyTrade{
+		{SecurityCode: "OFZ26207", ExecutionDate: testDate(2022, 3, 1), Volume: 5, Account: "IIS"},
+		{SecurityCode: "OFZ26207", ExecutionDate: testDate(2022, 4, 1), Volume: 3, Account: "iis"},
+	}
+	var result = srv.redemptionTrades(tt, newCurrencyConverter(testCandleStorage{}, ""), testDate(2023, 6, 1))
+	if len(result) != 1 {
+		t.Fatalf("got %v redemption trades, want 1: %+v", len(result), result)
+	}
+	if r := result[0]; r.Volume != -8 || r.Account != "IIS" || r.Price != 1000 ||
+		!r.ExecutionDate.Equal(testDate(2023, 2, 1)) {
+		t.Errorf("got %+v", r)
+	}
+}
+
+func TestComputeLdv(t *testing.T) {
+	// 3 полных года владения (льгота - владение более 3 лет)
+	var lot3 = func(volume int, openPrice, closePrice float64) ClosedMyTrade {
+		return ClosedMyTrade{OpenDate: testDate(2016, 1, 10), CloseDate: testDate(2019, 6, 1),
+			Volume: volume, OpenPrice: openPrice, ClosePrice: closePrice}
+	}
+	// 5 полных лет владения
+	var lot5 = func(volume int, openPrice, closePrice float64) ClosedMyTrade {
+		return ClosedMyTrade{OpenDate: testDate(2014, 3, 1), CloseDate: testDate(2019, 6, 1),
+			Volume: volume, OpenPrice: openPrice, ClosePrice: closePrice}
+	}
+	var tests = []struct {
+		name   string
+		trades []ClosedMyTrade
+		limit  float64
+		used   float64
+		ldv    []float64
+	}{
+		{
+			name:   "profit below limit",
+			trades: []ClosedMyTrade{lot5(1000, 100, 300)},
+			limit:  15000000,
+			used:   200000,
+			ldv:    []float64{200000},
+		},
+		{
+			// Кцб = (20 млн * 3 + 3 млн * 5) / 23 млн, предел 3 млн * Кцб = 9782609,
+			// вычет делится пропорционально прибыли 10 млн и 2 млн
+			name:   "limit binds",
+			trades: []ClosedMyTrade{lot3(100000, 100, 200), lot5(10000, 100, 300)},
+			limit:  9782609,
+			used:   9782609,
+			ldv:    []float64{9782609 * 10.0 / 12, 9782609 * 2.0 / 12},
+		},
+		{
+			// убыток по льготному лоту уменьшает вычет, не льготные лоты не учитываются
+			name: "loss and ineligible lots",
+			trades: []ClosedMyTrade{
+				lot3(10000, 100, 200),
+				lot3(1000, 500, 200),
+				{OpenDate: testDate(2018, 1, 10), CloseDate: testDate(2019, 6, 1), Volume: 1000, OpenPrice: 100, ClosePrice: 200},
+				{OpenDate: testDate(2013, 1, 10), CloseDate: testDate(2019, 6, 1), Volume: 1000, OpenPrice: 100, ClosePrice: 200},
+				{OpenDate: testDate(2015, 1, 10), CloseDate: testDate(2019, 6, 1), Volume: 1000, OpenPrice: 200, ClosePrice: 100, Short: true},
+			},
+			limit: 9000000,
+			used:  700000,
+			ldv:   []float64{700000, 0, 0, 0, 0},
+		},
+		{
+			name:   "commissions reduce result",
+			trades: []ClosedMyTrade{{OpenDate: testDate(2016, 1, 10), CloseDate: testDate(2019, 6, 1), Volume: 100, OpenPrice: 100, ClosePrice: 200, OpenComission: 50, CloseComission: 150}},
+			limit:  9000000,
+			used:   9800,
+			ldv:    []float64{9800},
+		},
+		{
+			name:   "total loss",
+			trades: []ClosedMyTrade{lot3(1000, 300, 200)},
+			limit:  9000000,
+			ldv:    []float64{0},
+		},
+		{
+			name:   "no eligible lots",
+			trades: []ClosedMyTrade{{OpenDate: testDate(2018, 1, 10), CloseDate: testDate(2019, 6, 1), Volume: 1000, OpenPrice: 100, ClosePrice: 200}},
+			ldv:    []float64{0},
+		},
+	}
+	for _, test := range tests {
+		var limit, used = computeLdv(test.trades)
+		if !sameAmount(limit, test.limit) || !sameAmount(used, test.used) {
+			t.Errorf("%v: got limit %v used %v, want %v %v", test.name, limit, used, test.limit, test.used)
+		}
+		for i, trade := range test.trades {
+			if !sameAmount(trade.Ldv, test.ldv[i]) {
+				t.Errorf("%v: lot %v got ldv %v, want %v", test.name, i, trade.Ldv, test.ldv[i])
+			}
+		}
+	}
+}
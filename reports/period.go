@@ -6,6 +6,7 @@ import (
 	"os"
 	"sort"
 	"strconv"
+	"strings"
 	"text/tabwriter"
 	"time"
 
@@ -13,58 +14,101 @@ import (
 )
 
 type PeriodReportService struct {
-	myTradeStorage        core.MyTradeStorage
-	historyCandleStorage  core.HistoryCandleStorage
-	securityInfoDirectory core.SecurityInfoDirectory
-	myDividendStorage     core.MyDividendStorage
+	myTradeStorage          core.MyTradeStorage
+	historyCandleStorage    core.HistoryCandleStorage
+	securityInfoDirectory   core.SecurityInfoDirectory
+	myDividendStorage       core.MyDividendStorage
+	cashMovementStorage     core.CashMovementStorage
+	corporateActionStorage  core.CorporateActionStorage
+	securityTransferStorage core.SecurityTransferStorage
 }
 
 func NewPeriodReportService(
 	myTradeStorage core.MyTradeStorage,
 	historyCandleStorage core.HistoryCandleStorage,
 	securityInfoDirectory core.SecurityInfoDirectory,
-	myDividendStorage core.MyDividendStorage) *PeriodReportService {
+	myDividendStorage core.MyDividendStorage,
+	cashMovementStorage core.CashMovementStorage,
+	corporateActionStorage core.CorporateActionStorage,
+	securityTransferStorage core.SecurityTransferStorage) *PeriodReportService {
 	return &PeriodReportService{
-		myTradeStorage:        myTradeStorage,
-		historyCandleStorage:  historyCandleStorage,
-		securityInfoDirectory: securityInfoDirectory,
-		myDividendStorage:     myDividendStorage,
+		myTradeStorage:          myTradeStorage,
+		historyCandleStorage:    historyCandleStorage,
+		securityInfoDirectory:   securityInfoDirectory,
+		myDividendStorage:       myDividendStorage,
+		cashMovementStorage:     cashMovementStorage,
+		corporateActionStorage:  corporateActionStorage,
+		securityTransferStorage: securityTransferStorage,
 	}
 }
 
 type PeriodReport struct {
-	Start        time.Time
-	Finish       time.Time
-	Account      string
-	Items        []PeriodItem
+	Start       time.Time
+	Finish      time.Time
+	Account     string
+	Currency    string
+	Items       []PeriodItem
+	AmountStart float64
+	AmountBuy   float64
+	AmountSell  float64
+	// стоимость бумаг, переведенных с других счетов и на другие счета, по рынку на дату перевода
+	AmountTransferIn  float64
+	AmountTransferOut float64
+	AmountChange      float64
+	AmountFinish      float64
+	Dividends         float64
+	Coupons           float64
+	Redemptions       float64
+	Comissions        float64
+	PnL               float64
+	Irr               float64
+	Benchmark         float64
+	Cash              *PeriodCash
+}
+
+// PeriodCash денежные средства на счете. Заполняется, если есть движения денежных средств.
+type PeriodCash struct {
 	AmountStart  float64
-	AmountBuy    float64
-	AmountSell   float64
-	AmountChange float64
 	AmountFinish float64
-	Dividends    float64
-	Comissions   float64
-	PnL          float64
-	Irr          float64
-	Benchmark    float64
+	Deposits     float64
+	Withdrawals  float64
+	Coupons      float64
+	Interest     float64
+	Fees         float64
+	Taxes        float64
+	Balances     []PeriodCashBalance
 }
 
-type PeriodItem struct {
-	SecurityCode string
-	Title        string
-	PriceStart   float64
-	PriceFinish  float64
-	VolumeStart  int
-	VolumeBuy    int
-	VolumeSell   int
-	VolumeFinish int
+// PeriodCashBalance остаток денежных средств в валюте счета.
+type PeriodCashBalance struct {
+	Currency     string
 	AmountStart  float64
-	AmountBuy    float64
-	AmountSell   float64
-	AmountChange float64
 	AmountFinish float64
-	Weight       float64
-	Comissions   float64
+}
+
+type PeriodItem struct {
+	SecurityCode      string
+	Title             string
+	Currency          string
+	PriceStart        float64
+	PriceFinish       float64
+	VolumeStart       int
+	VolumeBuy         int
+	VolumeSell        int
+	VolumeTransferIn  int
+	VolumeTransferOut int
+	VolumeFinish      int
+	AmountStart       float64
+	AmountBuy         float64
+	AmountSell        float64
+	AmountTransferIn  float64
+	AmountTransferOut float64
+	AmountChange      float64
+	AmountFinish      float64
+	Weight            float64
+	Comissions        float64
+	Coupons           float64
+	Redemptions       float64
 }
 
 type PeriodReportRequest struct {
@@ -80,12 +124,22 @@ func (srv *PeriodReportService) BuildPeriodReport(r PeriodReportRequest) (Period
 	if err != nil {
 		return PeriodReport{}, err
 	}
-	var curConv = &currencyConverter{
-		codeTo:               r.Currency,
-		historyCandleStorage: srv.historyCandleStorage,
+	actions, err := srv.corporateActionStorage.Read()
+	if err != nil {
+		return PeriodReport{}, err
 	}
+	var candles = newAdjustedCandleStorage(srv.historyCandleStorage, actions, r.Finish)
+	transfers, err := srv.securityTransferStorage.Read()
+	if err != nil {
+		return PeriodReport{}, err
+	}
+	tt = append(tt, securityTransferTrades(transfers, r.Account)...)
+	tt = applyCorporateActions(tt, actions, r.Finish, corporateActionRules{prices: candles})
+	var curConv = newCurrencyConverter(srv.historyCandleStorage, r.Currency)
 	var m = make(map[string]*PeriodItem)
 	var cashflows []DateSum
+	// переводы бумаг - внешние потоки, как ввод и вывод денег
+	var transferCashflows []DateSum
 	for _, t := range tt {
 		//TODO compare date parts only?
 		if t.ExecutionDate.After(r.Finish) {
@@ -99,17 +153,31 @@ func (srv *PeriodReportService) BuildPeriodReport(r PeriodReportRequest) (Period
 		if t.ExecutionDate.Before(r.Start) {
 			item.VolumeStart += t.Volume
 			item.VolumeFinish += t.Volume
+		} else if isSecurityTransferTrade(t) {
+			item.VolumeFinish += t.Volume
+			var amount = srv.transferAmount(t, candles, curConv)
+			if t.Volume > 0 {
+				item.VolumeTransferIn += t.Volume
+				item.AmountTransferIn += amount
+				transferCashflows = append(transferCashflows, DateSum{t.ExecutionDate, -amount})
+			} else {
+				item.VolumeTransferOut -= t.Volume
+				item.AmountTransferOut += amount
+				transferCashflows = append(transferCashflows, DateSum{t.ExecutionDate, amount})
+			}
 		} else {
+			var currency = tradeCurrency(t, srv.securityInfoDirectory)
+			var bond = securityBond(t.SecurityCode, srv.securityInfoDirectory)
 			item.VolumeFinish += t.Volume
-			item.Comissions += curConv.Convert(t.ExecutionDate, t.BrokerComission+t.ExchangeComission)
+			item.Comissions += curConv.ConvertFrom(currency, t.ExecutionDate, t.BrokerComission+t.ExchangeComission)
 			if t.Volume > 0 {
 				item.VolumeBuy += t.Volume
-				var amount = curConv.Convert(t.ExecutionDate, float64(t.Volume)*t.Price)
+				var amount = curConv.ConvertFrom(currency, t.ExecutionDate, tradeAmount(bond, t))
 				item.AmountBuy += amount
 				cashflows = append(cashflows, DateSum{t.ExecutionDate, -amount})
 			} else {
 				item.VolumeSell -= t.Volume
-				var amount = curConv.Convert(t.ExecutionDate, float64(-t.Volume)*t.Price)
+				var amount = curConv.ConvertFrom(currency, t.ExecutionDate, -tradeAmount(bond, t))
 				item.AmountSell += amount
 				cashflows = append(cashflows, DateSum{t.ExecutionDate, amount})
 			}
@@ -120,18 +188,34 @@ func (srv *PeriodReportService) BuildPeriodReport(r PeriodReportRequest) (Period
 		if v.VolumeStart != 0 ||
 			v.VolumeFinish != 0 ||
 			v.VolumeBuy != 0 ||
-			v.VolumeSell != 0 {
+			v.VolumeSell != 0 ||
+			v.VolumeTransferIn != 0 ||
+			v.VolumeTransferOut != 0 {
+			v.Currency = securityCurrency(v.SecurityCode, srv.securityInfoDirectory)
+			var bond = securityBond(v.SecurityCode, srv.securityInfoDirectory)
 			if v.VolumeStart != 0 {
-				c0, _ := srv.historyCandleStorage.CandleBeforeDate(v.SecurityCode, r.Start)
+				c0, _ := candles.CandleBeforeDate(v.SecurityCode, r.Start)
 				v.PriceStart = c0.C
-				v.AmountStart = curConv.Convert(r.Start, v.PriceStart*float64(v.VolumeStart))
+				v.AmountStart = curConv.ConvertFrom(v.Currency, r.Start,
+					positionAmount(bond, v.PriceStart, v.VolumeStart, r.Start))
 			}
 			if v.VolumeFinish != 0 {
-				c1, _ := srv.historyCandleStorage.CandleByDate(v.SecurityCode, r.Finish)
+				c1, _ := candles.CandleByDate(v.SecurityCode, r.Finish)
 				v.PriceFinish = c1.C
-				v.AmountFinish = curConv.Convert(r.Finish, v.PriceFinish*float64(v.VolumeFinish))
+				v.AmountFinish = curConv.ConvertFrom(v.Currency, r.Finish,
+					positionAmount(bond, v.PriceFinish, v.VolumeFinish, r.Finish))
 			}
-			v.AmountChange = v.AmountFinish - v.AmountStart - (v.AmountBuy - v.AmountSell)
+			if bond != nil {
+				bondIncome(bond, tt, v.SecurityCode, r.Start, r.Finish, func(cf bondCashflow, volume int) {
+					var coupon = curConv.ConvertFrom(v.Currency, cf.Date, cf.Coupon*float64(volume))
+					var redemption = curConv.ConvertFrom(v.Currency, cf.Date, cf.Principal*float64(volume))
+					v.Coupons += coupon
+					v.Redemptions += redemption
+					cashflows = append(cashflows, DateSum{cf.Date, coupon + redemption})
+				})
+			}
+			v.AmountChange = v.AmountFinish - v.AmountStart - (v.AmountBuy - v.AmountSell) -
+				(v.AmountTransferIn - v.AmountTransferOut)
 			v.Title = securityTitle(v.SecurityCode, srv.securityInfoDirectory)
 			items = append(items, *v)
 		}
@@ -143,21 +227,27 @@ func (srv *PeriodReportService) BuildPeriodReport(r PeriodReportRequest) (Period
 		return items[i].AmountFinish > items[j].AmountFinish
 	})
 	var result = PeriodReport{
-		Start:   r.Start,
-		Finish:  r.Finish,
-		Account: r.Account,
-		Items:   items,
+		Start:    r.Start,
+		Finish:   r.Finish,
+		Account:  r.Account,
+		Currency: curConv.codeTo,
+		Items:    items,
 	}
 	for _, item := range items {
 		result.AmountStart += item.AmountStart
 		result.AmountBuy += item.AmountBuy
 		result.AmountSell += item.AmountSell
+		result.AmountTransferIn += item.AmountTransferIn
+		result.AmountTransferOut += item.AmountTransferOut
 		result.AmountFinish += item.AmountFinish
 		result.Comissions += item.Comissions
+		result.Coupons += item.Coupons
+		result.Redemptions += item.Redemptions
 	}
 	for i := range items {
 		items[i].Weight = items[i].AmountFinish / result.AmountFinish
 	}
+	cashflows = append(cashflows, transferCashflows...)
 	cashflows = append(cashflows, DateSum{r.Start, -result.AmountStart})
 	cashflows = append(cashflows, DateSum{r.Finish, result.AmountFinish})
 	dd, err := srv.myDividendStorage.ReadReceivedDividends(r.Account, r.Start, r.Finish)
@@ -165,12 +255,39 @@ func (srv *PeriodReportService) BuildPeriodReport(r PeriodReportRequest) (Period
 		return PeriodReport{}, err
 	}
 	for _, d := range dd {
-		var dividend = curConv.Convert(d.Date, d.Sum)
+		var dividend = curConv.ConvertFrom(d.Currency, d.Date, d.Sum)
 		result.Dividends += dividend
 		cashflows = append(cashflows, DateSum{d.Date, dividend})
 	}
-	result.AmountChange = result.AmountFinish - result.AmountStart - (result.AmountBuy - result.AmountSell)
-	result.PnL = result.AmountChange + result.Dividends - result.Comissions
+	result.AmountChange = result.AmountFinish - result.AmountStart - (result.AmountBuy - result.AmountSell) -
+		(result.AmountTransferIn - result.AmountTransferOut)
+	result.PnL = result.AmountChange + result.Dividends + result.Coupons + result.Redemptions - result.Comissions
+	cms, err := srv.cashMovementStorage.Read(r.Account)
+	if err != nil {
+		return PeriodReport{}, err
+	}
+	if len(cms) != 0 {
+		// дивиденды из Dividends.xml, которых нет в движениях денежных средств, зачисляются на счет
+		allDividends, err := srv.myDividendStorage.ReadReceivedDividends(r.Account, time.Time{}, r.Finish)
+		if err != nil {
+			return PeriodReport{}, err
+		}
+		cms = mergeReceivedDividends(cms, allDividends)
+		// доходность считаем только по внешним потокам: вводы и выводы денег
+		var cash = srv.buildPeriodCash(tt, cms, r.Start, r.Finish, curConv)
+		result.Cash = &cash
+		var valueStart = result.AmountStart + cash.AmountStart
+		var valueFinish = result.AmountFinish + cash.AmountFinish
+		result.PnL = valueFinish - valueStart - (cash.Deposits - cash.Withdrawals) -
+			(result.AmountTransferIn - result.AmountTransferOut)
+		cashflows = externalCashflows(cms, r.Start, r.Finish, curConv)
+		cashflows = append(cashflows, transferCashflows...)
+		cashflows = append(cashflows, DateSum{r.Start, -valueStart})
+		cashflows = append(cashflows, DateSum{r.Finish, valueFinish})
+	}
+	if err = curConv.Err(); err != nil {
+		return PeriodReport{}, err
+	}
 	result.Irr = InternalRateOfReturn(cashflows)
 	if years := yearsBetween(r.Start, r.Finish); years < 1 {
 		result.Irr = math.Pow(result.Irr, years)
@@ -188,6 +305,131 @@ func (srv *PeriodReportService) BuildPeriodReport(r PeriodReportRequest) (Period
 	return result, nil
 }
 
+// transferAmount стоимость переведенных бумаг по рыночной цене на дату перевода.
+func (srv *PeriodReportService) transferAmount(t core.MyTrade,
+	candles core.HistoryCandleStorage, curConv *currencyConverter) float64 {
+	var c, _ = candles.CandleByDate(t.SecurityCode, t.ExecutionDate)
+	var bond = securityBond(t.SecurityCode, srv.securityInfoDirectory)
+	var currency = securityCurrency(t.SecurityCode, srv.securityInfoDirectory)
+	var volume = t.Volume
+	if volume < 0 {
+		volume = -volume
+	}
+	return curConv.ConvertFrom(currency, t.ExecutionDate,
+		positionAmount(bond, c.C, volume, t.ExecutionDate))
+}
+
+// mergeReceivedDividends добавляет полученные дивиденды как зачисления денег,
+// если зачисления дивиденда на эту дату и сумму по счету нет.
+func mergeReceivedDividends(cms []core.CashMovement, dd []core.ReceivedDividend) []core.CashMovement {
+	type dividendKey struct {
+		account, date, currency string
+		sum                     int64
+	}
+	var keyOf = func(account string, date time.Time, currency string, sum float64) dividendKey {
+		return dividendKey{strings.ToLower(account), date.Format(dateLayout),
+			currencyCode(currency), int64(math.Round(sum * 100))}
+	}
+	var existing = make(map[dividendKey]int)
+	for _, m := range cms {
+		if m.Kind == core.CashDividend {
+			existing[keyOf(m.Account, m.Date, m.Currency, m.Sum)]++
+		}
+	}
+	var result = cms
+	for _, d := range dd {
+		var key = keyOf(d.Account, d.Date, d.Currency, d.Sum)
+		if existing[key] > 0 {
+			existing[key]--
+			continue
+		}
+		result = append(result, core.CashMovement{
+			Account:  d.Account,
+			Date:     d.Date,
+			Kind:     core.CashDividend,
+			Sum:      d.Sum,
+			Currency: d.Currency,
+		})
+	}
+	return result
+}
+
+func isExternalCashMovement(kind core.CashMovementKind) bool {
+	return kind == core.CashDeposit || kind == core.CashWithdrawal || kind == core.CashTransfer
+}
+
+// buildPeriodCash остатки денежных средств считаются по каждой валюте отдельно
+// и переводятся в валюту отчета по курсу на начало и конец периода.
+func (srv *PeriodReportService) buildPeriodCash(tt []core.MyTrade, cms []core.CashMovement,
+	start, finish time.Time, curConv *currencyConverter) PeriodCash {
+	var balances = make(map[string]*PeriodCashBalance)
+	var addBalance = func(currency string, date time.Time, sum float64) {
+		var balance, found = balances[currency]
+		if !found {
+			balance = &PeriodCashBalance{Currency: currency}
+			balances[currency] = balance
+		}
+		if date.Before(start) {
+			balance.AmountStart += sum
+		}
+		if !date.After(finish) {
+			balance.AmountFinish += sum
+		}
+	}
+	var result PeriodCash
+	for _, t := range tt {
+		var bond = securityBond(t.SecurityCode, srv.securityInfoDirectory)
+		var sum = -(tradeAmount(bond, t) + t.ExchangeComission + t.BrokerComission)
+		addBalance(tradeCurrency(t, srv.securityInfoDirectory), t.ExecutionDate, sum)
+	}
+	for _, m := range cms {
+		var currency = currencyCode(m.Currency)
+		addBalance(currency, m.Date, m.Sum)
+		if m.Date.Before(start) || m.Date.After(finish) {
+			continue
+		}
+		var sum = curConv.ConvertFrom(currency, m.Date, m.Sum)
+		switch {
+		case isExternalCashMovement(m.Kind) && sum >= 0:
+			result.Deposits += sum
+		case isExternalCashMovement(m.Kind):
+			result.Withdrawals -= sum
+		case m.Kind == core.CashCoupon:
+			result.Coupons += sum
+		case m.Kind == core.CashInterest:
+			result.Interest += sum
+		case m.Kind == core.CashFee:
+			result.Fees -= sum
+		case m.Kind == core.CashTax:
+			result.Taxes -= sum
+		}
+	}
+	for _, balance := range balances {
+		result.AmountStart += curConv.ConvertFrom(balance.Currency, start, balance.AmountStart)
+		result.AmountFinish += curConv.ConvertFrom(balance.Currency, finish, balance.AmountFinish)
+		result.Balances = append(result.Balances, *balance)
+	}
+	sort.Slice(result.Balances, func(i, j int) bool {
+		return result.Balances[i].Currency < result.Balances[j].Currency
+	})
+	return result
+}
+
+// externalCashflows потоки для расчета доходности с точки зрения инвестора:
+// ввод денег - отрицательный поток, вывод - положительный.
+func externalCashflows(cms []core.CashMovement, start, finish time.Time,
+	curConv *currencyConverter) []DateSum {
+	var result []DateSum
+	for _, m := range cms {
+		if !isExternalCashMovement(m.Kind) ||
+			m.Date.Before(start) || m.Date.After(finish) {
+			continue
+		}
+		result = append(result, DateSum{m.Date, -curConv.ConvertFrom(m.Currency, m.Date, m.Sum)})
+	}
+	return result
+}
+
 func (srv *PeriodReportService) calculateBenchmark(start, finish time.Time) float64 {
 	const ticker = "MICEXINDEXCF"
 	q0, _ := srv.historyCandleStorage.CandleBeforeDate(ticker, start)
@@ -211,28 +453,52 @@ func securityTitle(securityCode string,
 const dateLayout = "2006-01-02"
 
 func PrintPeriodReport(report PeriodReport) {
-	fmt.Printf("Отчет '%v' с %v по %v\n",
+	fmt.Printf("Отчет '%v' с %v по %v в %v\n",
 		report.Account,
 		report.Start.Format(dateLayout),
-		report.Finish.Format(dateLayout))
+		report.Finish.Format(dateLayout),
+		report.Currency)
 
 	fmt.Printf("Стоимость активов на начало периода: %.f\n", report.AmountStart)
 	fmt.Printf("Сумма зачисления: %.f\n", report.AmountBuy)
 	fmt.Printf("Сумма списания: %.f\n", report.AmountSell)
+	if report.AmountTransferIn != 0 || report.AmountTransferOut != 0 {
+		fmt.Printf("Перевод бумаг с других счетов: %.f\n", report.AmountTransferIn)
+		fmt.Printf("Перевод бумаг на другие счета: %.f\n", report.AmountTransferOut)
+	}
 	fmt.Printf("Изменение стоимости: %.f\n", report.AmountChange)
 	fmt.Printf("Стоимость активов на конец периода: %.f\n", report.AmountFinish)
 	fmt.Printf("Дивиденды: %.f\n", report.Dividends)
+	if report.Coupons != 0 || report.Redemptions != 0 {
+		fmt.Printf("Купоны по графику: %.f\n", report.Coupons)
+		fmt.Printf("Погашение номинала по графику: %.f\n", report.Redemptions)
+	}
 	fmt.Printf("Комиссия: %.f\n", report.Comissions)
+	if cash := report.Cash; cash != nil {
+		fmt.Printf("Денежные средства на начало периода: %.f\n", cash.AmountStart)
+		fmt.Printf("Ввод денежных средств: %.f\n", cash.Deposits)
+		fmt.Printf("Вывод денежных средств: %.f\n", cash.Withdrawals)
+		fmt.Printf("Купоны: %.f\n", cash.Coupons)
+		fmt.Printf("Проценты: %.f\n", cash.Interest)
+		fmt.Printf("Прочие комиссии: %.f\n", cash.Fees)
+		fmt.Printf("Налоги: %.f\n", cash.Taxes)
+		fmt.Printf("Денежные средства на конец периода: %.f\n", cash.AmountFinish)
+		for _, balance := range cash.Balances {
+			fmt.Printf("Остаток %v: %.2f -> %.2f\n",
+				balance.Currency, balance.AmountStart, balance.AmountFinish)
+		}
+	}
 	fmt.Printf("Доход: %.f\n", report.PnL)
 	fmt.Printf("Доходность: %.1f%%\n", (report.Irr-1)*100)
 	fmt.Printf("Доходность индекса: %.1f%%\n", (report.Benchmark-1)*100)
 
 	var w = newTabWriter()
-	fmt.Fprintf(w, "Security\tW1\tP1\tV0\tV+\tV-\tV1\tT1\t\n")
+	fmt.Fprintf(w, "Security\tW1\tP1\tCur\tV0\tV+\tV-\tVT+\tVT-\tV1\tT1\t\n")
 	for _, item := range report.Items {
-		fmt.Fprintf(w, "%v\t%.1f\t%v\t%v\t%v\t%v\t%v\t%.f\t\n",
-			item.Title, item.Weight*100, item.PriceFinish,
-			formatZeroInt(item.VolumeStart), formatZeroInt(item.VolumeBuy), formatZeroInt(item.VolumeSell), formatZeroInt(item.VolumeFinish),
+		fmt.Fprintf(w, "%v\t%.1f\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%.f\t\n",
+			item.Title, item.Weight*100, item.PriceFinish, item.Currency,
+			formatZeroInt(item.VolumeStart), formatZeroInt(item.VolumeBuy), formatZeroInt(item.VolumeSell),
+			formatZeroInt(item.VolumeTransferIn), formatZeroInt(item.VolumeTransferOut), formatZeroInt(item.VolumeFinish),
 			item.AmountFinish)
 	}
 	w.Flush()
@@ -254,6 +520,11 @@ func (srv *PeriodReportService) GetHoldingTickers() ([]string, error) {
 	if err != nil {
 		return nil, err
 	}
+	actions, err := srv.corporateActionStorage.Read()
+	if err != nil {
+		return nil, err
+	}
+	tt = applyCorporateActions(tt, actions, time.Now(), corporateActionRules{})
 	var m = make(map[string]int)
 	for _, t := range tt {
 		m[t.SecurityCode] += t.Volume
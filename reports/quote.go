@@ -10,16 +10,19 @@ import (
 )
 
 type QuoteReportService struct {
-	historyCandleStorage  core.HistoryCandleStorage
-	securityInfoDirectory core.SecurityInfoDirectory
+	historyCandleStorage   core.HistoryCandleStorage
+	securityInfoDirectory  core.SecurityInfoDirectory
+	corporateActionStorage core.CorporateActionStorage
 }
 
 func NewQuoteReportService(
 	historyCandleStorage core.HistoryCandleStorage,
-	securityInfoDirectory core.SecurityInfoDirectory) *QuoteReportService {
+	securityInfoDirectory core.SecurityInfoDirectory,
+	corporateActionStorage core.CorporateActionStorage) *QuoteReportService {
 	return &QuoteReportService{
-		historyCandleStorage:  historyCandleStorage,
-		securityInfoDirectory: securityInfoDirectory,
+		historyCandleStorage:   historyCandleStorage,
+		securityInfoDirectory:  securityInfoDirectory,
+		corporateActionStorage: corporateActionStorage,
 	}
 }
 
@@ -39,34 +42,43 @@ type QuoteReport struct {
 type QuoteItem struct {
 	SecurityCode string
 	Title        string
+	Currency     string
 	PriceStart   float64
 	PriceFinish  float64
 	Change       float64
 }
 
 func (srv *QuoteReportService) BuildQuoteReport(r QuoteReportRequest) (QuoteReport, error) {
-	var curConv = &currencyConverter{
-		codeTo:               r.Currency,
-		historyCandleStorage: srv.historyCandleStorage,
+	actions, err := srv.corporateActionStorage.Read()
+	if err != nil {
+		return QuoteReport{}, err
 	}
+	var candles = newAdjustedCandleStorage(srv.historyCandleStorage, actions, r.Finish)
+	var curConv = newCurrencyConverter(srv.historyCandleStorage, r.Currency)
 	var years = yearsBetween(r.Start, r.Finish)
 	var items []QuoteItem
 	for _, securityCode := range r.SecurityCodes {
-		priceStart, _ := srv.historyCandleStorage.CandleBeforeDate(securityCode, r.Start)
-		priceFinish, _ := srv.historyCandleStorage.CandleByDate(securityCode, r.Finish)
+		priceStart, _ := candles.CandleBeforeDate(securityCode, r.Start)
+		priceFinish, _ := candles.CandleByDate(securityCode, r.Finish)
 		title := securityTitle(securityCode, srv.securityInfoDirectory)
-		change := curConv.Convert(r.Finish, priceFinish.C) / curConv.Convert(r.Start, priceStart.C)
+		currency := securityCurrency(securityCode, srv.securityInfoDirectory)
+		change := curConv.ConvertFrom(currency, r.Finish, priceFinish.C) /
+			curConv.ConvertFrom(currency, r.Start, priceStart.C)
 		if years > 1 {
 			change = math.Pow(change, 1.0/years)
 		}
 		items = append(items, QuoteItem{
 			SecurityCode: securityCode,
 			Title:        title,
+			Currency:     currency,
 			PriceStart:   priceStart.C,
 			PriceFinish:  priceFinish.C,
 			Change:       change,
 		})
 	}
+	if err = curConv.Err(); err != nil {
+		return QuoteReport{}, err
+	}
 	sort.Slice(items, func(i, j int) bool {
 		return items[i].Change > items[j].Change
 	})
@@ -84,10 +96,10 @@ func PrintQuoteReport(report QuoteReport) {
 		report.Finish.Format(dateLayout))
 
 	var w = newTabWriter()
-	fmt.Fprintf(w, "Security\tPrice\tChange\t\n")
+	fmt.Fprintf(w, "Security\tPrice\tCur\tChange\t\n")
 	for _, item := range report.Items {
-		fmt.Fprintf(w, "%v\t%v\t%.1f\t\n",
-			item.Title, item.PriceFinish, (item.Change-1)*100)
+		fmt.Fprintf(w, "%v\t%v\t%v\t%.1f\t\n",
+			item.Title, item.PriceFinish, item.Currency, (item.Change-1)*100)
 	}
 	w.Flush()
 }
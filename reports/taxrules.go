@@ -0,0 +1,146 @@
+package reports
+
+import "math"
+
+// IncomeCategory вид дохода для расчета НДФЛ.
+type IncomeCategory string
+
+const (
+	IncomeTrades           IncomeCategory = "trades"
+	IncomeDividends        IncomeCategory = "dividends"
+	IncomeCoupons          IncomeCategory = "coupons"
+	IncomeForeignDividends IncomeCategory = "foreigndividends"
+)
+
+var incomeCategories = []IncomeCategory{
+	IncomeTrades,
+	IncomeDividends,
+	IncomeCoupons,
+	IncomeForeignDividends,
+}
+
+// taxBracket ставка для части дохода свыше threshold.
+type taxBracket struct {
+	threshold float64
+	rate      float64
+}
+
+// taxRule ставки для видов дохода, действующие с года fromYear до следующего правила.
+type taxRule struct {
+	fromYear   int
+	resident   bool
+	categories []IncomeCategory
+	brackets   []taxBracket
+}
+
+var (
+	flatRate9  = []taxBracket{{0, 0.09}}
+	flatRate13 = []taxBracket{{0, 0.13}}
+	flatRate15 = []taxBracket{{0, 0.15}}
+	flatRate30 = []taxBracket{{0, 0.30}}
+	noTax      = []taxBracket{{0, 0}}
+)
+
+// taxRules ставки НДФЛ по годам. Для нерезидентов иностранные дивиденды не облагаются в РФ.
+var taxRules = []taxRule{
+	{0, true, []IncomeCategory{IncomeTrades, IncomeCoupons, IncomeForeignDividends}, flatRate13},
+	{0, true, []IncomeCategory{IncomeDividends}, flatRate9},
+	{2015, true, []IncomeCategory{IncomeDividends}, flatRate13},
+	// с 2021 года доходы свыше 5 млн руб. за год облагаются по ставке 15%
+	{2021, true, incomeCategories, []taxBracket{{0, 0.13}, {5000000, 0.15}}},
+	// с 2025 года для инвестиционных доходов порог 2,4 млн руб.
+	{2025, true, incomeCategories, []taxBracket{{0, 0.13}, {2400000, 0.15}}},
+
+	{0, false, []IncomeCategory{IncomeTrades, IncomeCoupons}, flatRate30},
+	{0, false, []IncomeCategory{IncomeDividends}, flatRate15},
+	{0, false, []IncomeCategory{IncomeForeignDividends}, noTax},
+}
+
+// taxBrackets шкала ставок для вида дохода в году year.
+func taxBrackets(year int, resident bool, category IncomeCategory) []taxBracket {
+	var result []taxBracket
+	var fromYear = -1
+	for _, rule := range taxRules {
+		if rule.resident != resident || rule.fromYear > year || rule.fromYear < fromYear {
+			continue
+		}
+		for _, c := range rule.categories {
+			if c == category {
+				result, fromYear = rule.brackets, rule.fromYear
+			}
+		}
+	}
+	return result
+}
+
+// taxRate ставка для первой части дохода: удержание у источника, ожидаемые дивиденды.
+func taxRate(year int, resident bool, category IncomeCategory) float64 {
+	var brackets = taxBrackets(year, resident, category)
+	if len(brackets) == 0 {
+		return 0
+	}
+	return brackets[0].rate
+}
+
+func bracketsTax(brackets []taxBracket, income float64) float64 {
+	var result = 0.0
+	for i, b := range brackets {
+		if income <= b.threshold {
+			break
+		}
+		var upper = income
+		if i+1 < len(brackets) {
+			upper = math.Min(income, brackets[i+1].threshold)
+		}
+		result += (upper - b.threshold) * b.rate
+	}
+	return result
+}
+
+// computeIncomeTax налог за год по видам дохода. Для выбора ставки доходы всех видов (и всех счетов)
+// складываются, налог по виду дохода - его доля в налоге со всей суммы по шкале этого вида.
+// Отрицательный доход (убыток) налог не уменьшает.
+func computeIncomeTax(year int, resident bool,
+	incomes map[IncomeCategory]float64) map[IncomeCategory]float64 {
+	var total = 0.0
+	for _, income := range incomes {
+		total += math.Max(0, income)
+	}
+	var result = make(map[IncomeCategory]float64)
+	for category, income := range incomes {
+		if income <= 0 {
+			continue
+		}
+		result[category] = bracketsTax(taxBrackets(year, resident, category), total) * income / total
+	}
+	return result
+}
+
+// plannedIncomeTax на сколько увеличится налог за год, если к полученным за год доходам incomes
+// по всем счетам добавится доход от сделок pnl. Убыток уменьшает налог с прибыли по сделкам.
+func plannedIncomeTax(year int, resident bool, incomes map[IncomeCategory]float64, pnl float64) float64 {
+	var planned = make(map[IncomeCategory]float64)
+	for category, income := range incomes {
+		planned[category] = income
+	}
+	planned[IncomeTrades] += pnl
+	return math.Round(totalIncomeTax(computeIncomeTax(year, resident, planned)) -
+		totalIncomeTax(computeIncomeTax(year, resident, incomes)))
+}
+
+func totalIncomeTax(taxes map[IncomeCategory]float64) float64 {
+	var result = 0.0
+	for _, tax := range taxes {
+		result += tax
+	}
+	return result
+}
+
+// accountIncomeTax налог по счету: доля счета в налоге по виду дохода за год по всем счетам.
+func accountIncomeTax(taxes, incomesTotal map[IncomeCategory]float64,
+	category IncomeCategory, income float64) float64 {
+	if income <= 0 || incomesTotal[category] <= 0 {
+		return 0
+	}
+	return math.Round(taxes[category] * math.Min(1, income/incomesTotal[category]))
+}
@@ -0,0 +1,97 @@
+package reports
+
+import "testing"
+
+func TestBracketsTax(t *testing.T) {
+	var brackets = []taxBracket{{0, 0.13}, {5000000, 0.15}}
+	var tests = []struct {
+		income, tax float64
+	}{
+		{0, 0},
+		{-100000, 0},
+		{1000000, 130000},
+		{5000000, 650000},
+		{7000000, 950000},
+	}
+	for _, test := range tests {
+		if tax := bracketsTax(brackets, test.income); !sameAmount(tax, test.tax) {
+			t.Errorf("bracketsTax(%v) = %v, want %v", test.income, tax, test.tax)
+		}
+	}
+}
+
+func TestComputeIncomeTax(t *testing.T) {
+	var tests = []struct {
+		name     string
+		year     int
+		resident bool
+		incomes  map[IncomeCategory]float64
+		taxes    map[IncomeCategory]float64
+	}{
+		{"2020 flat 13%", 2020, true,
+			map[IncomeCategory]float64{IncomeTrades: 6000000},
+			map[IncomeCategory]float64{IncomeTrades: 780000}},
+		{"2021 at 5M", 2021, true,
+			map[IncomeCategory]float64{IncomeTrades: 5000000},
+			map[IncomeCategory]float64{IncomeTrades: 650000}},
+		{"2021 above 5M", 2021, true,
+			map[IncomeCategory]float64{IncomeTrades: 6000000},
+			map[IncomeCategory]float64{IncomeTrades: 800000}},
+		// 800000 со всей суммы 6 млн делится пропорционально доходам
+		{"2021 incomes summed for rate", 2021, true,
+			map[IncomeCategory]float64{IncomeTrades: 4000000, IncomeDividends: 2000000},
+			map[IncomeCategory]float64{IncomeTrades: 533333.3333333, IncomeDividends: 266666.6666667}},
+		{"2021 loss ignored", 2021, true,
+			map[IncomeCategory]float64{IncomeTrades: -1000000, IncomeDividends: 5500000},
+			map[IncomeCategory]float64{IncomeDividends: 725000}},
+		{"2024 at 2.5M", 2024, true,
+			map[IncomeCategory]float64{IncomeTrades: 2500000},
+			map[IncomeCategory]float64{IncomeTrades: 325000}},
+		{"2025 at 2.4M", 2025, true,
+			map[IncomeCategory]float64{IncomeTrades: 2400000},
+			map[IncomeCategory]float64{IncomeTrades: 312000}},
+		{"2025 above 2.4M", 2025, true,
+			map[IncomeCategory]float64{IncomeTrades: 2500000},
+			map[IncomeCategory]float64{IncomeTrades: 327000}},
+		{"non-resident", 2021, false,
+			map[IncomeCategory]float64{IncomeTrades: 1000000, IncomeDividends: 100000, IncomeForeignDividends: 50000},
+			map[IncomeCategory]float64{IncomeTrades: 300000, IncomeDividends: 15000, IncomeForeignDividends: 0}},
+	}
+	for _, test := range tests {
+		var taxes = computeIncomeTax(test.year, test.resident, test.incomes)
+		if len(taxes) != len(test.taxes) {
+			t.Errorf("%v: got %v, want %v", test.name, taxes, test.taxes)
+			continue
+		}
+		for category, tax := range test.taxes {
+			if !sameAmount(taxes[category], tax) {
+				t.Errorf("%v: %v got %v, want %v", test.name, category, taxes[category], tax)
+			}
+		}
+	}
+}
+
+func TestPlannedIncomeTax(t *testing.T) {
+	var tests = []struct {
+		name     string
+		year     int
+		incomes  map[IncomeCategory]float64
+		pnl, tax float64
+	}{
+		{"no realized income", 2021, nil, 200000, 26000},
+		// 100000 по 13% до порога 5 млн, 100000 по 15% сверх него
+		{"crosses 5M with realized trades", 2021,
+			map[IncomeCategory]float64{IncomeTrades: 4900000}, 200000, 28000},
+		{"above 2.4M with realized dividends", 2025,
+			map[IncomeCategory]float64{IncomeDividends: 2400000}, 100000, 15000},
+		{"loss reduces realized trades tax", 2021,
+			map[IncomeCategory]float64{IncomeTrades: 1000000}, -200000, -26000},
+		{"loss does not reduce dividends tax", 2021,
+			map[IncomeCategory]float64{IncomeDividends: 1000000}, -200000, 0},
+	}
+	for _, test := range tests {
+		if tax := plannedIncomeTax(test.year, true, test.incomes, test.pnl); !sameAmount(tax, test.tax) {
+			t.Errorf("%v: got %v, want %v", test.name, tax, test.tax)
+		}
+	}
+}
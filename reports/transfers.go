@@ -0,0 +1,166 @@
+package reports
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ChizhovVadim/assets/core"
+)
+
+// securityTransferNote примечание сделок, которыми перевод бумаг отражается на счетах.
+const securityTransferNote = "перевод"
+
+func isSecurityTransferTrade(t core.MyTrade) bool {
+	return t.Note == securityTransferNote
+}
+
+// securityTransferTrades перевод бумаг как сделки по нулевой цене на дату перевода:
+// списание со счета FromAccount и зачисление на счет ToAccount. Нужны для расчета позиций по счету.
+// Для всех счетов (account == "") перевод не меняет позиций.
+func securityTransferTrades(transfers []core.SecurityTransfer, account string) []core.MyTrade {
+	if account == "" {
+		return nil
+	}
+	var result []core.MyTrade
+	for _, transfer := range transfers {
+		if strings.EqualFold(transfer.FromAccount, account) {
+			result = append(result, securityTransferTrade(transfer, transfer.FromAccount, -transfer.Volume))
+		}
+		if strings.EqualFold(transfer.ToAccount, account) {
+			result = append(result, securityTransferTrade(transfer, transfer.ToAccount, transfer.Volume))
+		}
+	}
+	return result
+}
+
+func securityTransferTrade(transfer core.SecurityTransfer, account string, volume int) core.MyTrade {
+	return core.MyTrade{
+		SecurityCode:  transfer.SecurityCode,
+		DateTime:      transfer.Date,
+		ExecutionDate: transfer.Date,
+		Volume:        volume,
+		Account:       account,
+		Note:          securityTransferNote,
+	}
+}
+
+// applyTransfersAndCorporateActions налоговый учет переводов: открытые лоты переходят на счет ToAccount
+// с исходными датами, ценами и комиссиями. Переводы и корпоративные действия применяются
+// в порядке дат, поэтому количество в переводе указывается в бумагах на дату перевода.
+// Переводы, для которых не хватило открытых лотов, возвращаются как замечания.
+func applyTransfersAndCorporateActions(tt []core.MyTrade, actions []core.CorporateAction,
+	transfers []core.SecurityTransfer, until time.Time,
+	rules corporateActionRules) ([]core.MyTrade, []LotAnomaly) {
+	var result = make([]core.MyTrade, len(tt))
+	copy(result, tt)
+	var anomalies []LotAnomaly
+	var next = 0
+	for _, transfer := range transfers {
+		if transfer.Date.After(until) {
+			continue
+		}
+		for ; next < len(actions) && !actions[next].Date.After(transfer.Date); next++ {
+			result = applyCorporateAction(result, actions[next], rules)
+		}
+		var anomaly *LotAnomaly
+		result, anomaly = moveTransferredLots(result, transfer, rules.method)
+		if anomaly != nil {
+			anomalies = append(anomalies, *anomaly)
+		}
+	}
+	for ; next < len(actions); next++ {
+		if !actions[next].Date.After(until) {
+			result = applyCorporateAction(result, actions[next], rules)
+		}
+	}
+	return removeEmptyTrades(result), anomalies
+}
+
+// moveTransferredLots переносит лоты, открытые на дату перевода при способе method.
+// Лоты переносятся от старых к новым, при средней цене - пропорционально из каждого лота,
+// чтобы средняя цена на обоих счетах не изменилась. Частично переведенный лот делится на две сделки, комиссии делятся пропорционально,
+// перенесенная часть получает свой TradeId, чтобы выбор лотов не находил обе части.
+// Если открытых лотов меньше, чем в переводе, остаток не переносится и возвращается замечание:
+// обычно это пропущенная покупка или ошибка в файле переводов.
+func moveTransferredLots(tt []core.MyTrade, transfer core.SecurityTransfer,
+	method CostBasisMethod) ([]core.MyTrade, *LotAnomaly) {
+	var indexes []int
+	for i, t := range tt {
+		if t.SecurityCode == transfer.SecurityCode && t.Volume != 0 &&
+			strings.EqualFold(t.Account, transfer.FromAccount) &&
+			!t.ExecutionDate.After(transfer.Date) {
+			indexes = append(indexes, i)
+		}
+	}
+	var lots, sources = openLots(tt, indexes, method)
+	var volumes, rest = transferVolumes(lots, transfer.Volume, method)
+	for i, lot := range lots {
+		if volumes[i] == 0 {
+			continue
+		}
+		var moved = takeLot(tt, sources[i], lot, volumes[i])
+		moved.Account = transfer.ToAccount
+		if tt[sources[i]].Volume != 0 {
+			moved.TradeId = transferredTradeId(moved, transfer)
+		}
+		tt = append(tt, moved)
+	}
+	if rest > 0 {
+		return tt, &LotAnomaly{
+			Trade:   securityTransferTrade(transfer, transfer.FromAccount, -transfer.Volume),
+			Message: fmt.Sprintf("перевод на счет %v: нет открытых лотов на %v", transfer.ToAccount, rest),
+		}
+	}
+	return tt, nil
+}
+
+// transferVolumes количество бумаг, переносимое из каждого длинного лота, и непокрытый лотами остаток.
+func transferVolumes(lots []core.MyTrade, volume int, method CostBasisMethod) ([]int, int) {
+	var volumes = make([]int, len(lots))
+	var total = 0
+	for _, lot := range lots {
+		if lot.Volume > 0 {
+			total += lot.Volume
+		}
+	}
+	var rest = volume
+	if method == CostBasisAverage && total > volume {
+		for i, lot := range lots {
+			if lot.Volume > 0 {
+				volumes[i] = lot.Volume * volume / total
+				rest -= volumes[i]
+			}
+		}
+	}
+	// остаток от округления и перевод без средней цены - по порядку открытия
+	for i, lot := range lots {
+		if rest == 0 {
+			break
+		}
+		if lot.Volume > volumes[i] {
+			var v = minInt(lot.Volume-volumes[i], rest)
+			volumes[i] += v
+			rest -= v
+		}
+	}
+	return volumes, rest
+}
+
+// transferredTradeId TradeId перенесенной части лота: исходный TradeId и дата перевода.
+// Лот без TradeId выбирается по дате исполнения, она у частей общая.
+func transferredTradeId(t core.MyTrade, transfer core.SecurityTransfer) string {
+	if t.TradeId == "" {
+		return ""
+	}
+	return t.TradeId + "/" + transfer.Date.Format(dateLayout)
+}
+
+func filterAccountTrades(tt []core.MyTrade, account string) []core.MyTrade {
+	if account == "" {
+		return tt
+	}
+	return filterTrades(tt, func(t core.MyTrade) bool {
+		return strings.EqualFold(t.Account, account)
+	})
+}
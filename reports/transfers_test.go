@@ -0,0 +1,90 @@
+package reports
+
+import (
+	"testing"
+
+	"github.com/ChizhovVadim/assets/core"
+)
+
+func TestApplyTransfersCostBasisMethod(t *testing.T) {
+	var transfer = core.SecurityTransfer{Date: testDate(2022, 9, 1), SecurityCode: "SBER",
+		FromAccount: "broker", ToAccount: "iis"}
+	var tests = []struct {
+		name   string
+		method CostBasisMethod
+		volume int
+		trades []core.MyTrade
+		closed []ClosedMyTrade
+	}{
+		{
+			name:   "specific lot sold before transfer",
+			method: CostBasisSpecific,
+			volume: 5,
+			trades: []core.MyTrade{
+				{SecurityCode: "SBER", ExecutionDate: testDate(2022, 1, 10), Price: 100, Volume: 5, Account: "broker", TradeId: "1"},
+				{SecurityCode: "SBER", ExecutionDate: testDate(2022, 2, 10), Price: 200, Volume: 5, Account: "broker", TradeId: "2"},
+				{SecurityCode: "SBER", ExecutionDate: testDate(2022, 6, 1), Price: 250, Volume: -5, Account: "broker",
+					Lots: []core.LotSelection{{Lot: "2", Volume: 5}}},
+				{SecurityCode: "SBER", ExecutionDate: testDate(2023, 3, 1), Price: 300, Volume: -5, Account: "iis"},
+			},
+			closed: []ClosedMyTrade{
+				{SecurityCode: "SBER", Account: "broker", Volume: 5, OpenPrice: 200, ClosePrice: 250},
+				{SecurityCode: "SBER", Account: "iis", Volume: 5, OpenPrice: 100, ClosePrice: 300},
+			},
+		},
+		{
+			name:   "average price kept on both accounts",
+			method: CostBasisAverage,
+			volume: 10,
+			trades: []core.MyTrade{
+				{SecurityCode: "SBER", ExecutionDate: testDate(2022, 1, 10), Price: 100, Volume: 10, Account: "broker", BrokerComission: 20},
+				{SecurityCode: "SBER", ExecutionDate: testDate(2022, 2, 10), Price: 200, Volume: 10, Account: "broker"},
+				{SecurityCode: "SBER", ExecutionDate: testDate(2023, 3, 1), Price: 180, Volume: -10, Account: "broker"},
+				{SecurityCode: "SBER", ExecutionDate: testDate(2023, 4, 1), Price: 180, Volume: -10, Account: "iis"},
+			},
+			closed: []ClosedMyTrade{
+				{SecurityCode: "SBER", Account: "broker", Volume: 5, OpenPrice: 150, ClosePrice: 180, OpenComission: 5},
+				{SecurityCode: "SBER", Account: "broker", Volume: 5, OpenPrice: 150, ClosePrice: 180, OpenComission: 5},
+				{SecurityCode: "SBER", Account: "iis", Volume: 5, OpenPrice: 150, ClosePrice: 180, OpenComission: 5},
+				{SecurityCode: "SBER", Account: "iis", Volume: 5, OpenPrice: 150, ClosePrice: 180, OpenComission: 5},
+			},
+		},
+	}
+	for _, test := range tests {
+		var transfer = transfer
+		transfer.Volume = test.volume
+		var result, anomalies = applyTransfersAndCorporateActions(test.trades, nil,
+			[]core.SecurityTransfer{transfer}, testDate(2023, 12, 31), corporateActionRules{tax: true, method: test.method})
+		if len(anomalies) != 0 {
+			t.Errorf("%v: unexpected anomalies %+v", test.name, anomalies)
+		}
+		var lots = matchLots(result, test.method)
+		checkClosedTrades(t, test.name, lots.Closed, test.closed)
+		checkOpenLots(t, test.name, lots.Open, nil)
+	}
+}
+
+// Перенесенная часть лота получает свой TradeId, остаток на исходном счете - прежний.
+func TestApplyTransfersSplitLotTradeId(t *testing.T) {
+	var tt = []core.MyTrade{
+		{SecurityCode: "SBER", ExecutionDate: testDate(2022, 1, 10), Price: 100, Volume: 10, Account: "broker",
+			TradeId: "7", BrokerComission: 10},
+	}
+	var transfers = []core.SecurityTransfer{{Date: testDate(2022, 9, 1), SecurityCode: "SBER", Volume: 4,
+		FromAccount: "broker", ToAccount: "iis"}}
+	var result, anomalies = applyTransfersAndCorporateActions(tt, nil, transfers, testDate(2023, 12, 31),
+		corporateActionRules{tax: true, method: CostBasisSpecific})
+	if len(anomalies) != 0 || len(result) != 2 {
+		t.Fatalf("got %+v %+v", result, anomalies)
+	}
+	var source, moved = result[0], result[1]
+	if source.Account != "broker" || source.TradeId != "7" || source.Volume != 6 || !sameAmount(source.BrokerComission, 6) {
+		t.Errorf("source got %+v", source)
+	}
+	if moved.Account != "iis" || moved.TradeId != "7/2022-09-01" || moved.Volume != 4 || !sameAmount(moved.BrokerComission, 4) {
+		t.Errorf("moved got %+v", moved)
+	}
+	if findLot([]core.MyTrade{source, moved}, "7") != 0 {
+		t.Error("lot 7 matches moved part")
+	}
+}